@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Account modes. Paper accounts trade against the simulator; live accounts
+// are reserved for orders routed to a real broker, which doesn't exist yet.
+const (
+	accountModePaper = "paper"
+	accountModeLive  = "live"
+)
+
+// AccountModeRequest switches a user between paper and live trading
+type AccountModeRequest struct {
+	Mode string `json:"mode"`
+}
+
+// checkAccountMode is where live orders will branch off to a broker. Until
+// there is one they are refused, so nothing placed from a live account is
+// ever filled by the simulator.
+func (s *Server) checkAccountMode(userID uint) *orderError {
+	var users []User
+	if err := s.db.Select("account_mode").Where("id = ?", userID).Limit(1).Find(&users).Error; err != nil {
+		return &orderError{Status: 500, Code: orderCodeInternal, Message: "Failed to fetch account"}
+	}
+	if len(users) > 0 && users[0].AccountMode == accountModeLive {
+		return &orderError{Status: 501, Code: orderCodeLiveUnavailable, Message: "Live trading is not available yet"}
+	}
+	return nil
+}
+
+// checkAccountAge refuses orders from accounts younger than minAccountAge,
+// so freshly created bot accounts can't trade straight away. Users created
+// before sign-up times were recorded have no age and are let through.
+func (s *Server) checkAccountAge(userID uint) *orderError {
+	if s.minAccountAge == 0 {
+		return nil
+	}
+	var users []User
+	if err := s.db.Select("created_at").Where("id = ?", userID).Limit(1).Find(&users).Error; err != nil {
+		return &orderError{Status: 500, Code: orderCodeInternal, Message: "Failed to fetch account"}
+	}
+	if len(users) == 0 || users[0].CreatedAt.IsZero() {
+		return nil
+	}
+	allowedAt := users[0].CreatedAt.Add(s.minAccountAge)
+	if remaining := time.Until(allowedAt); remaining > 0 {
+		message := fmt.Sprintf("New accounts can trade after %s; try again in %s", s.minAccountAge, remaining.Round(time.Second))
+		return &orderError{Status: 403, Code: orderCodeAccountTooNew, Message: message, RetryAt: allowedAt}
+	}
+	return nil
+}
+
+// Profile is the authenticated user's account, as returned by GET /api/me
+type Profile struct {
+	User
+
+	// ImpersonatedBy is the admin using the token, so the UI can show
+	// that someone else is acting as the user
+	ImpersonatedBy uint `json:"impersonated_by,omitempty"`
+}
+
+// getProfile returns the authenticated user's account
+func (s *Server) getProfile(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	var users []User
+	if err := s.db.Where("id = ?", userID).Limit(1).Find(&users).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to fetch user"})
+		return
+	}
+	if len(users) == 0 {
+		c.JSON(404, gin.H{"error": "User not found"})
+		return
+	}
+	profile := Profile{User: users[0]}
+	if adminID, ok := c.Get("impersonated_by"); ok {
+		profile.ImpersonatedBy = adminID.(uint)
+	}
+	c.JSON(200, profile)
+}
+
+// setAccountMode switches a user between paper and live trading
+func (s *Server) setAccountMode(c *gin.Context) {
+	adminID, _ := c.Get("user_id")
+	userID, ok := s.entitlementsUser(c)
+	if !ok {
+		return
+	}
+
+	var req AccountModeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request"})
+		return
+	}
+	mode := strings.ToLower(strings.TrimSpace(req.Mode))
+	if mode != accountModePaper && mode != accountModeLive {
+		c.JSON(400, gin.H{"error": "mode must be 'paper' or 'live'"})
+		return
+	}
+
+	var user User
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&User{}).Where("id = ?", userID).Update("account_mode", mode).Error; err != nil {
+			return err
+		}
+		if err := recordAudit(tx, adminID.(uint), "user.account_mode", "user", userID); err != nil {
+			return err
+		}
+		return tx.First(&user, userID).Error
+	})
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to update account mode"})
+		return
+	}
+
+	c.JSON(200, user)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// ageAccount backdates the user's sign-up time to age ago
+func ageAccount(t *testing.T, s *Server, user User, age time.Duration) time.Time {
+	t.Helper()
+	createdAt := time.Now().Add(-age)
+	if err := s.db.Model(&user).Update("created_at", createdAt).Error; err != nil {
+		t.Fatal(err)
+	}
+	return createdAt
+}
+
+func TestMinAccountAge(t *testing.T) {
+	s := newTestServer(t, map[string]string{"MIN_ACCOUNT_AGE": "1h"})
+	order := OrderRequest{Symbol: "AAPL", Side: sideBuy, Quantity: 1}
+
+	young := createTestUser(t, s, "young", roleUser)
+	createdAt := ageAccount(t, s, young, time.Hour-5*time.Second)
+	_, err := s.placeOrder(young.ID, false, order)
+	if err == nil || err.Status != 403 || err.Code != orderCodeAccountTooNew {
+		t.Fatalf("account just under the minimum age: %v, want %s", err, orderCodeAccountTooNew)
+	}
+	if want := createdAt.Add(time.Hour); !err.RetryAt.Equal(want) {
+		t.Fatalf("retry at %v, want %v", err.RetryAt, want)
+	}
+
+	old := createTestUser(t, s, "old", roleUser)
+	ageAccount(t, s, old, time.Hour+time.Second)
+	if _, err := s.placeOrder(old.ID, false, order); err != nil {
+		t.Fatalf("account just over the minimum age: %s", err.Message)
+	}
+
+	// Admins aren't held back
+	admin := createTestUser(t, s, "new-admin", roleAdmin)
+	if _, err := s.placeOrder(admin.ID, true, order); err != nil {
+		t.Fatalf("new admin: %s", err.Message)
+	}
+}
+
+func TestMinAccountAgeOff(t *testing.T) {
+	s := newTestServer(t, nil)
+	user := createTestUser(t, s, "trader", roleUser)
+
+	if _, err := s.placeOrder(user.ID, false, OrderRequest{Symbol: "AAPL", Side: sideBuy, Quantity: 1}); err != nil {
+		t.Fatalf("new account with no minimum age: %s", err.Message)
+	}
+}
+
+// profileMode fetches the user's account mode from GET /api/me
+func profileMode(t *testing.T, r http.Handler, token string) string {
+	t.Helper()
+	w := doRequest(r, "GET", "/api/me", token, "")
+	if w.Code != 200 {
+		t.Fatalf("profile: status %d: %s", w.Code, w.Body)
+	}
+	var profile Profile
+	if err := json.Unmarshal(w.Body.Bytes(), &profile); err != nil {
+		t.Fatal(err)
+	}
+	return profile.AccountMode
+}
+
+func TestAccountMode(t *testing.T) {
+	s, r := newTestRouter(t, nil)
+	admin := seededAdmin(t, s)
+	_, adminToken := createTestSession(t, s, admin)
+	user := createTestUser(t, s, "trader", roleUser)
+	_, token := createTestSession(t, s, user)
+	order := `{"symbol":"AAPL","side":"buy","quantity":1}`
+	path := "/api/admin/users/" + jsonNumber(user.ID) + "/account-mode"
+
+	// New accounts trade on paper
+	if mode := profileMode(t, r, token); mode != accountModePaper {
+		t.Fatalf("new account mode = %q, want %q", mode, accountModePaper)
+	}
+	if w := doRequest(r, "POST", "/api/orders", token, order); w.Code != 201 {
+		t.Fatalf("paper order: status %d: %s", w.Code, w.Body)
+	}
+
+	if w := doRequest(r, "POST", path, adminToken, `{"mode":" Live "}`); w.Code != 200 {
+		t.Fatalf("switching to live: status %d: %s", w.Code, w.Body)
+	}
+	if mode := profileMode(t, r, token); mode != accountModeLive {
+		t.Fatalf("account mode = %q, want %q", mode, accountModeLive)
+	}
+	w := doRequest(r, "POST", "/api/orders", token, order)
+	if w.Code != 501 || !jsonHasCode(w.Body.Bytes(), orderCodeLiveUnavailable) {
+		t.Fatalf("live order: status %d: %s, want 501 %s", w.Code, w.Body, orderCodeLiveUnavailable)
+	}
+	var count int64
+	s.db.Model(&Order{}).Where("user_id = ?", user.ID).Count(&count)
+	if count != 1 {
+		t.Fatalf("user has %d orders, want only the paper one", count)
+	}
+
+	var entries []AuditLog
+	s.db.Where("action = ?", "user.account_mode").Find(&entries)
+	if len(entries) != 1 || entries[0].ActorID != admin.ID {
+		t.Fatalf("audit entries = %+v, want one by admin %d", entries, admin.ID)
+	}
+
+	if w := doRequest(r, "POST", path, adminToken, `{"mode":"paper"}`); w.Code != 200 {
+		t.Fatalf("switching back to paper: status %d: %s", w.Code, w.Body)
+	}
+	if w := doRequest(r, "POST", "/api/orders", token, order); w.Code != 201 {
+		t.Fatalf("paper order after switching back: status %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestAccountModeValidation(t *testing.T) {
+	s, r := newTestRouter(t, nil)
+	_, adminToken := createTestSession(t, s, seededAdmin(t, s))
+	user := createTestUser(t, s, "trader", roleUser)
+	_, token := createTestSession(t, s, user)
+	path := "/api/admin/users/" + jsonNumber(user.ID) + "/account-mode"
+
+	for _, body := range []string{`{"mode":"margin"}`, `{"mode":""}`, `{}`} {
+		if w := doRequest(r, "POST", path, adminToken, body); w.Code != 400 {
+			t.Fatalf("mode %s: status %d, want 400", body, w.Code)
+		}
+	}
+	if mode := profileMode(t, r, token); mode != accountModePaper {
+		t.Fatalf("account mode = %q after rejected changes, want %q", mode, accountModePaper)
+	}
+
+	// Only admins can switch accounts
+	if w := doRequest(r, "POST", path, token, `{"mode":"live"}`); w.Code != 403 {
+		t.Fatalf("user switching own mode: status %d, want 403", w.Code)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AdminOrder is an order as admins see it, with the internal id that the
+// audit log and POST /api/admin/orders/:id/cancel use
+type AdminOrder struct {
+	ID uint `json:"id"`
+	Order
+}
+
+// MarshalJSON encodes the order with Order.MarshalJSON and puts its id in
+// front. Without it the embedded Order's method would be promoted and drop
+// the id.
+func (o AdminOrder) MarshalJSON() ([]byte, error) {
+	order, err := o.Order.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	encoded := strconv.AppendUint([]byte(`{"id":`), uint64(o.ID), 10)
+	return append(append(encoded, ','), order[1:]...), nil
+}
+
+// AdminOrderPage is one page of orders across all users
+type AdminOrderPage struct {
+	Orders []AdminOrder `json:"orders"`
+	Total  int64        `json:"total"` // Orders matching the filters, across all pages
+	Limit  int          `json:"limit"`
+	Offset int          `json:"offset"`
+}
+
+// listAllOrders returns a page of every user's orders, newest first,
+// optionally filtered by ?user_id=, ?symbol=, ?side=, ?status= and a
+// ?from= / ?to= time range (RFC 3339, from inclusive, to exclusive).
+// Archived orders are included.
+func (s *Server) listAllOrders(c *gin.Context) {
+	requested, ok := s.pagination.parsePage(c)
+	if !ok {
+		return
+	}
+
+	query := s.db.Model(&Order{})
+	if raw := c.Query("user_id"); raw != "" {
+		userID, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil || userID == 0 {
+			c.JSON(400, gin.H{"error": "Invalid user_id"})
+			return
+		}
+		query = query.Where("user_id = ?", userID)
+	}
+	if symbol := strings.ToUpper(strings.TrimSpace(s.resolveSymbol(c.Query("symbol")))); symbol != "" {
+		query = query.Where("symbol = ?", symbol)
+	}
+	if raw := c.Query("side"); raw != "" {
+		side, ok := sideAliases[strings.ToLower(raw)]
+		if !ok {
+			c.JSON(400, gin.H{"error": "Invalid side"})
+			return
+		}
+		query = query.Where("side = ?", side)
+	}
+	if status := strings.ToLower(c.Query("status")); status != "" {
+		if _, ok := orderTransitions[status]; !ok {
+			c.JSON(400, gin.H{"error": "Invalid status"})
+			return
+		}
+		query = query.Where("status = ?", status)
+	}
+	bounds := []struct{ param, cond string }{
+		{"from", "timestamp >= ?"},
+		{"to", "timestamp < ?"},
+	}
+	for _, bound := range bounds {
+		raw := c.Query(bound.param)
+		if raw == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			c.JSON(400, gin.H{"error": bound.param + " must be an RFC 3339 time, e.g. 2024-01-02T15:04:05Z"})
+			return
+		}
+		// Timestamps are stored in server local time
+		query = query.Where(bound.cond, t.Local())
+	}
+
+	page := AdminOrderPage{Orders: []AdminOrder{}, Limit: requested.Limit, Offset: requested.Offset}
+	if err := query.Count(&page.Total).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to fetch orders"})
+		return
+	}
+	var orders []Order
+	if err := query.Order("timestamp DESC, id DESC").Limit(page.Limit).Offset(page.Offset).Find(&orders).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to fetch orders"})
+		return
+	}
+	page.Orders = make([]AdminOrder, 0, len(orders))
+	for _, order := range orders {
+		page.Orders = append(page.Orders, AdminOrder{ID: order.ID, Order: order})
+	}
+
+	c.JSON(200, page)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestAdminOrderEncoding(t *testing.T) {
+	s, r := newTestRouter(t, nil)
+	admin := seededAdmin(t, s)
+	_, token := createTestSession(t, s, admin)
+	user := createTestUser(t, s, "trader", roleUser)
+	order := createFilledOrder(t, s, user.ID, "AAPL", sideBuy, 3, 100.125)
+
+	w := doRequest(r, "GET", "/api/admin/orders", token, "")
+	if w.Code != 200 {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	var page struct {
+		Orders []map[string]json.RawMessage `json:"orders"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil || len(page.Orders) != 1 {
+		t.Fatalf("page = %s", w.Body)
+	}
+
+	// Everything the user sees, plus the id
+	listed := page.Orders[0]
+	own, err := json.Marshal(order)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(own, &fields); err != nil {
+		t.Fatal(err)
+	}
+	for key, value := range fields {
+		if !bytes.Equal(listed[key], value) {
+			t.Errorf("%s = %s, want %s as in the user's view", key, listed[key], value)
+		}
+	}
+	if string(listed["id"]) != "1" {
+		t.Errorf("id = %s, want 1", listed["id"])
+	}
+	if string(listed["notional"]) != "300.38" {
+		t.Errorf("notional = %s, want 300.38", listed["notional"])
+	}
+}
+
+func TestAdminRoutesRefuseNonAdmins(t *testing.T) {
+	s, r := newTestRouter(t, nil)
+	user := createTestUser(t, s, "trader", roleUser)
+	_, token := createTestSession(t, s, user)
+	admin := seededAdmin(t, s)
+	_, adminToken := createTestSession(t, s, admin)
+
+	routes := []struct{ method, path, body string }{
+		{"GET", "/api/admin/orders", ""},
+		{"GET", "/api/admin/orders?user_id=1&side=buy", ""},
+		{"POST", "/api/admin/orders/1/cancel", `{}`},
+		{"GET", "/api/admin/users", ""},
+		{"GET", "/api/admin/maintenance", ""},
+		{"POST", "/api/admin/maintenance", `{"enabled":true}`},
+		{"POST", "/api/admin/users/1/entitlements", `{"symbols":["AAPL"]}`},
+	}
+	for _, route := range routes {
+		if w := doRequest(r, route.method, route.path, token, route.body); w.Code != 403 {
+			t.Errorf("%s %s as a user: status %d, want 403", route.method, route.path, w.Code)
+		}
+	}
+	if s.maintenance.Load() {
+		t.Fatal("a user turned maintenance mode on")
+	}
+
+	if w := doRequest(r, "GET", "/api/admin/orders", "", ""); w.Code != 401 {
+		t.Errorf("without a token: status %d, want 401", w.Code)
+	}
+	if w := doRequest(r, "GET", "/api/admin/orders", adminToken, ""); w.Code != 200 {
+		t.Errorf("as an admin: status %d, want 200", w.Code)
+	}
+}
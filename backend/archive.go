@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// defaultArchiveInterval is how often the archival job runs when enabled
+const defaultArchiveInterval = time.Hour
+
+// retentionPolicy decides which orders are moved out of the default order
+// history. Archived orders are kept and still count towards P&L and summaries.
+type retentionPolicy struct {
+	// MaxOrders keeps only each user's newest orders unarchived; 0 disables it
+	MaxOrders int
+
+	// MaxAge archives orders older than this; 0 disables it
+	MaxAge time.Duration
+
+	// Interval is how often the archival job runs
+	Interval time.Duration
+}
+
+// enabled reports whether any retention limit is configured
+func (p retentionPolicy) enabled() bool {
+	return p.MaxOrders > 0 || p.MaxAge > 0
+}
+
+// loadRetentionPolicy reads the order retention settings from the environment
+func loadRetentionPolicy() (retentionPolicy, error) {
+	var policy retentionPolicy
+	var err error
+
+	if policy.MaxOrders, err = envInt("ORDER_RETENTION_COUNT", 0); err != nil {
+		return policy, err
+	}
+	if policy.MaxOrders < 0 {
+		return policy, fmt.Errorf("ORDER_RETENTION_COUNT must not be negative")
+	}
+	if policy.MaxAge, err = envDuration("ORDER_RETENTION_AGE", 0); err != nil {
+		return policy, err
+	}
+	if policy.MaxAge < 0 {
+		return policy, fmt.Errorf("ORDER_RETENTION_AGE must not be negative")
+	}
+	if policy.Interval, err = envDuration("ORDER_ARCHIVE_INTERVAL", defaultArchiveInterval); err != nil {
+		return policy, err
+	}
+	if policy.Interval <= 0 {
+		return policy, fmt.Errorf("ORDER_ARCHIVE_INTERVAL must be positive")
+	}
+
+	return policy, nil
+}
+
+// archiveJob archives orders on the retention policy's interval. It runs
+// once at startup so a tightened policy takes effect immediately.
+func (s *Server) archiveJob() job {
+	return job{
+		name:       "archive-orders",
+		interval:   s.retention.Interval,
+		runAtStart: true,
+		run: func(context.Context) {
+			if n, err := s.archiveOrders(time.Now()); err != nil {
+				log.Printf("Error archiving orders: %v", err)
+			} else if n > 0 {
+				log.Printf("Archived %d orders", n)
+			}
+		},
+	}
+}
+
+// archiveOrders marks every order that falls outside the retention policy
+// as archived and returns how many orders it archived
+func (s *Server) archiveOrders(now time.Time) (int64, error) {
+	var archived int64
+
+	if s.retention.MaxAge > 0 {
+		res := s.db.Model(&Order{}).
+			Where("archived = ? AND timestamp < ?", false, now.Add(-s.retention.MaxAge)).
+			Where("status NOT IN ?", workingOrderStatuses).
+			Update("archived", true)
+		if res.Error != nil {
+			return archived, res.Error
+		}
+		archived += res.RowsAffected
+	}
+
+	if s.retention.MaxOrders > 0 {
+		// Rank each user's live orders newest first and archive the overflow.
+		// Working orders count towards the limit but are never archived.
+		res := s.db.Exec(`UPDATE orders SET archived = ? WHERE status NOT IN ? AND id IN (
+			SELECT id FROM (
+				SELECT id, ROW_NUMBER() OVER (PARTITION BY user_id ORDER BY timestamp DESC, id DESC) AS row_num
+				FROM orders WHERE archived = ?
+			) WHERE row_num > ?
+		)`, true, workingOrderStatuses, false, s.retention.MaxOrders)
+		if res.Error != nil {
+			return archived, res.Error
+		}
+		archived += res.RowsAffected
+	}
+
+	return archived, nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestArchivedFillsStillCount(t *testing.T) {
+	s, r := newTestRouter(t, map[string]string{"ORDER_RETENTION_COUNT": "1"})
+	user := createTestUser(t, s, "trader", roleUser)
+	_, token := createTestSession(t, s, user)
+	createFilledOrder(t, s, user.ID, "AAPL", sideBuy, 3, 100)
+	createFilledOrder(t, s, user.ID, "AAPL", sideBuy, 2, 110)
+
+	// Warm the cache so a stale entry would show
+	if net := netPosition(t, s, user.ID, "AAPL"); net != 5 {
+		t.Fatalf("net = %g, want 5", net)
+	}
+	if n, err := s.archiveOrders(time.Now()); err != nil || n != 1 {
+		t.Fatalf("archiveOrders = %d, %v, want 1", n, err)
+	}
+
+	// The order history hides the archived buy
+	w := doRequest(r, "GET", "/api/orders", token, "")
+	var orders []Order
+	if err := json.Unmarshal(w.Body.Bytes(), &orders); w.Code != 200 || err != nil || len(orders) != 1 {
+		t.Fatalf("orders = %s, want just the newest", w.Body)
+	}
+
+	// But positions, computed afresh or not, and the summary still hold it
+	if net := netPosition(t, s, user.ID, "AAPL"); net != 5 {
+		t.Fatalf("cached net after archival = %g, want 5", net)
+	}
+	s.positionsCache.invalidate(user.ID)
+	if net := netPosition(t, s, user.ID, "AAPL"); net != 5 {
+		t.Fatalf("net after archival = %g, want 5", net)
+	}
+	w = doRequest(r, "GET", "/api/me/summary", token, "")
+	var summary TradeSummary
+	if err := json.Unmarshal(w.Body.Bytes(), &summary); err != nil || summary.TotalOrders != 2 || summary.BuyVolume != 5 {
+		t.Fatalf("summary after archival = %s", w.Body)
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// AuditLog records an action an admin took on someone else's behalf
+type AuditLog struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	ActorID    uint      `gorm:"not null;index" json:"actor_id"` // The admin who acted
+	Action     string    `gorm:"not null" json:"action"`         // e.g. "order.cancel"
+	TargetType string    `gorm:"not null" json:"target_type"`    // e.g. "order"
+	TargetID   uint      `gorm:"not null" json:"target_id"`
+	CreatedAt  time.Time `gorm:"index" json:"created_at"`
+}
+
+// recordAudit writes an audit entry in tx, so it commits or rolls back
+// together with the action it describes
+func recordAudit(tx *gorm.DB, actorID uint, action, targetType string, targetID uint) error {
+	return tx.Create(&AuditLog{
+		ActorID:    actorID,
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+	}).Error
+}
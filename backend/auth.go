@@ -0,0 +1,299 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// jwtIssuer is the "iss" claim stamped on and required of every token
+	jwtIssuer = "trading-dashboard"
+
+	// tokenTTL is how long an issued token stays valid
+	tokenTTL = 24 * time.Hour
+
+	// defaultTokenLeeway tolerates small clock skew when checking exp/nbf
+	defaultTokenLeeway = 30 * time.Second
+
+	// defaultJWTKeyID is the kid of JWT_SECRET when JWT_KEY_ID isn't set
+	defaultJWTKeyID = "default"
+
+	// Impersonation tokens live for IMPERSONATION_TTL, by default
+	// defaultImpersonationTTL and never more than maxImpersonationTTL
+	defaultImpersonationTTL = 15 * time.Minute
+	maxImpersonationTTL     = time.Hour
+)
+
+// authCookieName is the cookie holding the token in cookie auth mode
+const authCookieName = "token"
+
+// jwtLeeway is the clock skew allowed when validating token times
+var jwtLeeway = defaultTokenLeeway
+
+// jwtNow is the clock token times are checked against; tests replace it
+var jwtNow = time.Now
+
+// jwtKeyID is the kid stamped on tokens signed with jwtSecret
+var jwtKeyID = defaultJWTKeyID
+
+// jwtPreviousKeys are retired secrets by kid. Tokens they signed are still
+// accepted, so sessions survive a rotation until they expire.
+var jwtPreviousKeys = map[string][]byte{}
+
+// Error codes returned by authMiddleware so clients can tell an expired
+// session (log in again) from a malformed or tampered token
+const (
+	authCodeMissing     = "AUTH_REQUIRED"
+	authCodeExpired     = "TOKEN_EXPIRED"
+	authCodeNotYetValid = "TOKEN_NOT_YET_VALID"
+	authCodeMalformed   = "TOKEN_MALFORMED"
+	authCodeInvalid     = "TOKEN_INVALID"
+	authCodeRevoked     = "SESSION_REVOKED"
+	authCodeReadOnly    = "IMPERSONATION_READ_ONLY"
+)
+
+// errInvalidClaims is returned for correctly signed tokens whose claims are
+// missing or of the wrong type
+var errInvalidClaims = errors.New("invalid token claims")
+
+// errUnknownKey is returned for tokens whose kid isn't in the active key set,
+// e.g. one signed with a key that has since been retired
+var errUnknownKey = errors.New("unknown signing key")
+
+// tokenClaims are the validated claims extracted from a token
+type tokenClaims struct {
+	UserID   uint
+	Username string
+	Role     string
+
+	// TokenID is the jti of the token's session; empty for tokens issued
+	// before sessions were tracked
+	TokenID string
+
+	// ImpersonatedBy is the admin acting as the user; 0 when the user
+	// signed in themselves
+	ImpersonatedBy uint
+}
+
+// issueToken signs a new HS256 token for the user's session, valid for the
+// session's lifetime, with the current key
+func issueToken(user User, session Session) (string, error) {
+	claims := jwt.MapClaims{
+		"iss":      jwtIssuer,
+		"jti":      session.TokenID,
+		"user_id":  user.ID,
+		"username": user.Username,
+		"role":     user.Role,
+		"iat":      session.IssuedAt.Unix(),
+		"nbf":      session.IssuedAt.Unix(),
+		"exp":      session.ExpiresAt.Unix(),
+	}
+	if session.ImpersonatedBy != 0 {
+		claims["impersonated_by"] = session.ImpersonatedBy
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	token.Header["kid"] = jwtKeyID
+	return token.SignedString(jwtSecret)
+}
+
+// verificationKey returns the key a token's signature is checked against:
+// the current or a previous key, chosen by its kid header. Tokens issued
+// before kids were stamped may match any of them.
+func verificationKey(token *jwt.Token) (interface{}, error) {
+	kid, hasKid := token.Header["kid"]
+	if !hasKid {
+		set := jwt.VerificationKeySet{Keys: []jwt.VerificationKey{jwtSecret}}
+		for _, key := range jwtPreviousKeys {
+			set.Keys = append(set.Keys, key)
+		}
+		return set, nil
+	}
+
+	id, ok := kid.(string)
+	if !ok {
+		return nil, errUnknownKey
+	}
+	if id == jwtKeyID {
+		return jwtSecret, nil
+	}
+	if key, ok := jwtPreviousKeys[id]; ok {
+		return key, nil
+	}
+	return nil, errUnknownKey
+}
+
+// parseToken verifies a token and returns its claims. Only HS256 is
+// accepted, so "none" and other HMAC variants can't be substituted, and the
+// issuer and expiry claims must be present. exp and nbf (when set) are
+// checked with jwtLeeway of tolerance. The signature may be from any key in
+// the active set.
+func parseToken(tokenString string) (tokenClaims, error) {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, jwt.ErrSignatureInvalid
+		}
+		return verificationKey(token)
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithIssuer(jwtIssuer),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(jwtLeeway),
+		jwt.WithTimeFunc(jwtNow),
+	)
+	if err != nil {
+		return tokenClaims{}, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return tokenClaims{}, errInvalidClaims
+	}
+
+	// JSON numbers decode as float64; reject anything that isn't a positive id
+	userID, ok := claims["user_id"].(float64)
+	if !ok || userID < 1 || userID != math.Trunc(userID) {
+		return tokenClaims{}, errInvalidClaims
+	}
+	username, _ := claims["username"].(string)
+
+	// Tokens issued before roles existed carry no role claim
+	role, _ := claims["role"].(string)
+	if role == "" {
+		role = roleUser
+	}
+
+	tokenID, _ := claims["jti"].(string)
+
+	parsed := tokenClaims{UserID: uint(userID), Username: username, Role: role, TokenID: tokenID}
+	if raw, ok := claims["impersonated_by"]; ok {
+		adminID, ok := raw.(float64)
+		if !ok || adminID < 1 || adminID != math.Trunc(adminID) {
+			return tokenClaims{}, errInvalidClaims
+		}
+		parsed.ImpersonatedBy = uint(adminID)
+	}
+	return parsed, nil
+}
+
+// tokenErrorCode maps a parseToken error to the code reported to clients
+func tokenErrorCode(err error) (code, message string) {
+	switch {
+	case errors.Is(err, jwt.ErrTokenExpired):
+		return authCodeExpired, "Token has expired"
+	case errors.Is(err, jwt.ErrTokenNotValidYet):
+		return authCodeNotYetValid, "Token is not valid yet"
+	case errors.Is(err, jwt.ErrTokenMalformed):
+		return authCodeMalformed, "Malformed token"
+	case errors.Is(err, errInvalidClaims):
+		return authCodeInvalid, "Invalid token claims"
+	case errors.Is(err, errSessionRevoked):
+		return authCodeRevoked, "Session has been revoked"
+	default:
+		return authCodeInvalid, "Invalid or expired token"
+	}
+}
+
+// impersonationReadOnlyMessage refuses changes made with an impersonation
+// token, which nothing would attribute to the admin behind it
+const impersonationReadOnlyMessage = "Impersonation sessions are read-only"
+
+// parseSameSite reads an AUTH_COOKIE_SAMESITE value; the default is Lax
+func parseSameSite(raw string) (http.SameSite, error) {
+	switch strings.ToLower(strings.TrimSpace(raw)) {
+	case "", "lax":
+		return http.SameSiteLaxMode, nil
+	case "strict":
+		return http.SameSiteStrictMode, nil
+	case "none":
+		return http.SameSiteNoneMode, nil
+	default:
+		return 0, fmt.Errorf("AUTH_COOKIE_SAMESITE must be lax, strict or none")
+	}
+}
+
+// setAuthCookie stores token in an HttpOnly cookie that expires with it,
+// when cookie auth is enabled
+func (s *Server) setAuthCookie(c *gin.Context, token string) {
+	if !s.authCookie {
+		return
+	}
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     authCookieName,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   int(tokenTTL.Seconds()),
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: s.authCookieSameSite,
+	})
+}
+
+// logout ends the session of the token in the Authorization header or auth
+// cookie, which revokes the token, and clears the cookie
+func (s *Server) logout(c *gin.Context) {
+	tokenString, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !ok {
+		tokenString = s.cookieToken(c)
+	}
+	if tokenString != "" {
+		s.endSession(tokenString)
+	}
+
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     authCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: s.authCookieSameSite,
+	})
+	c.Status(204)
+}
+
+// cookieToken returns the token from the auth cookie, if cookie auth is on
+func (s *Server) cookieToken(c *gin.Context) string {
+	if !s.authCookie {
+		return ""
+	}
+	token, _ := c.Cookie(authCookieName)
+	return token
+}
+
+// requireAdmin rejects requests from non-admin users. It must run after
+// authMiddleware, which sets the role from the token.
+func (s *Server) requireAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if role, _ := c.Get("role"); role != roleAdmin {
+			c.JSON(403, gin.H{"error": "Admin access required"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// originAllowed reports whether a cookie-authenticated WebSocket handshake
+// comes from the API's own origin or one in ALLOWED_ORIGINS. Browsers always
+// send Origin on WebSocket handshakes, so a request without one isn't a
+// cross-site page riding on the cookie.
+func (s *Server) originAllowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if slices.Contains(s.allowedOrigins, origin) {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
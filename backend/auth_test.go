@@ -0,0 +1,312 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testClaims are the claims of a valid token for user 7
+func testClaims() jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"iss":      jwtIssuer,
+		"user_id":  7,
+		"username": "trader",
+		"role":     roleUser,
+		"iat":      now.Unix(),
+		"exp":      now.Add(time.Hour).Unix(),
+	}
+}
+
+// signTestToken signs claims with key, setting the kid header unless kid is
+// nil
+func signTestToken(t *testing.T, method jwt.SigningMethod, key interface{}, kid interface{}, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	if kid != nil {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("signing token: %v", err)
+	}
+	return signed
+}
+
+// rotatedKeys configures "test-secret" as the current key, v2, after a
+// rotation from "old-secret", v1
+func rotatedKeys(t *testing.T) {
+	newTestConfig(t, map[string]string{
+		"JWT_KEY_ID":          "v2",
+		"JWT_PREVIOUS_SECRET": "old-secret",
+		"JWT_PREVIOUS_KEY_ID": "v1",
+	})
+}
+
+func TestPreviousKeyValidatesDuringOverlap(t *testing.T) {
+	rotatedKeys(t)
+
+	for name, key := range map[string]string{"v1": "old-secret", "v2": "test-secret"} {
+		token := signTestToken(t, jwt.SigningMethodHS256, []byte(key), name, testClaims())
+		claims, err := parseToken(token)
+		if err != nil {
+			t.Fatalf("token signed with %s: %v", name, err)
+		}
+		if claims.UserID != 7 {
+			t.Fatalf("token signed with %s: user_id = %d, want 7", name, claims.UserID)
+		}
+	}
+
+	// Tokens from before kids were stamped may be signed with either key
+	if _, err := parseToken(signTestToken(t, jwt.SigningMethodHS256, []byte("old-secret"), nil, testClaims())); err != nil {
+		t.Fatalf("token without kid: %v", err)
+	}
+
+	// New tokens are signed with the current key
+	token, err := issueToken(User{ID: 7, Username: "trader", Role: roleUser}, Session{IssuedAt: time.Now(), ExpiresAt: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, _, err := jwt.NewParser().ParseUnverified(token, jwt.MapClaims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Header["kid"] != "v2" {
+		t.Fatalf("kid = %v, want v2", parsed.Header["kid"])
+	}
+}
+
+func TestPreviousKeyRejectedAfterOverlap(t *testing.T) {
+	newTestConfig(t, map[string]string{"JWT_KEY_ID": "v2"})
+
+	token := signTestToken(t, jwt.SigningMethodHS256, []byte("old-secret"), "v1", testClaims())
+	if _, err := parseToken(token); !errors.Is(err, errUnknownKey) {
+		t.Fatalf("parseToken = %v, want errUnknownKey", err)
+	}
+}
+
+func TestUnknownKeyIDRejected(t *testing.T) {
+	rotatedKeys(t)
+
+	// Even signed with a known secret, a kid outside the active set fails
+	token := signTestToken(t, jwt.SigningMethodHS256, []byte("test-secret"), "v9", testClaims())
+	if _, err := parseToken(token); !errors.Is(err, errUnknownKey) {
+		t.Fatalf("parseToken = %v, want errUnknownKey", err)
+	}
+	if code, _ := tokenErrorCode(errUnknownKey); code != authCodeInvalid {
+		t.Fatalf("code = %s, want %s", code, authCodeInvalid)
+	}
+}
+
+func TestNonStringKeyIDRejected(t *testing.T) {
+	rotatedKeys(t)
+
+	for _, kid := range []interface{}{2, true, []string{"v2"}} {
+		token := signTestToken(t, jwt.SigningMethodHS256, []byte("test-secret"), kid, testClaims())
+		if _, err := parseToken(token); !errors.Is(err, errUnknownKey) {
+			t.Errorf("kid %v: parseToken = %v, want errUnknownKey", kid, err)
+		}
+	}
+}
+
+func TestTokenSignedUnderOtherKeyIDRejected(t *testing.T) {
+	rotatedKeys(t)
+
+	// The previous secret doesn't verify a token claiming the current kid
+	token := signTestToken(t, jwt.SigningMethodHS256, []byte("old-secret"), "v2", testClaims())
+	if _, err := parseToken(token); !errors.Is(err, jwt.ErrSignatureInvalid) {
+		t.Fatalf("parseToken = %v, want a signature error", err)
+	}
+}
+
+func TestOnlyHS256Accepted(t *testing.T) {
+	newTestConfig(t, nil)
+
+	none := signTestToken(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, jwtKeyID, testClaims())
+	if _, err := parseToken(none); err == nil {
+		t.Error(`token with alg "none" accepted`)
+	}
+
+	for _, method := range []jwt.SigningMethod{jwt.SigningMethodHS384, jwt.SigningMethodHS512} {
+		token := signTestToken(t, method, jwtSecret, jwtKeyID, testClaims())
+		if _, err := parseToken(token); !errors.Is(err, jwt.ErrTokenSignatureInvalid) {
+			t.Errorf("%s: parseToken = %v, want a signature error", method.Alg(), err)
+		}
+	}
+
+	if _, err := parseToken(signTestToken(t, jwt.SigningMethodHS256, jwtSecret, jwtKeyID, testClaims())); err != nil {
+		t.Fatalf("HS256: %v", err)
+	}
+}
+
+func TestRequiredClaims(t *testing.T) {
+	newTestConfig(t, nil)
+
+	tests := map[string]func(jwt.MapClaims){
+		"missing exp":        func(c jwt.MapClaims) { delete(c, "exp") },
+		"missing iss":        func(c jwt.MapClaims) { delete(c, "iss") },
+		"other iss":          func(c jwt.MapClaims) { c["iss"] = "someone-else" },
+		"missing user_id":    func(c jwt.MapClaims) { delete(c, "user_id") },
+		"string user_id":     func(c jwt.MapClaims) { c["user_id"] = "7" },
+		"zero user_id":       func(c jwt.MapClaims) { c["user_id"] = 0 },
+		"fractional user_id": func(c jwt.MapClaims) { c["user_id"] = 7.5 },
+		"bad impersonation":  func(c jwt.MapClaims) { c["impersonated_by"] = "admin" },
+	}
+	for name, mutate := range tests {
+		claims := testClaims()
+		mutate(claims)
+		if _, err := parseToken(signTestToken(t, jwt.SigningMethodHS256, jwtSecret, jwtKeyID, claims)); err == nil {
+			t.Errorf("%s: token accepted", name)
+		}
+	}
+}
+
+// freezeClock makes parseToken see now as the current time
+func freezeClock(t *testing.T, now time.Time) {
+	t.Helper()
+	jwtNow = func() time.Time { return now }
+	t.Cleanup(func() { jwtNow = time.Now })
+}
+
+func TestTokenTimesAllowLeeway(t *testing.T) {
+	newTestConfig(t, map[string]string{"JWT_LEEWAY": "30s"})
+
+	issued := time.Unix(1_700_000_000, 0)
+	claims := testClaims()
+	claims["nbf"] = issued.Unix()
+	claims["exp"] = issued.Add(time.Hour).Unix()
+	token := signTestToken(t, jwt.SigningMethodHS256, jwtSecret, jwtKeyID, claims)
+
+	tests := []struct {
+		name string
+		now  time.Time
+		code string // Empty when the token is valid
+	}{
+		{"before nbf, past the leeway", issued.Add(-31 * time.Second), authCodeNotYetValid},
+		{"before nbf, within the leeway", issued.Add(-30 * time.Second), ""},
+		{"at nbf", issued, ""},
+		{"at exp, within the leeway", issued.Add(time.Hour + 29*time.Second), ""},
+		{"after exp, past the leeway", issued.Add(time.Hour + 31*time.Second), authCodeExpired},
+	}
+	for _, tt := range tests {
+		freezeClock(t, tt.now)
+		_, err := parseToken(token)
+		switch {
+		case tt.code == "" && err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		case tt.code != "" && err == nil:
+			t.Errorf("%s: token accepted", tt.name)
+		case tt.code != "":
+			if code, _ := tokenErrorCode(err); code != tt.code {
+				t.Errorf("%s: code = %s, want %s", tt.name, code, tt.code)
+			}
+		}
+	}
+}
+
+func TestMalformedTokenCode(t *testing.T) {
+	newTestConfig(t, nil)
+
+	_, err := parseToken("not-a-token")
+	if code, _ := tokenErrorCode(err); code != authCodeMalformed {
+		t.Fatalf("code = %s, want %s", code, authCodeMalformed)
+	}
+}
+
+// cheapArgon2id is an argon2id hasher with parameters small enough for tests
+func cheapArgon2id() argon2idHasher {
+	return argon2idHasher{time: 1, memory: 64, threads: 1, keyLen: 32, saltLen: 16}
+}
+
+func TestLoginRehashesOlderSchemes(t *testing.T) {
+	cfg := newTestConfig(t, nil)
+	cfg.PasswordHasher = cheapArgon2id()
+	s := NewServer(cfg)
+	t.Cleanup(func() {
+		if sqlDB, err := s.db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	r, err := s.router(cfg)
+	if err != nil {
+		t.Fatalf("router: %v", err)
+	}
+
+	old, err := bcryptHasher{cost: bcrypt.MinCost}.Hash("Correct-Horse-9")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := createTestUser(t, s, "trader", roleUser)
+	s.db.Model(&user).Update("password", old)
+	storedHash := func() string {
+		var stored User
+		s.db.First(&stored, user.ID)
+		return stored.Password
+	}
+
+	// A wrong password leaves the old hash alone
+	if w := doRequest(r, "POST", "/api/login", "", `{"username":"trader","password":"wrong"}`); w.Code != 401 {
+		t.Fatalf("wrong password: status %d", w.Code)
+	}
+	if storedHash() != old {
+		t.Fatal("hash changed after a failed login")
+	}
+
+	// The bcrypt hash is upgraded to argon2id on the first successful login
+	if w := doRequest(r, "POST", "/api/login", "", `{"username":"trader","password":"Correct-Horse-9"}`); w.Code != 200 {
+		t.Fatalf("login: status %d: %s", w.Code, w.Body)
+	}
+	upgraded := storedHash()
+	if hashAlgorithm(upgraded) != hashArgon2id {
+		t.Fatalf("hash after login = %q, want argon2id", upgraded)
+	}
+	if err := cheapArgon2id().Compare(upgraded, "Correct-Horse-9"); err != nil {
+		t.Fatalf("upgraded hash doesn't verify: %v", err)
+	}
+
+	// and left alone after that
+	if w := doRequest(r, "POST", "/api/login", "", `{"username":"trader","password":"Correct-Horse-9"}`); w.Code != 200 {
+		t.Fatalf("second login: status %d: %s", w.Code, w.Body)
+	}
+	if storedHash() != upgraded {
+		t.Error("argon2id hash rehashed again")
+	}
+}
+
+func TestComparePassword(t *testing.T) {
+	bcryptHash, _ := bcryptHasher{cost: bcrypt.MinCost}.Hash("secret")
+	argonHash, _ := cheapArgon2id().Hash("secret")
+	// Hashes made with older argon2id parameters verify without a rehash
+	olderParams := cheapArgon2id()
+	olderParams.time = 2
+	olderHash, _ := olderParams.Hash("secret")
+
+	tests := []struct {
+		name      string
+		preferred PasswordHasher
+		hash      string
+		password  string
+		rehash    bool
+		wantErr   bool
+	}{
+		{"bcrypt preferred", bcryptHasher{cost: bcrypt.MinCost}, bcryptHash, "secret", false, false},
+		{"bcrypt to argon2id", cheapArgon2id(), bcryptHash, "secret", true, false},
+		{"argon2id to bcrypt", bcryptHasher{cost: bcrypt.MinCost}, argonHash, "secret", true, false},
+		{"argon2id preferred", cheapArgon2id(), argonHash, "secret", false, false},
+		{"older argon2id parameters", cheapArgon2id(), olderHash, "secret", false, false},
+		{"wrong password", cheapArgon2id(), bcryptHash, "guess", false, true},
+		{"unknown scheme", cheapArgon2id(), "plaintext", "plaintext", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rehash, err := comparePassword(tt.preferred, tt.hash, tt.password)
+			if (err != nil) != tt.wantErr || rehash != tt.rehash {
+				t.Fatalf("comparePassword = %v, %v; want rehash %v, error %v", rehash, err, tt.rehash, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Basket is a composite symbol, such as an index, whose price is the
+// weighted sum of its constituents' prices
+type Basket struct {
+	Symbol       string              `json:"symbol"`
+	Currency     string              `json:"currency"`
+	Constituents []basketConstituent `json:"constituents"`
+
+	// Tradable baskets accept orders; by default they are display only.
+	// This is the configured value; admins may halt or resume trading in
+	// the basket's stock since.
+	Tradable bool `json:"tradable"`
+}
+
+// basketConstituent is one stock in a basket and how many units of it the
+// basket holds
+type basketConstituent struct {
+	Symbol string  `json:"symbol"`
+	Weight float64 `json:"weight"`
+}
+
+// loadBaskets reads basket definitions from BASKETS, e.g.
+// "TECH=AAPL:0.5,TSLA:0.3,AMZN:0.2;ADR=INFY:1", where each weight is the
+// number of units of that stock in the basket. Constituents must be tracked
+// stocks quoted in the same currency. BASKETS_TRADABLE lists the baskets
+// that accept orders.
+func loadBaskets(stocks []Stock) ([]Basket, error) {
+	raw := strings.TrimSpace(os.Getenv("BASKETS"))
+	if raw == "" {
+		if strings.TrimSpace(os.Getenv("BASKETS_TRADABLE")) != "" {
+			return nil, fmt.Errorf("BASKETS_TRADABLE is set but no BASKETS are defined")
+		}
+		return nil, nil
+	}
+
+	index := make(map[string]Stock, len(stocks))
+	for _, stock := range stocks {
+		index[stock.Symbol] = stock
+	}
+
+	var baskets []Basket
+	seen := make(map[string]bool)
+	for _, def := range strings.Split(raw, ";") {
+		name, list, ok := strings.Cut(def, "=")
+		symbol := strings.ToUpper(strings.TrimSpace(name))
+		if !ok || symbol == "" {
+			return nil, fmt.Errorf("BASKETS: malformed basket %q, expected NAME=SYMBOL:WEIGHT,...", def)
+		}
+		if _, ok := index[symbol]; ok {
+			return nil, fmt.Errorf("BASKETS: %s is already a stock symbol", symbol)
+		}
+		if seen[symbol] {
+			return nil, fmt.Errorf("BASKETS: duplicate basket %s", symbol)
+		}
+		seen[symbol] = true
+
+		key := "BASKETS: " + symbol
+		entries, err := parseSymbolValues(key, list)
+		if err != nil {
+			return nil, err
+		}
+		if len(entries) == 0 {
+			return nil, fmt.Errorf("%s: needs at least one constituent", key)
+		}
+
+		basket := Basket{Symbol: symbol}
+		for _, entry := range entries {
+			stock, ok := index[entry.Symbol]
+			if !ok {
+				return nil, fmt.Errorf("%s: unknown symbol %s", key, entry.Symbol)
+			}
+			if entry.Value <= 0 || math.IsInf(entry.Value, 0) {
+				return nil, fmt.Errorf("%s: weight for %s must be a positive number", key, entry.Symbol)
+			}
+			if basket.Currency == "" {
+				basket.Currency = stock.Currency
+			} else if stock.Currency != basket.Currency {
+				return nil, fmt.Errorf("%s: constituents must share a currency, %s is quoted in %s", key, entry.Symbol, stock.Currency)
+			}
+			basket.Constituents = append(basket.Constituents, basketConstituent{Symbol: entry.Symbol, Weight: entry.Value})
+		}
+		baskets = append(baskets, basket)
+	}
+
+	for _, name := range strings.Split(os.Getenv("BASKETS_TRADABLE"), ",") {
+		symbol := strings.ToUpper(strings.TrimSpace(name))
+		if symbol == "" {
+			continue
+		}
+		i := slices.IndexFunc(baskets, func(b Basket) bool { return b.Symbol == symbol })
+		if i < 0 {
+			return nil, fmt.Errorf("BASKETS_TRADABLE: unknown basket %s", symbol)
+		}
+		baskets[i].Tradable = true
+	}
+
+	sort.Slice(baskets, func(i, j int) bool {
+		return baskets[i].Symbol < baskets[j].Symbol
+	})
+	return baskets, nil
+}
+
+// stock returns the tracked entry for the basket, priced from the given
+// constituent prices
+func (b Basket) stock(stocks []Stock) Stock {
+	prices := make(map[string]float64, len(stocks))
+	for _, stock := range stocks {
+		prices[stock.Symbol] = stock.Price
+	}
+	return Stock{
+		Symbol:        b.Symbol,
+		Name:          b.Symbol,
+		Price:         b.value(prices),
+		Currency:      b.Currency,
+		PriceDecimals: defaultPriceDecimals,
+		Basket:        true,
+		Tradable:      b.Tradable,
+	}
+}
+
+// value is the weighted sum of the constituent prices
+func (b Basket) value(prices map[string]float64) float64 {
+	var total float64
+	for _, c := range b.Constituents {
+		total += c.Weight * prices[c.Symbol]
+	}
+	return total
+}
+
+// updateBaskets reprices every basket from its constituents' current
+// prices, stamping those that moved with now. The caller must hold
+// stocksLock for writing.
+func (s *Server) updateBaskets(now time.Time) {
+	if len(s.baskets) == 0 {
+		return
+	}
+
+	prices := make(map[string]float64, len(s.stocks))
+	for symbol, stock := range s.stocks {
+		prices[symbol] = stock.Price
+	}
+
+	for _, basket := range s.baskets {
+		stock := s.stocks[basket.Symbol]
+		price := roundDecimals(basket.value(prices), stock.PriceDecimals)
+		if price != stock.Price {
+			stock.Price = price
+			stock.LastUpdate = now
+		}
+		stock.updateQuote()
+	}
+}
+
+// getBaskets returns the basket definitions, sorted by symbol
+func (s *Server) getBaskets(c *gin.Context) {
+	baskets := s.baskets
+	if baskets == nil {
+		baskets = []Basket{}
+	}
+	c.JSON(200, baskets)
+}
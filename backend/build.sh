@@ -5,8 +5,12 @@ set -euo pipefail
 echo ">> Installing Go dependencies"
 go mod download
 
-echo ">> Building GrowTrade backend binary"
-go build -o growtrade-server main.go
+VERSION="${VERSION:-$(git describe --tags --always 2>/dev/null || echo dev)}"
+COMMIT="${COMMIT:-$(git rev-parse --short HEAD 2>/dev/null || echo unknown)}"
+BUILD_TIME="$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+LDFLAGS="-X main.version=${VERSION} -X main.commit=${COMMIT} -X main.buildTime=${BUILD_TIME}"
 
-echo "Build complete: $(pwd)/growtrade-server"
+echo ">> Building GrowTrade backend binary (${VERSION} @ ${COMMIT})"
+go build -ldflags "${LDFLAGS}" -o growtrade-server .
 
+echo "Build complete: $(pwd)/growtrade-server"
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// candleIntervals are the candle widths clients may subscribe to. Candles
+// are aligned to whole intervals in UTC, so a 1h candle starts on the hour.
+var candleIntervals = map[string]time.Duration{
+	"1m":  time.Minute,
+	"5m":  5 * time.Minute,
+	"15m": 15 * time.Minute,
+	"1h":  time.Hour,
+}
+
+// maxCandleSubscriptions caps the candle streams one connection may follow
+const maxCandleSubscriptions = 20
+
+// candleKey identifies one candle stream
+type candleKey struct {
+	Symbol   string `json:"symbol"`
+	Interval string `json:"interval"`
+}
+
+// Candle is the open, high, low and close of a symbol's price over one
+// interval. Final is set once the interval is over; until then the candle is
+// still forming and changes with every price update.
+type Candle struct {
+	Symbol   string    `json:"symbol"`
+	Interval string    `json:"interval"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Open     float64   `json:"open"`
+	High     float64   `json:"high"`
+	Low      float64   `json:"low"`
+	Close    float64   `json:"close"`
+	Final    bool      `json:"final"`
+}
+
+// MarshalJSON writes the candle's prices to its symbol's precision
+func (c Candle) MarshalJSON() ([]byte, error) {
+	type candleJSON Candle
+	decimals := decimalsFor(c.Symbol)
+	return json.Marshal(struct {
+		candleJSON
+		Open  json.Number `json:"open"`
+		High  json.Number `json:"high"`
+		Low   json.Number `json:"low"`
+		Close json.Number `json:"close"`
+	}{
+		candleJSON: candleJSON(c),
+		Open:       fixed(c.Open, decimals),
+		High:       fixed(c.High, decimals),
+		Low:        fixed(c.Low, decimals),
+		Close:      fixed(c.Close, decimals),
+	})
+}
+
+// candlesMessage pushes candle updates to a WebSocket client
+type candlesMessage struct {
+	Type    string   `json:"type"` // "candles"
+	Candles []Candle `json:"candles"`
+}
+
+// candleSubscriptionsMessage confirms a client's candle streams
+type candleSubscriptionsMessage struct {
+	Type    string      `json:"type"` // "candle_subscriptions"
+	Candles []candleKey `json:"candles"`
+}
+
+// candleBook aggregates the price stream into a forming candle for every
+// symbol and interval, whether or not anyone is subscribed, so a new
+// subscriber gets the whole of the current candle
+type candleBook struct {
+	mu      sync.Mutex
+	forming map[candleKey]*Candle
+}
+
+func newCandleBook() *candleBook {
+	return &candleBook{forming: make(map[candleKey]*Candle)}
+}
+
+// update adds a price snapshot taken at now. It returns the candles that
+// changed, keyed by stream: a candle whose interval ended before now comes
+// back final, followed by the one that replaced it. An interval is only
+// seen to end when the next price arrives.
+func (b *candleBook) update(prices []Stock, now time.Time) map[candleKey][]Candle {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now = now.UTC()
+	changed := make(map[candleKey][]Candle, len(prices)*len(candleIntervals))
+	for _, stock := range prices {
+		for interval, width := range candleIntervals {
+			key := candleKey{Symbol: stock.Symbol, Interval: interval}
+			start := now.Truncate(width)
+
+			candle, ok := b.forming[key]
+			if ok && !candle.Start.Equal(start) {
+				final := *candle
+				final.Final = true
+				changed[key] = append(changed[key], final)
+				ok = false
+			}
+			if !ok {
+				candle = &Candle{
+					Symbol:   stock.Symbol,
+					Interval: interval,
+					Start:    start,
+					End:      start.Add(width),
+					Open:     stock.Price,
+					High:     stock.Price,
+					Low:      stock.Price,
+				}
+				b.forming[key] = candle
+			}
+
+			candle.High = max(candle.High, stock.Price)
+			candle.Low = min(candle.Low, stock.Price)
+			candle.Close = stock.Price
+			changed[key] = append(changed[key], *candle)
+		}
+	}
+	return changed
+}
+
+// current returns the forming candle for key, if there is one yet
+func (b *candleBook) current(key candleKey) (Candle, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	candle, ok := b.forming[key]
+	if !ok {
+		return Candle{}, false
+	}
+	return *candle, true
+}
+
+// reset drops every forming candle, so candles don't span a jump in prices
+func (b *candleBook) reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.forming = make(map[candleKey]*Candle)
+}
+
+// candleStreams returns the client's candle subscriptions, sorted by symbol
+// and then interval
+func (c *Client) candleStreams() []candleKey {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	keys := make([]candleKey, 0, len(c.candles))
+	for key := range c.candles {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Symbol != keys[j].Symbol {
+			return keys[i].Symbol < keys[j].Symbol
+		}
+		return candleIntervals[keys[i].Interval] < candleIntervals[keys[j].Interval]
+	})
+	return keys
+}
+
+// subscribeCandles adds a candle stream, reporting false if the client is
+// already at maxCandleSubscriptions
+func (c *Client) subscribeCandles(key candleKey) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.candles[key] {
+		return true
+	}
+	if len(c.candles) >= maxCandleSubscriptions {
+		return false
+	}
+	if c.candles == nil {
+		c.candles = make(map[candleKey]bool)
+	}
+	c.candles[key] = true
+	return true
+}
+
+// unsubscribeCandles removes a candle stream; unknown streams are ignored
+func (c *Client) unsubscribeCandles(key candleKey) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.candles, key)
+}
+
+// candleStream checks the symbol and interval of a candle command
+func (s *Server) candleStream(client *Client, msg clientMessage) (candleKey, bool) {
+	symbol := strings.ToUpper(strings.TrimSpace(s.resolveSymbol(msg.Symbol)))
+	if symbol == "" {
+		s.queueMessage(client, errorMessage{Type: "error", Action: msg.Action, Error: "Missing symbol", Field: "symbol", Status: 400})
+		return candleKey{}, false
+	}
+	if _, ok := s.lookupStock(symbol); !ok {
+		s.queueMessage(client, errorMessage{Type: "error", Action: msg.Action, Error: "Unknown symbol", Field: "symbol", Status: 400})
+		return candleKey{}, false
+	}
+	interval := strings.ToLower(strings.TrimSpace(msg.Interval))
+	if _, ok := candleIntervals[interval]; !ok {
+		s.queueMessage(client, errorMessage{Type: "error", Action: msg.Action, Error: "interval must be 1m, 5m, 15m or 1h", Field: "interval", Status: 400})
+		return candleKey{}, false
+	}
+	return candleKey{Symbol: symbol, Interval: interval}, true
+}
+
+// handleSubscribeCandlesMessage starts a candle stream, replying with the
+// client's candle streams and then the candle forming right now
+func (s *Server) handleSubscribeCandlesMessage(client *Client, msg clientMessage) {
+	key, ok := s.candleStream(client, msg)
+	if !ok {
+		return
+	}
+	if !client.subscribeCandles(key) {
+		s.queueMessage(client, errorMessage{Type: "error", Action: msg.Action, Error: "Too many candle subscriptions", Status: 400})
+		return
+	}
+
+	s.sendCandleStreams(client)
+	if candle, ok := s.candles.current(key); ok {
+		s.queueMessage(client, candlesMessage{Type: "candles", Candles: []Candle{candle}})
+	}
+}
+
+// handleUnsubscribeCandlesMessage stops a candle stream and replies with the
+// client's remaining candle streams
+func (s *Server) handleUnsubscribeCandlesMessage(client *Client, msg clientMessage) {
+	key, ok := s.candleStream(client, msg)
+	if !ok {
+		return
+	}
+
+	client.unsubscribeCandles(key)
+	s.sendCandleStreams(client)
+}
+
+// sendCandleStreams replies with the client's candle streams
+func (s *Server) sendCandleStreams(client *Client) {
+	s.queueMessage(client, candleSubscriptionsMessage{Type: "candle_subscriptions", Candles: client.candleStreams()})
+}
+
+// broadcastCandles folds a price snapshot into the candles and sends each
+// client the updates for its candle streams, all in one message
+func (s *Server) broadcastCandles(prices []Stock, now time.Time) {
+	changed := s.candles.update(prices, now)
+
+	s.broadcastEach(func(client *Client) []byte {
+		streams := client.candleStreams()
+		if len(streams) == 0 {
+			return nil
+		}
+		var candles []Candle
+		for _, key := range streams {
+			candles = append(candles, changed[key]...)
+		}
+		if len(candles) == 0 {
+			return nil
+		}
+		msg, err := json.Marshal(candlesMessage{Type: "candles", Candles: candles})
+		if err != nil {
+			log.Printf("Error encoding candles: %v", err)
+			return nil
+		}
+		return msg
+	})
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// tick is a price update for AAPL
+func tick(price float64) []Stock {
+	return []Stock{{Symbol: "AAPL", Price: price}}
+}
+
+func TestCandleBookFormsAndFinalizes(t *testing.T) {
+	book := newCandleBook()
+	minute := candleKey{Symbol: "AAPL", Interval: "1m"}
+	start := time.Date(2026, 1, 5, 14, 30, 0, 0, time.UTC)
+
+	// Ticks within the minute shape one forming candle
+	for i, price := range []float64{100, 103, 98, 101} {
+		changed := book.update(tick(price), start.Add(time.Duration(i*10)*time.Second))
+		if len(changed[minute]) != 1 || changed[minute][0].Final {
+			t.Fatalf("tick %d: changed = %+v, want one forming candle", i, changed[minute])
+		}
+	}
+	forming, ok := book.current(minute)
+	if !ok {
+		t.Fatal("no forming candle")
+	}
+	want := Candle{Symbol: "AAPL", Interval: "1m", Start: start, End: start.Add(time.Minute), Open: 100, High: 103, Low: 98, Close: 101}
+	if forming != want {
+		t.Fatalf("forming = %+v, want %+v", forming, want)
+	}
+
+	// The first tick of the next minute finalizes it and opens the next
+	changed := book.update(tick(102), start.Add(time.Minute+time.Second))
+	if len(changed[minute]) != 2 {
+		t.Fatalf("changed = %+v, want the final candle and a new one", changed[minute])
+	}
+	final := want
+	final.Final = true
+	if changed[minute][0] != final {
+		t.Fatalf("final = %+v, want %+v", changed[minute][0], final)
+	}
+	next := Candle{Symbol: "AAPL", Interval: "1m", Start: start.Add(time.Minute), End: start.Add(2 * time.Minute), Open: 102, High: 102, Low: 102, Close: 102}
+	if changed[minute][1] != next {
+		t.Fatalf("next = %+v, want %+v", changed[minute][1], next)
+	}
+
+	// Wider intervals keep forming across the minute
+	hour := candleKey{Symbol: "AAPL", Interval: "1h"}
+	if len(changed[hour]) != 1 || changed[hour][0].Final || changed[hour][0].Open != 100 || changed[hour][0].High != 103 || changed[hour][0].Close != 102 {
+		t.Fatalf("1h candle = %+v, want still forming from 100", changed[hour])
+	}
+}
+
+func TestCandleBookSkipsQuietIntervals(t *testing.T) {
+	book := newCandleBook()
+	minute := candleKey{Symbol: "AAPL", Interval: "1m"}
+	start := time.Date(2026, 1, 5, 14, 30, 0, 0, time.UTC)
+
+	book.update(tick(100), start)
+
+	// No prices arrived for three minutes: the old candle is only finalized
+	// now, and the new one starts on the current minute
+	changed := book.update(tick(105), start.Add(3*time.Minute+5*time.Second))
+	if len(changed[minute]) != 2 || !changed[minute][0].Final || !changed[minute][0].End.Equal(start.Add(time.Minute)) {
+		t.Fatalf("changed = %+v", changed[minute])
+	}
+	if got := changed[minute][1].Start; !got.Equal(start.Add(3 * time.Minute)) {
+		t.Fatalf("new candle starts at %v, want %v", got, start.Add(3*time.Minute))
+	}
+}
+
+func TestCandleBookReset(t *testing.T) {
+	book := newCandleBook()
+	minute := candleKey{Symbol: "AAPL", Interval: "1m"}
+	start := time.Date(2026, 1, 5, 14, 30, 0, 0, time.UTC)
+
+	book.update(tick(100), start)
+	book.reset()
+	if _, ok := book.current(minute); ok {
+		t.Fatal("forming candle kept across a reset")
+	}
+
+	// After a reset the next tick opens a fresh candle rather than
+	// finalizing the dropped one
+	changed := book.update(tick(50), start.Add(time.Second))
+	if len(changed[minute]) != 1 || changed[minute][0].Open != 50 || changed[minute][0].High != 50 {
+		t.Fatalf("changed = %+v, want one fresh candle at 50", changed[minute])
+	}
+}
+
+// readCandles reads WebSocket messages until the next candles message
+func readCandles(t *testing.T, conn *websocket.Conn) []Candle {
+	t.Helper()
+	for {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("reading candles: %v", err)
+		}
+		var msg candlesMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			t.Fatalf("decoding %s: %v", data, err)
+		}
+		if msg.Type == "candles" {
+			return msg.Candles
+		}
+	}
+}
+
+func TestCandleStreamOverWebSocket(t *testing.T) {
+	s := newTestServer(t, nil)
+	s.candles.reset()
+	conn := dialTestWebSocket(t, newTestWebSocketServer(t, s), "")
+	start := time.Date(2026, 1, 5, 14, 30, 0, 0, time.UTC)
+
+	if err := conn.WriteJSON(clientMessage{Action: "subscribe_candles", Symbol: "AAPL", Interval: "1m"}); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var confirm candleSubscriptionsMessage
+	if err := conn.ReadJSON(&confirm); err != nil || confirm.Type != "candle_subscriptions" || len(confirm.Candles) != 1 {
+		t.Fatalf("confirmation = %+v, %v", confirm, err)
+	}
+
+	// Only the subscribed stream is sent, still forming
+	s.broadcastCandles([]Stock{{Symbol: "AAPL", Price: 100}, {Symbol: "TSLA", Price: 250}}, start)
+	s.broadcastCandles(tick(104), start.Add(30*time.Second))
+	for _, want := range []float64{100, 104} {
+		candles := readCandles(t, conn)
+		if len(candles) != 1 || candles[0].Final || candles[0].Symbol != "AAPL" || candles[0].Interval != "1m" || candles[0].Close != want {
+			t.Fatalf("candles = %+v, want the forming AAPL 1m candle closing at %g", candles, want)
+		}
+	}
+
+	// The next minute's first tick sends the final candle and the new one
+	s.broadcastCandles(tick(99), start.Add(time.Minute))
+	candles := readCandles(t, conn)
+	if len(candles) != 2 {
+		t.Fatalf("candles = %+v, want the final candle and a new one", candles)
+	}
+	if final := candles[0]; !final.Final || final.Open != 100 || final.High != 104 || final.Low != 100 || final.Close != 104 {
+		t.Fatalf("final = %+v", final)
+	}
+	if next := candles[1]; next.Final || next.Open != 99 || !next.Start.Equal(start.Add(time.Minute)) {
+		t.Fatalf("next = %+v", next)
+	}
+}
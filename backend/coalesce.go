@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// Defaults for the simulation and client delivery rates
+const (
+	defaultPriceUpdateInterval = 3 * time.Second
+	defaultBroadcastInterval   = 250 * time.Millisecond // At most 4 times a second
+)
+
+// pricesChanged records that prices moved. Without a broadcast interval the
+// new prices go out immediately; otherwise broadcastJob picks them up on
+// its next flush.
+func (s *Server) pricesChanged() {
+	if s.broadcastInterval == 0 {
+		s.broadcastPrices()
+		return
+	}
+	s.pricesDirty.Store(true)
+}
+
+// broadcastJob flushes the latest prices to clients at most once per
+// broadcast interval, however often they change. Any number of updates
+// between flushes are coalesced into a single snapshot.
+func (s *Server) broadcastJob() job {
+	return job{
+		name:     "broadcast-prices",
+		interval: s.broadcastInterval,
+		run: func(context.Context) {
+			if s.pricesDirty.Swap(false) {
+				s.broadcastPrices()
+			}
+		},
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// coalesceFactor is how many price updates the benchmarks simulate per
+// broadcast interval: a 25ms simulation against the default 250ms flush
+const coalesceFactor = 10
+
+// benchmarkClients connects n fake WebSocket clients whose queues are
+// drained as fast as messages arrive, and returns a counter of the messages
+// delivered
+func benchmarkClients(b *testing.B, s *Server, n int) func() int {
+	b.Helper()
+	quietLogs(b)
+	delivered := make(chan int)
+	for i := 0; i < n; i++ {
+		// A deep queue, since a dropped client would close its nil conn
+		client := &Client{send: make(chan []byte, 1<<16), protocol: wsProtocolV1}
+		s.clientsLock.Lock()
+		s.clients[client] = struct{}{}
+		s.clientsLock.Unlock()
+		go func() {
+			count := 0
+			for range client.send {
+				count++
+			}
+			delivered <- count
+		}()
+	}
+	return func() int {
+		s.clientsLock.Lock()
+		for client := range s.clients {
+			delete(s.clients, client)
+			close(client.send)
+		}
+		s.clientsLock.Unlock()
+		total := 0
+		for i := 0; i < n; i++ {
+			total += <-delivered
+		}
+		return total
+	}
+}
+
+// BenchmarkBroadcastNaive sends every price update to every client
+func BenchmarkBroadcastNaive(b *testing.B) {
+	s := newTestServer(b, map[string]string{"BROADCAST_INTERVAL": "0"})
+	stop := benchmarkClients(b, s, 100)
+	rng := rand.New(rand.NewSource(1))
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.stepPrices(rng)
+		s.pricesChanged()
+	}
+	b.StopTimer()
+	b.ReportMetric(float64(stop())/float64(b.N), "msgs/update")
+}
+
+// BenchmarkBroadcastCoalesced marks updates dirty and flushes the latest
+// prices once per coalesceFactor updates, as broadcastJob would
+func BenchmarkBroadcastCoalesced(b *testing.B) {
+	s := newTestServer(b, map[string]string{"BROADCAST_INTERVAL": "250ms"})
+	stop := benchmarkClients(b, s, 100)
+	flush := s.broadcastJob().run
+	rng := rand.New(rand.NewSource(1))
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.stepPrices(rng)
+		s.pricesChanged()
+		if i%coalesceFactor == coalesceFactor-1 {
+			flush(nil)
+		}
+	}
+	b.StopTimer()
+	b.ReportMetric(float64(stop())/float64(b.N), "msgs/update")
+}
@@ -0,0 +1,894 @@
+package main
+
+import (
+	"compress/flate"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds the settings read from the environment at startup
+type Config struct {
+	// Environment is the deployment environment from APP_ENV
+	Environment string
+
+	DBPath          string
+	DBRetry         dbRetryPolicy
+	DBHealthCheck   time.Duration // How often the database is pinged; 0 disables it
+	PasswordPolicy  PasswordPolicy
+	UsernamePolicy  UsernamePolicy
+	PasswordHasher  PasswordHasher
+	StarterHoldings []symbolValue
+	Stocks          []Stock
+	Baskets         []Basket // Also present in Stocks
+
+	// SymbolAliases maps upper-cased alternative names, such as APPLE, to
+	// the tracked symbol they stand for
+	SymbolAliases map[string]string
+	TickSizeMode  string
+
+	// LimitPriceDecimals caps the decimal places of limit prices
+	LimitPriceDecimals int
+
+	// QuantityIncrement is the smallest step order quantities may use
+	QuantityIncrement float64
+
+	// QuantityPrecisionMode decides whether quantities with more decimals
+	// than their symbol allows are rejected or rounded
+	QuantityPrecisionMode string
+
+	// ShortSelling accepts short and cover orders, and stops sells and
+	// covers from crossing a position through zero
+	ShortSelling bool
+
+	// MoneyRounding rounds notionals and totals, and FeeRounding fees; see
+	// roundMoney
+	MoneyRounding, FeeRounding string
+
+	// TokenLeeway is the clock skew tolerated when validating JWT exp/nbf
+	TokenLeeway time.Duration
+
+	// TokenKeyID is the kid of JWT_SECRET, which signs new tokens.
+	// PreviousTokenKeys holds the retired secret by kid, still accepted
+	// until the tokens it signed expire.
+	TokenKeyID        string
+	PreviousTokenKeys map[string][]byte
+
+	// ImpersonationTTL is how long tokens admins issue to act as a user last
+	ImpersonationTTL time.Duration
+
+	// WelcomeMessage is the first notification of every new user; empty
+	// sends none
+	WelcomeMessage string
+
+	// MaxOrdersPerDay caps orders per non-admin user per UTC day; 0 disables it
+	MaxOrdersPerDay int
+
+	// MinAccountAge is how old a non-admin account must be to place orders;
+	// 0 disables the check
+	MinAccountAge time.Duration
+
+	// MaxOpenOrders caps the working orders a non-admin user may have at
+	// once; 0 disables it
+	MaxOpenOrders int
+
+	// SymbolOrderLimit caps orders per non-admin user in any one symbol per
+	// SymbolOrderWindow; 0 disables it
+	SymbolOrderLimit  int
+	SymbolOrderWindow time.Duration
+
+	// MaintenanceMode starts the server read-only
+	MaintenanceMode bool
+
+	// Retention controls archival of old orders; off unless a limit is set
+	Retention retentionPolicy
+
+	// Pagination sets the page sizes of list endpoints
+	Pagination paginationPolicy
+
+	// PriceUpdateInterval is how often simulated prices move
+	PriceUpdateInterval time.Duration
+
+	// BroadcastInterval is the minimum time between price broadcasts to
+	// clients; 0 broadcasts every update as it happens
+	BroadcastInterval time.Duration
+
+	// WebhookAllowPrivate lets webhooks target internal addresses, for
+	// local testing only
+	WebhookAllowPrivate bool
+
+	// WSCompression negotiates permessage-deflate with WebSocket clients
+	// that support it, compressing at WSCompressionLevel
+	WSCompression      bool
+	WSCompressionLevel int
+
+	// WSProtocols are the accepted WebSocket subprotocols, most preferred
+	// first
+	WSProtocols []string
+
+	// SettlementDelay keeps new orders pending for this long before they
+	// fill; 0 fills them immediately
+	SettlementDelay time.Duration
+
+	// RateLimit caps /api requests per client IP; off unless a limit is set
+	RateLimit rateLimitPolicy
+
+	// TrustedProxies may name the client in X-Forwarded-For; other requests
+	// are attributed to the address they came from
+	TrustedProxies []string
+
+	// AllowedOrigins may make credentialed cross-origin requests, and open
+	// cookie-authenticated WebSockets; empty allows any origin, without
+	// credentials
+	AllowedOrigins []string
+
+	// Faults injects artificial latency and errors; off unless configured
+	Faults faultInjection
+
+	// AuthCookie also hands out tokens in an HttpOnly cookie, with the
+	// given SameSite policy, and accepts them from it
+	AuthCookie         bool
+	AuthCookieSameSite http.SameSite
+
+	// PricesRequireAuth serves live prices, over REST, the event stream and
+	// the WebSocket, only to authenticated users
+	PricesRequireAuth bool
+
+	// ResponseEnvelope wraps every JSON response with metadata; without it
+	// clients opt in per request
+	ResponseEnvelope bool
+}
+
+// envProduction is the APP_ENV value that disables demo-only features
+const envProduction = "production"
+
+// Tick size modes for order prices that aren't a multiple of the tick, also
+// used for quantities with too many decimals
+const (
+	tickSizeReject = "reject"
+	tickSizeRound  = "round"
+)
+
+// defaultTickSize is the price increment for symbols without an explicit one
+const defaultTickSize = 0.01
+
+// defaultBeta is the market sensitivity for symbols without an explicit one
+const defaultBeta = 1.0
+
+// defaultPriceDecimals is the price precision for symbols without an explicit one
+const defaultPriceDecimals = 2
+
+// maxPriceDecimals bounds PRICE_DECIMALS to what a float64 price can hold exactly
+const maxPriceDecimals = 8
+
+// defaultLimitPriceDecimals is how many decimal places limit prices may
+// have, whatever the symbol's tick size
+const defaultLimitPriceDecimals = 4
+
+// defaultQuantityDecimals is the quantity precision for symbols without an
+// explicit one, matching defaultQuantityIncrement
+const defaultQuantityDecimals = 3
+
+// defaultQuantityIncrement allows fractional shares down to a thousandth
+const defaultQuantityIncrement = 0.001
+
+// symbolValue is a single SYMBOL:VALUE entry from a comma-separated list
+type symbolValue struct {
+	Symbol string
+	Value  float64
+}
+
+// loadConfig reads the server configuration from environment variables,
+// falling back to defaults for anything that is unset
+func loadConfig() (Config, error) {
+	cfg := Config{
+		Environment: strings.ToLower(strings.TrimSpace(os.Getenv("APP_ENV"))),
+		DBPath:      os.Getenv("DB_PATH"),
+	}
+	if cfg.Environment == "" {
+		cfg.Environment = "development"
+	}
+
+	if cfg.DBPath != "" {
+		// A directory can never be opened, so fail fast instead of retrying
+		if info, err := os.Stat(cfg.DBPath); err == nil && info.IsDir() {
+			return cfg, fmt.Errorf("DB_PATH %q is a directory", cfg.DBPath)
+		}
+	}
+	var err error
+	if cfg.DBRetry.Attempts, err = envInt("DB_CONNECT_ATTEMPTS", defaultDBConnectAttempts); err != nil {
+		return cfg, err
+	}
+	if cfg.DBRetry.Attempts < 1 {
+		return cfg, fmt.Errorf("DB_CONNECT_ATTEMPTS must be at least 1")
+	}
+	if cfg.DBRetry.Backoff, err = envDuration("DB_CONNECT_BACKOFF", defaultDBConnectBackoff); err != nil {
+		return cfg, err
+	}
+	if cfg.DBRetry.Backoff < 0 {
+		return cfg, fmt.Errorf("DB_CONNECT_BACKOFF must not be negative")
+	}
+	if cfg.DBHealthCheck, err = envDuration("DB_HEALTH_INTERVAL", defaultDBHealthInterval); err != nil {
+		return cfg, err
+	}
+	if cfg.DBHealthCheck < 0 {
+		return cfg, fmt.Errorf("DB_HEALTH_INTERVAL must not be negative")
+	}
+
+	policy, err := loadPasswordPolicy()
+	if err != nil {
+		return cfg, err
+	}
+	cfg.PasswordPolicy = policy
+	if cfg.UsernamePolicy, err = loadUsernamePolicy(); err != nil {
+		return cfg, err
+	}
+
+	hasherName := strings.ToLower(strings.TrimSpace(os.Getenv("PASSWORD_HASHER")))
+	if hasherName == "" {
+		hasherName = hashBcrypt
+	}
+	if cfg.PasswordHasher, err = newPasswordHasher(hasherName); err != nil {
+		return cfg, fmt.Errorf("PASSWORD_HASHER: %w", err)
+	}
+
+	// Starter holdings are off unless STARTER_HOLDINGS is set
+	holdings, err := parseSymbolValues("STARTER_HOLDINGS", os.Getenv("STARTER_HOLDINGS"))
+	if err != nil {
+		return cfg, err
+	}
+	for _, h := range holdings {
+		if h.Value <= 0 {
+			return cfg, fmt.Errorf("STARTER_HOLDINGS: quantity for %s must be positive", h.Symbol)
+		}
+	}
+	cfg.StarterHoldings = holdings
+
+	stocks, err := loadStocks()
+	if err != nil {
+		return cfg, err
+	}
+	cfg.Stocks = stocks
+
+	// Baskets are tracked like any other symbol, so the per-symbol settings
+	// below can target them too
+	if cfg.Baskets, err = loadBaskets(cfg.Stocks); err != nil {
+		return cfg, err
+	}
+	for _, basket := range cfg.Baskets {
+		cfg.Stocks = append(cfg.Stocks, basket.stock(stocks))
+	}
+
+	if err := applyNames(cfg.Stocks); err != nil {
+		return cfg, err
+	}
+	if cfg.SymbolAliases, err = loadSymbolAliases(cfg.Stocks); err != nil {
+		return cfg, err
+	}
+	if err := applyTickSizes(cfg.Stocks); err != nil {
+		return cfg, err
+	}
+	if err := applyBetas(cfg.Stocks); err != nil {
+		return cfg, err
+	}
+	if err := applyPriceDecimals(cfg.Stocks); err != nil {
+		return cfg, err
+	}
+	if err := applySpreads(cfg.Stocks); err != nil {
+		return cfg, err
+	}
+	if err := applyQuantityDecimals(cfg.Stocks); err != nil {
+		return cfg, err
+	}
+	if err := applyPriceBands(cfg.Stocks); err != nil {
+		return cfg, err
+	}
+	if err := applyMinNotionals(cfg.Stocks); err != nil {
+		return cfg, err
+	}
+
+	cfg.TickSizeMode = strings.ToLower(strings.TrimSpace(os.Getenv("TICK_SIZE_MODE")))
+	switch cfg.TickSizeMode {
+	case "":
+		cfg.TickSizeMode = tickSizeReject
+	case tickSizeReject, tickSizeRound:
+	default:
+		return cfg, fmt.Errorf("TICK_SIZE_MODE must be %q or %q, got %q", tickSizeReject, tickSizeRound, cfg.TickSizeMode)
+	}
+
+	if cfg.LimitPriceDecimals, err = envInt("LIMIT_PRICE_DECIMALS", defaultLimitPriceDecimals); err != nil {
+		return cfg, err
+	}
+	if cfg.LimitPriceDecimals < 0 || cfg.LimitPriceDecimals > maxPriceDecimals {
+		return cfg, fmt.Errorf("LIMIT_PRICE_DECIMALS must be between 0 and %d", maxPriceDecimals)
+	}
+	if cfg.QuantityIncrement, err = envFloat("QUANTITY_INCREMENT", defaultQuantityIncrement); err != nil {
+		return cfg, err
+	}
+	if cfg.QuantityIncrement <= 0 {
+		return cfg, fmt.Errorf("QUANTITY_INCREMENT must be positive")
+	}
+	cfg.QuantityPrecisionMode = strings.ToLower(strings.TrimSpace(os.Getenv("QUANTITY_PRECISION_MODE")))
+	switch cfg.QuantityPrecisionMode {
+	case "":
+		cfg.QuantityPrecisionMode = tickSizeReject
+	case tickSizeReject, tickSizeRound:
+	default:
+		return cfg, fmt.Errorf("QUANTITY_PRECISION_MODE must be %q or %q, got %q", tickSizeReject, tickSizeRound, cfg.QuantityPrecisionMode)
+	}
+	if cfg.TokenLeeway, err = envDuration("JWT_LEEWAY", defaultTokenLeeway); err != nil {
+		return cfg, err
+	}
+	if cfg.TokenLeeway < 0 {
+		return cfg, fmt.Errorf("JWT_LEEWAY must not be negative")
+	}
+	if cfg.TokenKeyID, cfg.PreviousTokenKeys, err = loadTokenKeys(); err != nil {
+		return cfg, err
+	}
+	if cfg.ImpersonationTTL, err = envDuration("IMPERSONATION_TTL", defaultImpersonationTTL); err != nil {
+		return cfg, err
+	}
+	if cfg.ImpersonationTTL <= 0 || cfg.ImpersonationTTL > maxImpersonationTTL {
+		return cfg, fmt.Errorf("IMPERSONATION_TTL must be positive and at most %s", maxImpersonationTTL)
+	}
+
+	cfg.WelcomeMessage = defaultWelcomeMessage
+	if raw, ok := os.LookupEnv("WELCOME_MESSAGE"); ok {
+		cfg.WelcomeMessage = strings.TrimSpace(raw)
+	}
+
+	if cfg.MaxOrdersPerDay, err = envInt("MAX_ORDERS_PER_DAY", 0); err != nil {
+		return cfg, err
+	}
+	if cfg.MaxOrdersPerDay < 0 {
+		return cfg, fmt.Errorf("MAX_ORDERS_PER_DAY must not be negative")
+	}
+	if cfg.MinAccountAge, err = envDuration("MIN_ACCOUNT_AGE", 0); err != nil {
+		return cfg, err
+	}
+	if cfg.MinAccountAge < 0 {
+		return cfg, fmt.Errorf("MIN_ACCOUNT_AGE must not be negative")
+	}
+	if cfg.MaxOpenOrders, err = envInt("MAX_OPEN_ORDERS", 0); err != nil {
+		return cfg, err
+	}
+	if cfg.MaxOpenOrders < 0 {
+		return cfg, fmt.Errorf("MAX_OPEN_ORDERS must not be negative")
+	}
+	if cfg.SymbolOrderLimit, err = envInt("SYMBOL_ORDER_LIMIT", 0); err != nil {
+		return cfg, err
+	}
+	if cfg.SymbolOrderLimit < 0 {
+		return cfg, fmt.Errorf("SYMBOL_ORDER_LIMIT must not be negative")
+	}
+	if cfg.SymbolOrderWindow, err = envDuration("SYMBOL_ORDER_WINDOW", defaultSymbolOrderWindow); err != nil {
+		return cfg, err
+	}
+	if cfg.SymbolOrderWindow <= 0 {
+		return cfg, fmt.Errorf("SYMBOL_ORDER_WINDOW must be positive")
+	}
+
+	if cfg.MaintenanceMode, err = envBool("MAINTENANCE_MODE", false); err != nil {
+		return cfg, err
+	}
+
+	if cfg.Retention, err = loadRetentionPolicy(); err != nil {
+		return cfg, err
+	}
+	if cfg.Pagination, err = loadPaginationPolicy(); err != nil {
+		return cfg, err
+	}
+
+	if cfg.PriceUpdateInterval, err = envDuration("PRICE_UPDATE_INTERVAL", defaultPriceUpdateInterval); err != nil {
+		return cfg, err
+	}
+	if cfg.PriceUpdateInterval <= 0 {
+		return cfg, fmt.Errorf("PRICE_UPDATE_INTERVAL must be positive")
+	}
+	if cfg.BroadcastInterval, err = envDuration("BROADCAST_INTERVAL", defaultBroadcastInterval); err != nil {
+		return cfg, err
+	}
+	if cfg.BroadcastInterval < 0 {
+		return cfg, fmt.Errorf("BROADCAST_INTERVAL must not be negative")
+	}
+
+	if cfg.WebhookAllowPrivate, err = envBool("WEBHOOK_ALLOW_PRIVATE", false); err != nil {
+		return cfg, err
+	}
+	if cfg.WebhookAllowPrivate && cfg.Environment == envProduction {
+		return cfg, fmt.Errorf("WEBHOOK_ALLOW_PRIVATE must not be enabled in production")
+	}
+
+	if cfg.WSCompression, err = envBool("WS_COMPRESSION", true); err != nil {
+		return cfg, err
+	}
+	if cfg.WSCompressionLevel, err = envInt("WS_COMPRESSION_LEVEL", flate.BestSpeed); err != nil {
+		return cfg, err
+	}
+	if cfg.WSCompressionLevel < flate.HuffmanOnly || cfg.WSCompressionLevel > flate.BestCompression {
+		return cfg, fmt.Errorf("WS_COMPRESSION_LEVEL must be between %d and %d", flate.HuffmanOnly, flate.BestCompression)
+	}
+
+	if cfg.WSProtocols, err = loadWSProtocols(); err != nil {
+		return cfg, err
+	}
+
+	if cfg.SettlementDelay, err = envDuration("SETTLEMENT_DELAY", 0); err != nil {
+		return cfg, err
+	}
+	if cfg.SettlementDelay < 0 {
+		return cfg, fmt.Errorf("SETTLEMENT_DELAY must not be negative")
+	}
+
+	if cfg.AuthCookie, err = envBool("AUTH_COOKIE", false); err != nil {
+		return cfg, err
+	}
+	if cfg.AuthCookieSameSite, err = parseSameSite(os.Getenv("AUTH_COOKIE_SAMESITE")); err != nil {
+		return cfg, err
+	}
+	if cfg.PricesRequireAuth, err = envBool("PRICES_REQUIRE_AUTH", false); err != nil {
+		return cfg, err
+	}
+	if cfg.ResponseEnvelope, err = envBool("RESPONSE_ENVELOPE", false); err != nil {
+		return cfg, err
+	}
+	if cfg.ShortSelling, err = envBool("SHORT_SELLING", false); err != nil {
+		return cfg, err
+	}
+	if cfg.MoneyRounding, cfg.FeeRounding, err = loadRoundingModes(); err != nil {
+		return cfg, err
+	}
+
+	if cfg.RateLimit, err = loadRateLimitPolicy(); err != nil {
+		return cfg, err
+	}
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			cfg.TrustedProxies = append(cfg.TrustedProxies, proxy)
+		}
+	}
+	for _, origin := range strings.Split(os.Getenv("ALLOWED_ORIGINS"), ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			cfg.AllowedOrigins = append(cfg.AllowedOrigins, origin)
+		}
+	}
+	if cfg.AuthCookie && cfg.AuthCookieSameSite == http.SameSiteNoneMode && len(cfg.AllowedOrigins) == 0 {
+		return cfg, fmt.Errorf("AUTH_COOKIE_SAMESITE=none requires ALLOWED_ORIGINS")
+	}
+
+	if cfg.Faults, err = loadFaultInjection(); err != nil {
+		return cfg, err
+	}
+	if cfg.Faults.enabled() && cfg.Environment == envProduction {
+		return cfg, fmt.Errorf("FAULT_* settings must not be enabled in production")
+	}
+
+	for _, h := range cfg.StarterHoldings {
+		if !onTick(h.Value, cfg.QuantityIncrement) {
+			return cfg, fmt.Errorf("STARTER_HOLDINGS: quantity for %s must be a multiple of %g", h.Symbol, cfg.QuantityIncrement)
+		}
+		for _, stock := range cfg.Stocks {
+			if stock.Symbol == h.Symbol && !stock.Tradable {
+				return cfg, fmt.Errorf("STARTER_HOLDINGS: %s is not tradable", h.Symbol)
+			}
+			if stock.Symbol == h.Symbol && roundDecimals(h.Value, stock.QuantityDecimals) != h.Value {
+				return cfg, fmt.Errorf("STARTER_HOLDINGS: quantity for %s may have at most %d decimal places", h.Symbol, stock.QuantityDecimals)
+			}
+		}
+	}
+
+	return cfg, nil
+}
+
+// loadStocks builds the tracked stocks from STOCK_SYMBOLS (e.g.
+// "AAPL:175.50,TSLA:245.30"), or returns the defaults when it is unset.
+// Symbols that match a default keep its metadata; others are quoted in USD.
+func loadStocks() ([]Stock, error) {
+	entries, err := parseSymbolValues("STOCK_SYMBOLS", os.Getenv("STOCK_SYMBOLS"))
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return defaultStocks(), nil
+	}
+
+	defaults := make(map[string]Stock)
+	for _, stock := range defaultStocks() {
+		defaults[stock.Symbol] = stock
+	}
+
+	stocks := make([]Stock, 0, len(entries))
+	for _, entry := range entries {
+		if entry.Value <= 0 {
+			return nil, fmt.Errorf("STOCK_SYMBOLS: price for %s must be positive", entry.Symbol)
+		}
+		stock, ok := defaults[entry.Symbol]
+		if !ok {
+			stock = Stock{Symbol: entry.Symbol, Currency: fxBaseCurrency, PriceDecimals: defaultPriceDecimals, Tradable: true}
+		}
+		stock.Price = entry.Value
+		stocks = append(stocks, stock)
+	}
+	return stocks, nil
+}
+
+// loadSymbolAliases reads SYMBOL_ALIASES, a list of ALIAS:SYMBOL pairs such
+// as "APPLE:AAPL,TESLA:TSLA". Aliases are upper-cased, must be unique and
+// can't shadow a tracked symbol; each must point at a tracked symbol.
+func loadSymbolAliases(stocks []Stock) (map[string]string, error) {
+	raw := strings.TrimSpace(os.Getenv("SYMBOL_ALIASES"))
+	if raw == "" {
+		return nil, nil
+	}
+
+	tracked := make(map[string]bool, len(stocks))
+	for _, stock := range stocks {
+		tracked[stock.Symbol] = true
+	}
+
+	aliases := make(map[string]string)
+	for _, entry := range strings.Split(raw, ",") {
+		alias, symbol, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if !ok {
+			return nil, fmt.Errorf("SYMBOL_ALIASES: malformed entry %q, expected ALIAS:SYMBOL", entry)
+		}
+		alias = strings.ToUpper(strings.TrimSpace(alias))
+		symbol = strings.ToUpper(strings.TrimSpace(symbol))
+		if alias == "" {
+			return nil, fmt.Errorf("SYMBOL_ALIASES: missing alias in entry %q", entry)
+		}
+		if _, ok := aliases[alias]; ok {
+			return nil, fmt.Errorf("SYMBOL_ALIASES: duplicate alias %s", alias)
+		}
+		if tracked[alias] {
+			return nil, fmt.Errorf("SYMBOL_ALIASES: alias %s is already a tracked symbol", alias)
+		}
+		if !tracked[symbol] {
+			return nil, fmt.Errorf("SYMBOL_ALIASES: unknown symbol %s for alias %s", symbol, alias)
+		}
+		aliases[alias] = symbol
+	}
+	return aliases, nil
+}
+
+// applyNames sets per-symbol display names and sectors from STOCK_NAMES and
+// STOCK_SECTORS (e.g. "AAPL:Apple Inc.,INFY:Infosys"). Symbols without a
+// name are shown by their symbol.
+func applyNames(stocks []Stock) error {
+	err := applySymbolStrings(stocks, "STOCK_NAMES", func(stock *Stock, name string) error {
+		if name == "" {
+			return fmt.Errorf("name for %s must not be empty", stock.Symbol)
+		}
+		stock.Name = name
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for i := range stocks {
+		if stocks[i].Name == "" {
+			stocks[i].Name = stocks[i].Symbol
+		}
+	}
+
+	return applySymbolStrings(stocks, "STOCK_SECTORS", func(stock *Stock, sector string) error {
+		if sector == "" {
+			return fmt.Errorf("sector for %s must not be empty", stock.Symbol)
+		}
+		stock.Sector = sector
+		return nil
+	})
+}
+
+// applyTickSizes sets per-symbol tick sizes from TICK_SIZES (e.g.
+// "TCS:0.05,AAPL:0.01") and fills in the default for any symbol left unset
+func applyTickSizes(stocks []Stock) error {
+	for i := range stocks {
+		if stocks[i].TickSize == 0 {
+			stocks[i].TickSize = defaultTickSize
+		}
+	}
+	return applySymbolValues(stocks, "TICK_SIZES", func(stock *Stock, tick float64) error {
+		if tick <= 0 {
+			return fmt.Errorf("tick size for %s must be positive", stock.Symbol)
+		}
+		stock.TickSize = tick
+		return nil
+	})
+}
+
+// applyBetas sets per-symbol market betas from STOCK_BETAS (e.g.
+// "TSLA:2.0,TCS:0.6"). Symbols without a default beta track the market 1:1.
+func applyBetas(stocks []Stock) error {
+	for i := range stocks {
+		if stocks[i].Beta == 0 {
+			stocks[i].Beta = defaultBeta
+		}
+	}
+	return applySymbolValues(stocks, "STOCK_BETAS", func(stock *Stock, beta float64) error {
+		if math.IsNaN(beta) || math.IsInf(beta, 0) {
+			return fmt.Errorf("beta for %s must be a finite number", stock.Symbol)
+		}
+		stock.Beta = beta
+		return nil
+	})
+}
+
+// applyPriceDecimals sets per-symbol price precision from PRICE_DECIMALS
+// (e.g. "TCS:1,INFY:3") and rounds each starting price to its precision
+func applyPriceDecimals(stocks []Stock) error {
+	err := applySymbolValues(stocks, "PRICE_DECIMALS", func(stock *Stock, decimals float64) error {
+		if decimals != math.Trunc(decimals) || decimals < 0 || decimals > maxPriceDecimals {
+			return fmt.Errorf("price decimals for %s must be a whole number between 0 and %d", stock.Symbol, maxPriceDecimals)
+		}
+		stock.PriceDecimals = int(decimals)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for i := range stocks {
+		stocks[i].Price = roundDecimals(stocks[i].Price, stocks[i].PriceDecimals)
+	}
+	return nil
+}
+
+// applyQuantityDecimals sets per-symbol quantity precision from
+// QUANTITY_DECIMALS (e.g. "TCS:0"); other symbols get the default
+func applyQuantityDecimals(stocks []Stock) error {
+	for i := range stocks {
+		stocks[i].QuantityDecimals = defaultQuantityDecimals
+	}
+	return applySymbolValues(stocks, "QUANTITY_DECIMALS", func(stock *Stock, decimals float64) error {
+		if decimals != math.Trunc(decimals) || decimals < 0 || decimals > maxPriceDecimals {
+			return fmt.Errorf("quantity decimals for %s must be a whole number between 0 and %d", stock.Symbol, maxPriceDecimals)
+		}
+		stock.QuantityDecimals = int(decimals)
+		return nil
+	})
+}
+
+// applyPriceBands sets the limit price band, in percent, from
+// PRICE_BAND_PERCENT for every symbol and PRICE_BANDS (e.g. "TSLA:30") per
+// symbol. Bands are off (0) unless configured.
+func applyPriceBands(stocks []Stock) error {
+	band, err := envFloat("PRICE_BAND_PERCENT", 0)
+	if err != nil {
+		return err
+	}
+	if band < 0 {
+		return fmt.Errorf("PRICE_BAND_PERCENT must not be negative")
+	}
+	for i := range stocks {
+		stocks[i].PriceBandPercent = band
+	}
+	return applySymbolValues(stocks, "PRICE_BANDS", func(stock *Stock, percent float64) error {
+		if percent < 0 || math.IsNaN(percent) || math.IsInf(percent, 0) {
+			return fmt.Errorf("price band for %s must be a non-negative percentage", stock.Symbol)
+		}
+		stock.PriceBandPercent = percent
+		return nil
+	})
+}
+
+// applyMinNotionals sets the smallest order value from MIN_ORDER_NOTIONAL,
+// in USD and converted to each symbol's currency, and MIN_NOTIONALS (e.g.
+// "TCS:500") per symbol in its own currency. Off (0) unless configured.
+func applyMinNotionals(stocks []Stock) error {
+	minimum, err := envFloat("MIN_ORDER_NOTIONAL", 0)
+	if err != nil {
+		return err
+	}
+	if minimum < 0 || math.IsNaN(minimum) || math.IsInf(minimum, 0) {
+		return fmt.Errorf("MIN_ORDER_NOTIONAL must be a non-negative amount")
+	}
+	for i := range stocks {
+		stocks[i].MinNotional = roundDecimals(convertCurrency(minimum, fxBaseCurrency, stocks[i].Currency), stocks[i].PriceDecimals)
+	}
+	return applySymbolValues(stocks, "MIN_NOTIONALS", func(stock *Stock, notional float64) error {
+		if notional < 0 || math.IsNaN(notional) || math.IsInf(notional, 0) {
+			return fmt.Errorf("minimum notional for %s must be a non-negative amount", stock.Symbol)
+		}
+		stock.MinNotional = notional
+		return nil
+	})
+}
+
+// applySpreads sets per-symbol bid/ask spreads in basis points from
+// STOCK_SPREADS (e.g. "TSLA:25,TCS:5"), defaulting to defaultSpreadBps
+func applySpreads(stocks []Stock) error {
+	for i := range stocks {
+		stocks[i].SpreadBps = defaultSpreadBps
+	}
+	return applySymbolValues(stocks, "STOCK_SPREADS", func(stock *Stock, bps float64) error {
+		if bps < 0 || math.IsNaN(bps) || math.IsInf(bps, 0) {
+			return fmt.Errorf("spread for %s must be a non-negative number of basis points", stock.Symbol)
+		}
+		stock.SpreadBps = bps
+		return nil
+	})
+}
+
+// applySymbolValues parses the SYMBOL:VALUE list in the environment variable
+// key and calls set with the matching stock for each entry. Every symbol in
+// the list must be one of the configured stocks.
+func applySymbolValues(stocks []Stock, key string, set func(stock *Stock, value float64) error) error {
+	entries, err := parseSymbolValues(key, os.Getenv(key))
+	if err != nil {
+		return err
+	}
+
+	index := make(map[string]int, len(stocks))
+	for i := range stocks {
+		index[stocks[i].Symbol] = i
+	}
+
+	for _, entry := range entries {
+		i, ok := index[entry.Symbol]
+		if !ok {
+			return fmt.Errorf("%s: unknown symbol %s", key, entry.Symbol)
+		}
+		if err := set(&stocks[i], entry.Value); err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// applySymbolStrings is applySymbolValues for SYMBOL:TEXT lists, where the
+// text is trimmed and may contain anything but commas
+func applySymbolStrings(stocks []Stock, key string, set func(stock *Stock, value string) error) error {
+	raw := strings.TrimSpace(os.Getenv(key))
+	if raw == "" {
+		return nil
+	}
+
+	index := make(map[string]int, len(stocks))
+	for i := range stocks {
+		index[stocks[i].Symbol] = i
+	}
+
+	seen := make(map[string]bool)
+	for _, entry := range strings.Split(raw, ",") {
+		symbol, value, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if !ok {
+			return fmt.Errorf("%s: malformed entry %q, expected SYMBOL:TEXT", key, entry)
+		}
+		symbol = strings.ToUpper(strings.TrimSpace(symbol))
+		if symbol == "" {
+			return fmt.Errorf("%s: missing symbol in entry %q", key, entry)
+		}
+		if seen[symbol] {
+			return fmt.Errorf("%s: duplicate symbol %s", key, symbol)
+		}
+		seen[symbol] = true
+
+		i, ok := index[symbol]
+		if !ok {
+			return fmt.Errorf("%s: unknown symbol %s", key, symbol)
+		}
+		if err := set(&stocks[i], strings.TrimSpace(value)); err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// parseSymbolValues parses a comma-separated list of SYMBOL:VALUE pairs such
+// as "AAPL:10,TSLA:5". Symbols are upper-cased and must be unique.
+func parseSymbolValues(key, raw string) ([]symbolValue, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return nil, nil
+	}
+
+	var values []symbolValue
+	seen := make(map[string]bool)
+	for _, entry := range strings.Split(raw, ",") {
+		parts := strings.Split(strings.TrimSpace(entry), ":")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("%s: malformed entry %q, expected SYMBOL:VALUE", key, entry)
+		}
+
+		symbol := strings.ToUpper(strings.TrimSpace(parts[0]))
+		if symbol == "" {
+			return nil, fmt.Errorf("%s: missing symbol in entry %q", key, entry)
+		}
+		if seen[symbol] {
+			return nil, fmt.Errorf("%s: duplicate symbol %s", key, symbol)
+		}
+		seen[symbol] = true
+
+		value, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid value in entry %q", key, entry)
+		}
+		values = append(values, symbolValue{Symbol: symbol, Value: value})
+	}
+	return values, nil
+}
+
+// envInt reads an integer environment variable, returning def when unset
+func envInt(key string, def int) (int, error) {
+	raw := strings.TrimSpace(os.Getenv(key))
+	if raw == "" {
+		return def, nil
+	}
+	v, err := strconv.Atoi(raw)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be an integer, got %q", key, raw)
+	}
+	return v, nil
+}
+
+// envFloat reads a float environment variable, returning def when unset
+func envFloat(key string, def float64) (float64, error) {
+	raw := strings.TrimSpace(os.Getenv(key))
+	if raw == "" {
+		return def, nil
+	}
+	v, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be a number, got %q", key, raw)
+	}
+	return v, nil
+}
+
+// envDuration reads a duration environment variable such as "30s",
+// returning def when unset
+func envDuration(key string, def time.Duration) (time.Duration, error) {
+	raw := strings.TrimSpace(os.Getenv(key))
+	if raw == "" {
+		return def, nil
+	}
+	v, err := time.ParseDuration(raw)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be a duration like \"30s\", got %q", key, raw)
+	}
+	return v, nil
+}
+
+// envBool reads a boolean environment variable, returning def when unset
+func envBool(key string, def bool) (bool, error) {
+	raw := strings.TrimSpace(os.Getenv(key))
+	if raw == "" {
+		return def, nil
+	}
+	v, err := strconv.ParseBool(raw)
+	if err != nil {
+		return false, fmt.Errorf("%s must be a boolean, got %q", key, raw)
+	}
+	return v, nil
+}
+
+// loadTokenKeys reads the kid of the current JWT key and the previous key,
+// if one is still accepted after a rotation
+func loadTokenKeys() (string, map[string][]byte, error) {
+	keyID := strings.TrimSpace(os.Getenv("JWT_KEY_ID"))
+	if keyID == "" {
+		keyID = defaultJWTKeyID
+	}
+
+	previous := map[string][]byte{}
+	secret := os.Getenv("JWT_PREVIOUS_SECRET")
+	previousID := strings.TrimSpace(os.Getenv("JWT_PREVIOUS_KEY_ID"))
+	if secret == "" {
+		if previousID != "" {
+			return keyID, previous, fmt.Errorf("JWT_PREVIOUS_KEY_ID is set without JWT_PREVIOUS_SECRET")
+		}
+		return keyID, previous, nil
+	}
+	if previousID == "" {
+		return keyID, previous, fmt.Errorf("JWT_PREVIOUS_SECRET requires JWT_PREVIOUS_KEY_ID")
+	}
+	if previousID == keyID {
+		return keyID, previous, fmt.Errorf("JWT_PREVIOUS_KEY_ID must differ from JWT_KEY_ID (%q)", keyID)
+	}
+	previous[previousID] = []byte(secret)
+	return keyID, previous, nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// Defaults for retrying the database connection at startup
+const (
+	defaultDBConnectAttempts = 5
+	defaultDBConnectBackoff  = 500 * time.Millisecond
+	maxDBConnectBackoff      = 10 * time.Second
+)
+
+const (
+	// defaultDBHealthInterval is how often the database is pinged while healthy
+	defaultDBHealthInterval = 5 * time.Second
+
+	// dbPingTimeout bounds a single health check
+	dbPingTimeout = 2 * time.Second
+
+	// dbDownRetryAfter is the Retry-After hint, in seconds, sent while the
+	// database is unreachable
+	dbDownRetryAfter = 5
+
+	dbDownMessage = "Database unavailable, please retry later"
+)
+
+// dbRetryPolicy controls how often opening the database is retried before
+// startup gives up. The backoff doubles after each failed attempt.
+type dbRetryPolicy struct {
+	Attempts int
+	Backoff  time.Duration
+}
+
+// openDatabase opens the SQLite database at path, retrying with backoff so a
+// volume or file that isn't ready yet doesn't kill the server at startup.
+// Invalid configuration is rejected earlier by loadConfig and never retried.
+func openDatabase(path string, retry dbRetryPolicy) (*gorm.DB, error) {
+	attempts := max(retry.Attempts, 1)
+	backoff := retry.Backoff
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		var db *gorm.DB
+		db, err = gorm.Open(sqlite.Open(sqliteDSN(path)), &gorm.Config{TranslateError: true})
+		if err == nil {
+			if attempt > 1 {
+				log.Printf("Connected to database (%s) on attempt %d", path, attempt)
+			}
+			return db, nil
+		}
+		if attempt >= attempts {
+			break
+		}
+
+		log.Printf("Database (%s) unavailable on attempt %d/%d: %v; retrying in %s", path, attempt, attempts, err, backoff)
+		time.Sleep(backoff)
+		backoff = min(backoff*2, maxDBConnectBackoff)
+	}
+	return nil, fmt.Errorf("giving up after %d attempts: %w", attempts, err)
+}
+
+// sqliteDSN adds the connection options the server relies on to path.
+// Transactions begin immediate, taking the write lock up front: a deferred
+// transaction that reads before it writes can't wait for the lock when
+// another writer holds it, and fails with "database is locked" instead.
+func sqliteDSN(path string) string {
+	separator := "?"
+	if strings.Contains(path, "?") {
+		separator = "&"
+	}
+	return path + separator + "_txlock=immediate"
+}
+
+// pingDatabase checks that the database answers within dbPingTimeout
+func (s *Server) pingDatabase(ctx context.Context) error {
+	sqlDB, err := s.db.DB()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, dbPingTimeout)
+	defer cancel()
+	return sqlDB.PingContext(ctx)
+}
+
+// dbHealthJob pings the database on an interval. When a ping fails the
+// server is marked not ready and the ping is retried with backoff, which
+// lets the connection pool reconnect, until the database answers again.
+func (s *Server) dbHealthJob(interval, backoff time.Duration) job {
+	return job{
+		name:     "db-health",
+		interval: interval,
+		run: func(ctx context.Context) {
+			err := s.pingDatabase(ctx)
+			if err == nil {
+				return
+			}
+
+			s.dbHealthy.Store(false)
+			wait := max(backoff, 100*time.Millisecond)
+			for err != nil {
+				log.Printf("Database unreachable: %v; retrying in %s", err, wait)
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(wait):
+				}
+				wait = min(wait*2, maxDBConnectBackoff)
+				err = s.pingDatabase(ctx)
+			}
+
+			log.Printf("Database reachable again")
+			s.dbHealthy.Store(true)
+		},
+	}
+}
+
+// requireDatabase rejects mutating requests with 503 while the database is
+// unreachable, rather than letting them fail with 500s
+func (s *Server) requireDatabase() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			if !s.dbHealthy.Load() {
+				c.Header("Retry-After", strconv.Itoa(dbDownRetryAfter))
+				c.AbortWithStatusJSON(503, gin.H{"error": dbDownMessage})
+				return
+			}
+		}
+		c.Next()
+	}
+}
+
+// healthz reports that the process is up
+func healthz(c *gin.Context) {
+	c.JSON(200, gin.H{"status": "ok"})
+}
+
+// readyz reports whether the server can take traffic, which it can't while
+// the database is unreachable
+func (s *Server) readyz(c *gin.Context) {
+	if !s.dbHealthy.Load() {
+		c.JSON(503, gin.H{"status": "not ready", "database": "unreachable"})
+		return
+	}
+	c.JSON(200, gin.H{"status": "ready", "database": "ok"})
+}
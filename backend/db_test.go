@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// captureLogs collects the standard logger's output for the rest of the test
+func captureLogs(t *testing.T) *bytes.Buffer {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return &logs
+}
+
+func TestOpenDatabaseRetries(t *testing.T) {
+	logs := captureLogs(t)
+
+	// The directory doesn't exist, so SQLite can't create the file
+	path := filepath.Join(t.TempDir(), "missing", "test.db")
+	start := time.Now()
+	_, err := openDatabase(path, dbRetryPolicy{Attempts: 3, Backoff: 10 * time.Millisecond})
+	if err == nil || !strings.Contains(err.Error(), "giving up after 3 attempts") {
+		t.Fatalf("err = %v, want giving up after 3 attempts", err)
+	}
+	if retries := strings.Count(logs.String(), "unavailable on attempt"); retries != 2 {
+		t.Errorf("logged %d retries, want 2:\n%s", retries, logs)
+	}
+	// The backoff doubles: 10ms, then 20ms
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Errorf("gave up after %s, want at least 30ms of backoff", elapsed)
+	}
+}
+
+func TestOpenDatabaseRecovers(t *testing.T) {
+	logs := captureLogs(t)
+	dir := filepath.Join(t.TempDir(), "late")
+
+	// The volume shows up while the server is waiting for it
+	go func() {
+		time.Sleep(30 * time.Millisecond)
+		os.Mkdir(dir, 0o755)
+	}()
+	db, err := openDatabase(filepath.Join(dir, "test.db"), dbRetryPolicy{Attempts: 10, Backoff: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("openDatabase: %v", err)
+	}
+	if sqlDB, err := db.DB(); err == nil {
+		sqlDB.Close()
+	}
+	if !strings.Contains(logs.String(), "Connected to database") {
+		t.Errorf("log = %q, want the attempt that connected", logs)
+	}
+}
+
+func TestDatabaseConfigFailsFast(t *testing.T) {
+	tests := map[string]map[string]string{
+		"directory":   {"DB_PATH": t.TempDir()},
+		"no attempts": {"DB_CONNECT_ATTEMPTS": "0"},
+		"negative":    {"DB_CONNECT_BACKOFF": "-1s"},
+	}
+	for name, env := range tests {
+		t.Run(name, func(t *testing.T) {
+			for key, value := range env {
+				t.Setenv(key, value)
+			}
+			if _, err := loadConfig(); err == nil {
+				t.Fatal("loadConfig accepted the configuration")
+			}
+		})
+	}
+}
+
+func TestDatabaseDownThenUp(t *testing.T) {
+	quietLogs(t)
+	cfg := newTestConfig(t, nil)
+	volume := filepath.Join(t.TempDir(), "volume")
+	if err := os.Mkdir(volume, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	cfg.DBPath = filepath.Join(volume, "test.db")
+	s := NewServer(cfg)
+	sqlDB, err := s.db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+	r, err := s.router(cfg)
+	if err != nil {
+		t.Fatalf("router: %v", err)
+	}
+	_, token := createTestSession(t, s, createTestUser(t, s, "trader", roleUser))
+
+	// Without idle connections every ping reconnects, so moving the volume
+	// away makes the database unreachable until it is moved back
+	sqlDB.SetMaxIdleConns(0)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	check := s.dbHealthJob(time.Hour, 10*time.Millisecond).run
+
+	waitHealthy := func(want bool) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for s.dbHealthy.Load() != want {
+			if time.Now().After(deadline) {
+				t.Fatalf("database healthy still %t", !want)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	moved := volume + ".gone"
+	if err := os.Rename(volume, moved); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		check(ctx)
+		close(done)
+	}()
+	waitHealthy(false)
+
+	if w := doRequest(r, "GET", "/readyz", "", ""); w.Code != 503 {
+		t.Errorf("readyz while down: status %d, want 503", w.Code)
+	}
+	w := doRequest(r, "POST", "/api/orders", token, `{"symbol":"AAPL","side":"buy","quantity":1}`)
+	if w.Code != 503 || w.Header().Get("Retry-After") == "" {
+		t.Errorf("order while down: status %d, Retry-After %q; want 503 with Retry-After", w.Code, w.Header().Get("Retry-After"))
+	}
+	if w := doRequest(r, "GET", "/healthz", "", ""); w.Code != 200 {
+		t.Errorf("healthz while down: status %d, want 200", w.Code)
+	}
+
+	if err := os.Rename(moved, volume); err != nil {
+		t.Fatal(err)
+	}
+	waitHealthy(true)
+	<-done
+
+	if w := doRequest(r, "GET", "/readyz", "", ""); w.Code != 200 {
+		t.Errorf("readyz after recovery: status %d, want 200", w.Code)
+	}
+	if w := doRequest(r, "POST", "/api/orders", token, `{"symbol":"AAPL","side":"buy","quantity":1}`); w.Code != 201 {
+		t.Errorf("order after recovery: status %d: %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+// symbolDecimals maps each tracked symbol to its PriceDecimals. It is filled
+// once by NewServer and only read afterwards, so JSON marshaling of orders
+// and trades can format prices without a Server.
+var symbolDecimals = map[string]int{}
+
+// decimalsFor returns the price precision for symbol, falling back to the
+// default for symbols that are no longer tracked
+func decimalsFor(symbol string) int {
+	if decimals, ok := symbolDecimals[symbol]; ok {
+		return decimals
+	}
+	return defaultPriceDecimals
+}
+
+// fixed formats v with exactly decimals decimal places as a JSON number, so
+// float noise like 138.20000000000002 never reaches clients
+func fixed(v float64, decimals int) json.Number {
+	return json.Number(strconv.FormatFloat(v, 'f', decimals, 64))
+}
+
+// MarshalJSON writes the stock's prices to its configured precision
+func (st Stock) MarshalJSON() ([]byte, error) {
+	type stockJSON Stock
+	return json.Marshal(struct {
+		stockJSON
+		Price json.Number `json:"price"`
+		Bid   json.Number `json:"bid"`
+		Ask   json.Number `json:"ask"`
+	}{
+		stockJSON: stockJSON(st),
+		Price:     fixed(st.Price, st.PriceDecimals),
+		Bid:       fixed(st.Bid, st.PriceDecimals),
+		Ask:       fixed(st.Ask, st.PriceDecimals),
+	})
+}
+
+// MarshalJSON writes the order price, and the notional it adds, to its
+// symbol's precision
+func (o Order) MarshalJSON() ([]byte, error) {
+	type orderJSON Order
+	decimals := decimalsFor(o.Symbol)
+	return json.Marshal(struct {
+		orderJSON
+		Price    json.Number `json:"price"`
+		Notional json.Number `json:"notional"`
+	}{
+		orderJSON: orderJSON(o),
+		Price:     fixed(o.Price, decimals),
+		Notional:  fixed(orderNotional(o.Symbol, o.Quantity, o.Price), decimals),
+	})
+}
+
+// MarshalJSON writes the trade price to its symbol's precision
+func (t Trade) MarshalJSON() ([]byte, error) {
+	type tradeJSON Trade
+	return json.Marshal(struct {
+		tradeJSON
+		Price json.Number `json:"price"`
+	}{
+		tradeJSON: tradeJSON(t),
+		Price:     fixed(t.Price, decimalsFor(t.Symbol)),
+	})
+}
+
+// MarshalJSON writes the preview's amounts, which are in the symbol's
+// currency, to the symbol's precision
+func (p OrderPreview) MarshalJSON() ([]byte, error) {
+	type previewJSON OrderPreview
+	decimals := decimalsFor(p.Symbol)
+	return json.Marshal(struct {
+		previewJSON
+		Price    json.Number `json:"price"`
+		Notional json.Number `json:"notional"`
+		Fee      json.Number `json:"fee"`
+		Total    json.Number `json:"total"`
+	}{
+		previewJSON: previewJSON(p),
+		Price:       fixed(p.Price, decimals),
+		Notional:    fixed(p.Notional, decimals),
+		Fee:         fixed(p.Fee, decimals),
+		Total:       fixed(p.Total, decimals),
+	})
+}
+
+// MarshalJSON writes the summary's notional, converted to USD from every
+// symbol's currency, to the default precision
+func (t TradeSummary) MarshalJSON() ([]byte, error) {
+	type summaryJSON TradeSummary
+	return json.Marshal(struct {
+		summaryJSON
+		TotalNotional json.Number `json:"total_notional"`
+	}{
+		summaryJSON:   summaryJSON(t),
+		TotalNotional: fixed(t.TotalNotional, defaultPriceDecimals),
+	})
+}
+
+// MarshalJSON writes the average prices to the symbol's precision
+func (a SymbolAggregate) MarshalJSON() ([]byte, error) {
+	type aggregateJSON SymbolAggregate
+	decimals := decimalsFor(a.Symbol)
+	return json.Marshal(struct {
+		aggregateJSON
+		AvgBuyPrice  json.Number `json:"avg_buy_price"`
+		AvgSellPrice json.Number `json:"avg_sell_price"`
+	}{
+		aggregateJSON: aggregateJSON(a),
+		AvgBuyPrice:   fixed(a.AvgBuyPrice, decimals),
+		AvgSellPrice:  fixed(a.AvgSellPrice, decimals),
+	})
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestPriceDecimals(t *testing.T) {
+	quietLogs(t)
+	s, r := newTestRouter(t, map[string]string{"PRICE_DECIMALS": "INFY:3,TCS:0"})
+	want := map[string]int{"INFY": 3, "TCS": 0, "AAPL": defaultPriceDecimals}
+
+	// Simulated prices stay on each symbol's precision
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 50; i++ {
+		s.stepPrices(rng)
+	}
+	s.stocksLock.RLock()
+	for symbol, decimals := range want {
+		price := s.stocks[symbol].Price
+		if price != roundDecimals(price, decimals) {
+			t.Errorf("%s price %v has more than %d decimals", symbol, price, decimals)
+		}
+	}
+	s.stocksLock.RUnlock()
+
+	// and are written with exactly that many decimals
+	w := doRequest(r, "GET", "/api/prices", "", "")
+	var prices []map[string]json.RawMessage
+	if err := json.Unmarshal(w.Body.Bytes(), &prices); w.Code != 200 || err != nil {
+		t.Fatalf("prices: status %d: %s", w.Code, w.Body)
+	}
+	for _, price := range prices {
+		var symbol string
+		json.Unmarshal(price["symbol"], &symbol)
+		decimals, ok := want[symbol]
+		if !ok {
+			continue
+		}
+		for _, field := range []string{"price", "bid", "ask"} {
+			raw := string(price[field])
+			got := 0
+			if dot := strings.IndexByte(raw, '.'); dot >= 0 {
+				got = len(raw) - dot - 1
+			}
+			if got != decimals {
+				t.Errorf("%s %s = %s, want %d decimals", symbol, field, raw, decimals)
+			}
+		}
+	}
+}
+
+func TestPriceDecimalsConfig(t *testing.T) {
+	for _, value := range []string{"TCS:1.5", "TCS:-1", "TCS:9", "TCS"} {
+		t.Run(value, func(t *testing.T) {
+			t.Setenv("PRICE_DECIMALS", value)
+			if _, err := loadConfig(); err == nil {
+				t.Fatalf("PRICE_DECIMALS=%s accepted", value)
+			}
+		})
+	}
+}
+
+// excessDecimals returns the first number in the JSON body with more than
+// two decimal places, or "" if there is none
+func excessDecimals(t *testing.T, body []byte) string {
+	t.Helper()
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		t.Fatalf("decoding %s: %v", body, err)
+	}
+
+	var walk func(v interface{}) string
+	walk = func(v interface{}) string {
+		switch v := v.(type) {
+		case json.Number:
+			if dot := strings.IndexByte(string(v), '.'); dot >= 0 && len(v)-dot-1 > 2 {
+				return string(v)
+			}
+		case []interface{}:
+			for _, elem := range v {
+				if found := walk(elem); found != "" {
+					return found
+				}
+			}
+		case map[string]interface{}:
+			for _, elem := range v {
+				if found := walk(elem); found != "" {
+					return found
+				}
+			}
+		}
+		return ""
+	}
+	return walk(v)
+}
+
+func TestResponsesHaveNoExcessDecimals(t *testing.T) {
+	s, r := newTestRouter(t, nil)
+	user := createTestUser(t, s, "trader", roleUser)
+	_, token := createTestSession(t, s, user)
+
+	// Float noise of the kind 138.2 * 3 produces
+	noisy := 138.20000000000002
+	s.stocksLock.Lock()
+	s.stocks["AMZN"].Price = noisy
+	s.stocks["AMZN"].updateQuote()
+	s.snapshot = nil
+	s.stocksLock.Unlock()
+	createFilledOrder(t, s, user.ID, "AMZN", sideBuy, 3, noisy)
+	createFilledOrder(t, s, user.ID, "AMZN", sideSell, 1, noisy+0.1)
+
+	for _, path := range []string{"/api/prices", "/api/orders", "/api/orders/by-symbol", "/api/me/summary", "/api/trades/recent"} {
+		w := doRequest(r, "GET", path, token, "")
+		if w.Code != 200 {
+			t.Fatalf("%s: status %d: %s", path, w.Code, w.Body)
+		}
+		if match := excessDecimals(t, w.Body.Bytes()); match != "" {
+			t.Errorf("%s has excess decimals %q in %s", path, match, w.Body)
+		}
+	}
+
+	w := doRequest(r, "POST", "/api/orders/preview", token, `{"symbol":"AMZN","side":"buy","quantity":3}`)
+	if w.Code != 200 {
+		t.Fatalf("preview: status %d: %s", w.Code, w.Body)
+	}
+	if match := excessDecimals(t, w.Body.Bytes()); match != "" {
+		t.Errorf("preview has excess decimals %q in %s", match, w.Body)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// requestCodeInvalid is the code for request bodies that aren't the JSON
+// object the endpoint expects
+const requestCodeInvalid = "INVALID_REQUEST"
+
+// requestError explains why a request body couldn't be decoded. Field is
+// the offending JSON field, when there is one.
+type requestError struct {
+	Message string
+	Field   string
+}
+
+// body returns the JSON error response for e
+func (e *requestError) body() gin.H {
+	body := gin.H{"error": e.Message, "code": requestCodeInvalid}
+	if e.Field != "" {
+		body["field"] = e.Field
+	}
+	return body
+}
+
+// decodeStrict decodes data, which must hold exactly one JSON value, into
+// v. Unlike ShouldBindJSON it rejects fields v doesn't have, so typos such
+// as "qty" for "quantity" fail instead of being silently dropped.
+func decodeStrict(data []byte, v interface{}) *requestError {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(v)
+	if err == nil {
+		if dec.More() {
+			return &requestError{Message: "Request body must be a single JSON object"}
+		}
+		return nil
+	}
+
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.Is(err, io.EOF):
+		return &requestError{Message: "Request body is required"}
+	case errors.As(err, &syntaxErr), errors.Is(err, io.ErrUnexpectedEOF):
+		return &requestError{Message: "Request body is not valid JSON"}
+	case errors.As(err, &typeErr):
+		if typeErr.Field == "" {
+			return &requestError{Message: "Request body must be a JSON object"}
+		}
+		return &requestError{Message: fmt.Sprintf("%s must be %s", typeErr.Field, jsonKind(typeErr.Type)), Field: typeErr.Field}
+	}
+
+	// The decoder has no typed error for unknown fields
+	if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+		field = strings.Trim(field, `"`)
+		return &requestError{Message: fmt.Sprintf("Unknown field %q", field), Field: field}
+	}
+	return &requestError{Message: "Invalid request"}
+}
+
+// jsonKind describes the JSON value expected for a Go type, for error
+// messages
+func jsonKind(t reflect.Type) string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return "true or false"
+	case reflect.String:
+		return "a string"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	case reflect.Map, reflect.Struct:
+		return "an object"
+	default:
+		return "a number"
+	}
+}
+
+// bindStrictJSON decodes the request body into v with decodeStrict. On
+// failure it responds with 400 and reports false.
+func bindStrictJSON(c *gin.Context, v interface{}) bool {
+	data, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Failed to read request body", "code": requestCodeInvalid})
+		return false
+	}
+	if reqErr := decodeStrict(data, v); reqErr != nil {
+		c.JSON(400, reqErr.body())
+		return false
+	}
+	return true
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestDecodeStrict(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		message string
+		field   string
+	}{
+		{"valid", `{"symbol":"AAPL","side":"buy","quantity":1}`, "", ""},
+		{"misspelled field", `{"symbol":"AAPL","side":"buy","qty":1}`, `Unknown field "qty"`, "qty"},
+		{"wrong type", `{"symbol":"AAPL","side":"buy","quantity":"1"}`, "quantity must be a number", "quantity"},
+		{"not an object", `[1]`, "Request body must be a JSON object", ""},
+		{"trailing value", `{"symbol":"AAPL"} {}`, "Request body must be a single JSON object", ""},
+		{"invalid JSON", `{"symbol":`, "Request body is not valid JSON", ""},
+		{"empty", ``, "Request body is required", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var req OrderRequest
+			err := decodeStrict([]byte(tt.body), &req)
+			if tt.message == "" {
+				if err != nil {
+					t.Fatalf("unexpected error %q", err.Message)
+				}
+				return
+			}
+			if err == nil || err.Message != tt.message || err.Field != tt.field {
+				t.Fatalf("error = %+v, want %q on %q", err, tt.message, tt.field)
+			}
+		})
+	}
+}
+
+func TestUnknownFieldsAreRejected(t *testing.T) {
+	s, r := newTestRouter(t, nil)
+	user := createTestUser(t, s, "trader", roleUser)
+	_, token := createTestSession(t, s, user)
+
+	tests := []struct {
+		name, path, token, body, field string
+	}{
+		{"login", "/api/login", "", `{"username":"trader","passwrod":"password123"}`, "passwrod"},
+		{"signup", "/api/signup", "", `{"username":"newbie","password":"Correct-Horse-9","emial":"a@b.c"}`, "emial"},
+		{"order", "/api/orders", token, `{"symbol":"AAPL","side":"buy","qty":1}`, "qty"},
+		{"preview", "/api/orders/preview", token, `{"symbol":"AAPL","side":"buy","qty":1}`, "qty"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := doRequest(r, "POST", tt.path, tt.token, tt.body)
+			var body struct {
+				Error string `json:"error"`
+				Code  string `json:"code"`
+				Field string `json:"field"`
+			}
+			json.Unmarshal(w.Body.Bytes(), &body)
+			if w.Code != 400 || body.Code != requestCodeInvalid || body.Field != tt.field {
+				t.Fatalf("status %d, body %s; want 400 %s on %q", w.Code, w.Body, requestCodeInvalid, tt.field)
+			}
+		})
+	}
+
+	// Nothing was created from the misspelled bodies
+	var orders, users int64
+	s.db.Model(&Order{}).Count(&orders)
+	s.db.Model(&User{}).Where("username = ?", "newbie").Count(&users)
+	if orders != 0 || users != 0 {
+		t.Errorf("%d orders and %d users created from rejected bodies", orders, users)
+	}
+}
+
+func TestUnknownWebSocketFieldsAreRejected(t *testing.T) {
+	s := newTestServer(t, nil)
+	user := createTestUser(t, s, "trader", roleUser)
+	_, token := createTestSession(t, s, user)
+	conn := dialTestWebSocket(t, newTestWebSocketServer(t, s), token)
+
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"action":"order","order":{"symbol":"AAPL","side":"buy","qty":1}}`)); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var reply errorMessage
+	if err := conn.ReadJSON(&reply); err != nil || reply.Type != "error" || reply.Status != 400 || reply.Field != "qty" || reply.Error != `Unknown field "qty"` {
+		t.Fatalf("reply = %+v, %v", reply, err)
+	}
+}
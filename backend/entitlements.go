@@ -0,0 +1,162 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Entitlement allows a user to trade one symbol. A user with no
+// entitlements may trade every symbol.
+type Entitlement struct {
+	ID     uint   `gorm:"primaryKey"`
+	UserID uint   `gorm:"not null;uniqueIndex:idx_entitlements_user_symbol"`
+	Symbol string `gorm:"not null;uniqueIndex:idx_entitlements_user_symbol"`
+}
+
+// EntitlementsRequest replaces a user's entitlements; an empty list lifts
+// the restriction
+type EntitlementsRequest struct {
+	Symbols *[]string `json:"symbols"`
+}
+
+// EntitlementsResponse lists the symbols a user may trade
+type EntitlementsResponse struct {
+	UserID uint `json:"user_id"`
+
+	// Symbols is empty when the user may trade every symbol
+	Symbols []string `json:"symbols"`
+}
+
+// entitledSymbols returns the symbols userID may trade, or nil if the user
+// isn't restricted
+func (s *Server) entitledSymbols(userID uint) (map[string]bool, error) {
+	var rows []Entitlement
+	if err := s.db.Where("user_id = ?", userID).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+
+	symbols := make(map[string]bool, len(rows))
+	for _, row := range rows {
+		symbols[row.Symbol] = true
+	}
+	return symbols, nil
+}
+
+// checkEntitlement rejects an order for a symbol the user may not trade
+func (s *Server) checkEntitlement(userID uint, symbol string) *orderError {
+	symbols, err := s.entitledSymbols(userID)
+	if err != nil {
+		return &orderError{Status: 500, Code: orderCodeInternal, Message: "Failed to check entitlements"}
+	}
+	if symbols != nil && !symbols[symbol] {
+		return &orderError{Status: 403, Code: orderCodeNotEntitled, Message: "Not entitled to trade " + symbol}
+	}
+	return nil
+}
+
+// optionalUserID returns the user of a valid Bearer token (or auth cookie)
+// on a public endpoint. A missing or invalid token means an anonymous caller.
+func (s *Server) optionalUserID(c *gin.Context) (uint, bool) {
+	tokenString, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !ok {
+		tokenString = s.cookieToken(c)
+	}
+	if tokenString == "" {
+		return 0, false
+	}
+	claims, err := s.authenticate(tokenString)
+	if err != nil {
+		return 0, false
+	}
+	return claims.UserID, true
+}
+
+// entitlementsUser resolves the :id parameter to an existing user
+func (s *Server) entitlementsUser(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil || id == 0 {
+		c.JSON(400, gin.H{"error": "Invalid user id"})
+		return 0, false
+	}
+
+	var count int64
+	if err := s.db.Model(&User{}).Where("id = ?", id).Count(&count).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to fetch user"})
+		return 0, false
+	}
+	if count == 0 {
+		c.JSON(404, gin.H{"error": "User not found"})
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// respondEntitlements writes userID's entitlements in sorted order
+func (s *Server) respondEntitlements(c *gin.Context, userID uint) {
+	entitled, err := s.entitledSymbols(userID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to fetch entitlements"})
+		return
+	}
+
+	resp := EntitlementsResponse{UserID: userID, Symbols: make([]string, 0, len(entitled))}
+	for symbol := range entitled {
+		resp.Symbols = append(resp.Symbols, symbol)
+	}
+	sort.Strings(resp.Symbols)
+	c.JSON(200, resp)
+}
+
+// getEntitlements returns the symbols a user may trade
+func (s *Server) getEntitlements(c *gin.Context) {
+	userID, ok := s.entitlementsUser(c)
+	if !ok {
+		return
+	}
+	s.respondEntitlements(c, userID)
+}
+
+// setEntitlements replaces the symbols a user may trade
+func (s *Server) setEntitlements(c *gin.Context) {
+	adminID, _ := c.Get("user_id")
+	userID, ok := s.entitlementsUser(c)
+	if !ok {
+		return
+	}
+
+	var req EntitlementsRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Symbols == nil {
+		c.JSON(400, gin.H{"error": "Invalid request"})
+		return
+	}
+	symbols, ok := s.normalizeSymbols(*req.Symbols)
+	if !ok {
+		c.JSON(400, gin.H{"error": "Unknown symbol"})
+		return
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&Entitlement{}).Error; err != nil {
+			return err
+		}
+		for symbol := range symbolSet(symbols) {
+			if err := tx.Create(&Entitlement{UserID: userID, Symbol: symbol}).Error; err != nil {
+				return err
+			}
+		}
+		return recordAudit(tx, adminID.(uint), "user.entitlements", "user", userID)
+	})
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to update entitlements"})
+		return
+	}
+
+	s.respondEntitlements(c, userID)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"testing"
+)
+
+// priceSymbols returns the symbols GET /api/prices lists for token
+func priceSymbols(t *testing.T, h http.Handler, token string) []string {
+	t.Helper()
+	w := doRequest(h, "GET", "/api/prices", token, "")
+	var prices []struct {
+		Symbol string `json:"symbol"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &prices); w.Code != 200 || err != nil {
+		t.Fatalf("prices: status %d: %s", w.Code, w.Body)
+	}
+	symbols := make([]string, 0, len(prices))
+	for _, price := range prices {
+		symbols = append(symbols, price.Symbol)
+	}
+	sort.Strings(symbols)
+	return symbols
+}
+
+// setTestEntitlements replaces the user's entitlements through the admin route
+func setTestEntitlements(t *testing.T, h http.Handler, adminToken string, user User, body string) {
+	t.Helper()
+	path := "/api/admin/users/" + jsonNumber(user.ID) + "/entitlements"
+	if w := doRequest(h, "POST", path, adminToken, body); w.Code != 200 {
+		t.Fatalf("setting entitlements: status %d: %s", w.Code, w.Body)
+	}
+}
+
+func jsonNumber(id uint) string {
+	encoded, _ := json.Marshal(id)
+	return string(encoded)
+}
+
+func TestEntitlementsRestrictOrders(t *testing.T) {
+	s, r := newTestRouter(t, nil)
+	_, adminToken := createTestSession(t, s, seededAdmin(t, s))
+	user := createTestUser(t, s, "trader", roleUser)
+	other := createTestUser(t, s, "other", roleUser)
+	setTestEntitlements(t, r, adminToken, user, `{"symbols":["AAPL","TSLA"]}`)
+
+	for _, symbol := range []string{"AAPL", "TSLA"} {
+		if _, err := s.placeOrder(user.ID, false, OrderRequest{Symbol: symbol, Side: sideBuy, Quantity: 1}); err != nil {
+			t.Errorf("entitled %s: %s", symbol, err.Message)
+		}
+	}
+	if _, err := s.placeOrder(user.ID, false, OrderRequest{Symbol: "AMZN", Side: sideBuy, Quantity: 1}); err == nil || err.Status != 403 || err.Code != orderCodeNotEntitled {
+		t.Errorf("non-entitled AMZN = %v, want 403 %s", err, orderCodeNotEntitled)
+	}
+
+	// Users without entitlements trade everything
+	if _, err := s.placeOrder(other.ID, false, OrderRequest{Symbol: "AMZN", Side: sideBuy, Quantity: 1}); err != nil {
+		t.Errorf("unrestricted user: %s", err.Message)
+	}
+
+	// An empty list lifts the restriction
+	setTestEntitlements(t, r, adminToken, user, `{"symbols":[]}`)
+	if _, err := s.placeOrder(user.ID, false, OrderRequest{Symbol: "AMZN", Side: sideBuy, Quantity: 1}); err != nil {
+		t.Errorf("AMZN after lifting the restriction: %s", err.Message)
+	}
+}
+
+func TestEntitlementsFilterPrices(t *testing.T) {
+	s, r := newTestRouter(t, nil)
+	_, adminToken := createTestSession(t, s, seededAdmin(t, s))
+	user := createTestUser(t, s, "trader", roleUser)
+	_, token := createTestSession(t, s, user)
+	other := createTestUser(t, s, "other", roleUser)
+	_, otherToken := createTestSession(t, s, other)
+	all := priceSymbols(t, r, "")
+
+	setTestEntitlements(t, r, adminToken, user, `{"symbols":["TSLA","AAPL"]}`)
+	if got := priceSymbols(t, r, token); len(got) != 2 || got[0] != "AAPL" || got[1] != "TSLA" {
+		t.Fatalf("entitled user sees %v, want [AAPL TSLA]", got)
+	}
+	if got := priceSymbols(t, r, otherToken); len(got) != len(all) {
+		t.Fatalf("unrestricted user sees %d symbols, want %d", len(got), len(all))
+	}
+	if got := priceSymbols(t, r, ""); len(got) != len(all) || len(all) <= 2 {
+		t.Fatalf("anonymous caller sees %d symbols, want all %d", len(got), len(all))
+	}
+}
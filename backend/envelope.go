@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// envelopeMediaType in the Accept header asks for an enveloped response
+// when RESPONSE_ENVELOPE is off
+const envelopeMediaType = "application/vnd.trading-dashboard.envelope+json"
+
+// responseMeta is the metadata sent alongside every enveloped response
+type responseMeta struct {
+	ServerTime time.Time `json:"server_time"`
+	RequestID  string    `json:"request_id"`
+}
+
+// envelopeWriter holds back a handler's response body so it can be wrapped
+// once the handler is done. A flush means the handler is streaming, so the
+// body is passed through as is from then on.
+type envelopeWriter struct {
+	gin.ResponseWriter
+	body        bytes.Buffer
+	passthrough bool
+}
+
+func (w *envelopeWriter) Write(b []byte) (int, error) {
+	if w.passthrough {
+		return w.ResponseWriter.Write(b)
+	}
+	return w.body.Write(b)
+}
+
+func (w *envelopeWriter) WriteString(s string) (int, error) {
+	if w.passthrough {
+		return w.ResponseWriter.WriteString(s)
+	}
+	return w.body.WriteString(s)
+}
+
+// Written counts held back bytes, so recovery doesn't write a second body
+// after a handler that panicked mid-response
+func (w *envelopeWriter) Written() bool {
+	return w.body.Len() > 0 || w.ResponseWriter.Written()
+}
+
+func (w *envelopeWriter) Flush() {
+	if !w.passthrough {
+		w.passthrough = true
+		if w.body.Len() > 0 {
+			w.ResponseWriter.Write(w.body.Bytes())
+			w.body.Reset()
+		}
+	}
+	w.ResponseWriter.Flush()
+}
+
+// responseEnvelope wraps JSON responses as {"data": ..., "meta": {...}} for
+// every request when always is set, and otherwise for requests that Accept
+// envelopeMediaType. Error objects keep their shape and gain the "meta" key.
+// Other responses, downloads, streams and WebSocket connections are left
+// alone.
+func responseEnvelope(always bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !always && !strings.Contains(c.GetHeader("Accept"), envelopeMediaType) {
+			c.Next()
+			return
+		}
+
+		original := c.Writer
+		w := &envelopeWriter{ResponseWriter: original}
+		c.Writer = w
+		c.Next()
+		c.Writer = original
+
+		if w.passthrough || w.body.Len() == 0 {
+			return
+		}
+		// Downloads such as the account export are files, not API responses
+		body := w.body.Bytes()
+		header := original.Header()
+		if strings.HasPrefix(header.Get("Content-Type"), "application/json") && header.Get("Content-Disposition") == "" {
+			if wrapped, err := envelope(body, c.Writer.Status(), responseMeta{ServerTime: time.Now().UTC(), RequestID: c.GetString("request_id")}); err == nil {
+				body = wrapped
+			} else {
+				log.Printf("Error enveloping response: %v", err)
+			}
+		}
+		original.Write(body)
+	}
+}
+
+// envelope wraps a JSON body with meta. Successful responses become the
+// envelope's data; error objects have meta added alongside their fields.
+func envelope(body []byte, status int, meta responseMeta) ([]byte, error) {
+	if status >= 400 {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(body, &fields); err == nil {
+			encoded, err := json.Marshal(meta)
+			if err != nil {
+				return nil, err
+			}
+			fields["meta"] = encoded
+			return json.Marshal(fields)
+		}
+	}
+	return json.Marshal(struct {
+		Data json.RawMessage `json:"data"`
+		Meta responseMeta    `json:"meta"`
+	}{Data: body, Meta: meta})
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// envelopedBody is the shape of an enveloped success response
+type envelopedBody struct {
+	Data json.RawMessage `json:"data"`
+	Meta *responseMeta   `json:"meta"`
+}
+
+// requestWithAccept sends a request like doRequest with an Accept header
+func requestWithAccept(h http.Handler, method, path, token, accept string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	req.Header.Set("Accept", accept)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+// checkMeta fails unless meta carries the response's request id and a
+// current server time
+func checkMeta(t *testing.T, w *httptest.ResponseRecorder, meta *responseMeta) {
+	t.Helper()
+	if meta == nil {
+		t.Fatalf("no meta in %s", w.Body)
+	}
+	if meta.RequestID == "" || meta.RequestID != w.Header().Get(requestIDHeader) {
+		t.Errorf("meta request id %q, header %q", meta.RequestID, w.Header().Get(requestIDHeader))
+	}
+	if time.Since(meta.ServerTime) > time.Minute || meta.ServerTime.Location() != time.UTC {
+		t.Errorf("meta server time %v", meta.ServerTime)
+	}
+}
+
+func TestResponsesBareByDefault(t *testing.T) {
+	s, r := newTestRouter(t, nil)
+	_, token := createTestSession(t, s, createTestUser(t, s, "trader", roleUser))
+
+	w := doRequest(r, "GET", "/api/prices", "", "")
+	var prices []Stock
+	if err := json.Unmarshal(w.Body.Bytes(), &prices); w.Code != 200 || err != nil || len(prices) == 0 {
+		t.Fatalf("prices: status %d: %s", w.Code, w.Body)
+	}
+	w = doRequest(r, "POST", "/api/orders", token, `{"symbol":"AAPL","side":"buy","quantity":1}`)
+	if w.Code != 201 || strings.Contains(w.Body.String(), `"meta"`) {
+		t.Errorf("order: status %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestResponseEnvelope(t *testing.T) {
+	s, r := newTestRouter(t, map[string]string{"RESPONSE_ENVELOPE": "true"})
+	_, token := createTestSession(t, s, createTestUser(t, s, "trader", roleUser))
+
+	w := doRequest(r, "GET", "/api/prices", "", "")
+	var body envelopedBody
+	if err := json.Unmarshal(w.Body.Bytes(), &body); w.Code != 200 || err != nil {
+		t.Fatalf("prices: status %d: %s", w.Code, w.Body)
+	}
+	var prices []Stock
+	if err := json.Unmarshal(body.Data, &prices); err != nil || len(prices) == 0 {
+		t.Fatalf("data = %s, want the prices", body.Data)
+	}
+	checkMeta(t, w, body.Meta)
+
+	// The handler's status is kept
+	w = doRequest(r, "POST", "/api/orders", token, `{"symbol":"AAPL","side":"buy","quantity":1}`)
+	body = envelopedBody{}
+	var order Order
+	if err := json.Unmarshal(w.Body.Bytes(), &body); w.Code != 201 || err != nil || json.Unmarshal(body.Data, &order) != nil || order.Symbol != "AAPL" {
+		t.Fatalf("order: status %d: %s", w.Code, w.Body)
+	}
+
+	// Errors keep their fields and gain meta
+	w = doRequest(r, "POST", "/api/orders", token, `{"symbol":"ZZZZ","side":"buy","quantity":1}`)
+	var failure struct {
+		Error string        `json:"error"`
+		Code  string        `json:"code"`
+		Meta  *responseMeta `json:"meta"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &failure); w.Code != 400 || err != nil || failure.Code != orderCodeUnknownSymbol || failure.Error == "" {
+		t.Fatalf("rejected order: status %d: %s", w.Code, w.Body)
+	}
+	checkMeta(t, w, failure.Meta)
+
+	// Downloads are files, not API responses
+	w = doRequest(r, "GET", "/api/me/export", token, "")
+	if w.Code != 200 || w.Header().Get("Content-Disposition") == "" || strings.Contains(w.Body.String(), `"meta"`) {
+		t.Errorf("export: status %d, enveloped: %s", w.Code, w.Body)
+	}
+}
+
+func TestResponseEnvelopeOnRequest(t *testing.T) {
+	_, r := newTestRouter(t, nil)
+
+	w := requestWithAccept(r, "GET", "/api/prices", "", envelopeMediaType)
+	var body envelopedBody
+	if err := json.Unmarshal(w.Body.Bytes(), &body); w.Code != 200 || err != nil || len(body.Data) == 0 {
+		t.Fatalf("prices: status %d: %s", w.Code, w.Body)
+	}
+	checkMeta(t, w, body.Meta)
+
+	if w := requestWithAccept(r, "GET", "/api/prices", "", "application/json"); strings.Contains(w.Body.String(), `"meta"`) {
+		t.Errorf("plain Accept got an envelope: %s", w.Body)
+	}
+}
+
+func TestPanicsAreEnveloped(t *testing.T) {
+	quietLogs(t)
+	_, r := newTestRouter(t, map[string]string{"RESPONSE_ENVELOPE": "true"})
+	r.GET("/api/panic", func(c *gin.Context) {
+		panic("boom")
+	})
+
+	w := doRequest(r, "GET", "/api/panic", "", "")
+	var failure struct {
+		Code string        `json:"code"`
+		Meta *responseMeta `json:"meta"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &failure); w.Code != 500 || err != nil || failure.Code != "INTERNAL" {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	checkMeta(t, w, failure.Meta)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// exportCooldown is how often each user may download their account data;
+// an export reads every order they ever placed
+const exportCooldown = time.Minute
+
+// AccountExport is everything stored about a user, as downloaded from
+// /api/me/export. Password hashes, webhook secrets and session token ids
+// are left out.
+type AccountExport struct {
+	ExportedAt time.Time `json:"exported_at"`
+	User       User      `json:"user"`
+
+	// Orders includes archived orders, oldest first
+	Orders []Order `json:"orders"`
+
+	Webhook  *Webhook  `json:"webhook"`
+	Sessions []Session `json:"sessions"`
+
+	// Notifications is the user's inbox, oldest first
+	Notifications []Notification `json:"notifications"`
+
+	// Entitlements is empty when the user may trade every symbol
+	Entitlements []string `json:"entitlements"`
+}
+
+// exportAccount sends the authenticated user's data as a JSON attachment
+func (s *Server) exportAccount(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(401, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	now := time.Now()
+	if ok, retryAt := s.exportLimiter.allow(userID.(uint), now); !ok {
+		c.Header("Retry-After", strconv.Itoa(int(retryAt.Sub(now).Seconds())+1))
+		c.JSON(429, gin.H{"error": "Account data can only be exported once a minute", "reset_at": retryAt})
+		return
+	}
+
+	export := AccountExport{
+		ExportedAt:    now.UTC(),
+		Orders:        []Order{},
+		Sessions:      []Session{},
+		Notifications: []Notification{},
+		Entitlements:  []string{},
+	}
+	if err := s.db.First(&export.User, userID).Error; err != nil {
+		c.JSON(404, gin.H{"error": "User not found"})
+		return
+	}
+	if err := s.db.Where("user_id = ?", userID).Order("timestamp ASC, id ASC").Find(&export.Orders).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to fetch orders"})
+		return
+	}
+
+	var webhooks []Webhook
+	if err := s.db.Where("user_id = ?", userID).Limit(1).Find(&webhooks).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to fetch webhook"})
+		return
+	}
+	if len(webhooks) > 0 {
+		export.Webhook = &webhooks[0]
+	}
+
+	if err := s.db.Where("user_id = ? AND expires_at > ?", userID, now).Order("issued_at ASC").Find(&export.Sessions).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to fetch sessions"})
+		return
+	}
+	current := c.GetString("token_id")
+	for i := range export.Sessions {
+		export.Sessions[i].Current = current != "" && export.Sessions[i].TokenID == current
+	}
+
+	if err := s.db.Where("user_id = ?", userID).Order("id ASC").Find(&export.Notifications).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to fetch notifications"})
+		return
+	}
+
+	entitled, err := s.entitledSymbols(export.User.ID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to fetch entitlements"})
+		return
+	}
+	for symbol := range entitled {
+		export.Entitlements = append(export.Entitlements, symbol)
+	}
+	sort.Strings(export.Entitlements)
+
+	filename := fmt.Sprintf("account-%d-%s.json", export.User.ID, now.UTC().Format("20060102"))
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+	c.Header("Cache-Control", "no-store")
+	c.IndentedJSON(200, export)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// faultInjection adds artificial latency and failures to requests so
+// frontends can exercise their loading and retry states. It is for local
+// development and load testing only and is refused in production.
+type faultInjection struct {
+	Latency   time.Duration // Added to every matching request
+	Jitter    time.Duration // Up to this much extra random delay
+	ErrorRate float64       // Fraction of matching requests failed with 503
+
+	// Routes are the path prefixes affected; empty means every route
+	Routes []string
+}
+
+// enabled reports whether any fault is configured
+func (f faultInjection) enabled() bool {
+	return f.Latency > 0 || f.Jitter > 0 || f.ErrorRate > 0
+}
+
+// loadFaultInjection reads the FAULT_* settings from the environment
+func loadFaultInjection() (faultInjection, error) {
+	var f faultInjection
+	var err error
+
+	if f.Latency, err = envDuration("FAULT_LATENCY", 0); err != nil {
+		return f, err
+	}
+	if f.Jitter, err = envDuration("FAULT_JITTER", 0); err != nil {
+		return f, err
+	}
+	if f.Latency < 0 || f.Jitter < 0 {
+		return f, fmt.Errorf("FAULT_LATENCY and FAULT_JITTER must not be negative")
+	}
+	if f.ErrorRate, err = envFloat("FAULT_ERROR_RATE", 0); err != nil {
+		return f, err
+	}
+	if f.ErrorRate < 0 || f.ErrorRate > 1 {
+		return f, fmt.Errorf("FAULT_ERROR_RATE must be between 0 and 1")
+	}
+
+	for _, route := range strings.Split(os.Getenv("FAULT_ROUTES"), ",") {
+		if route = strings.TrimSpace(route); route != "" {
+			f.Routes = append(f.Routes, route)
+		}
+	}
+
+	return f, nil
+}
+
+// matches reports whether the request path falls under one of the routes
+func (f faultInjection) matches(path string) bool {
+	if len(f.Routes) == 0 {
+		return true
+	}
+	for _, route := range f.Routes {
+		if strings.HasPrefix(path, route) {
+			return true
+		}
+	}
+	return false
+}
+
+// faultInjector returns middleware that delays and occasionally fails the
+// requests matched by f
+func faultInjector(f faultInjection) gin.HandlerFunc {
+	log.Printf("Fault injection enabled: latency %s, jitter %s, error rate %g, routes %v", f.Latency, f.Jitter, f.ErrorRate, f.Routes)
+
+	return func(c *gin.Context) {
+		if !f.matches(c.Request.URL.Path) {
+			c.Next()
+			return
+		}
+
+		delay := f.Latency
+		if f.Jitter > 0 {
+			delay += time.Duration(rand.Int63n(int64(f.Jitter)))
+		}
+		if delay > 0 {
+			select {
+			case <-time.After(delay):
+			case <-c.Request.Context().Done():
+				c.Abort()
+				return
+			}
+		}
+
+		if f.ErrorRate > 0 && rand.Float64() < f.ErrorRate {
+			c.AbortWithStatusJSON(503, gin.H{"error": "Injected failure"})
+			return
+		}
+		c.Next()
+	}
+}
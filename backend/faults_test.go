@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFaultInjectionOffByDefault(t *testing.T) {
+	cfg := newTestConfig(t, nil)
+	if cfg.Faults.enabled() {
+		t.Fatalf("faults enabled without any FAULT_* settings: %+v", cfg.Faults)
+	}
+	s, r := newTestRouter(t, nil)
+	_, token := createTestSession(t, s, createTestUser(t, s, "trader", roleUser))
+
+	start := time.Now()
+	for i := 0; i < 50; i++ {
+		if w := doRequest(r, "GET", "/api/orders", token, ""); w.Code != 200 {
+			t.Fatalf("request %d: status %d: %s", i+1, w.Code, w.Body)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("50 requests took %s", elapsed)
+	}
+}
+
+func TestFaultInjectionScopedToRoutes(t *testing.T) {
+	quietLogs(t)
+	s, r := newTestRouter(t, map[string]string{
+		"FAULT_LATENCY":    "30ms",
+		"FAULT_ERROR_RATE": "1",
+		"FAULT_ROUTES":     "/api/orders",
+	})
+	_, token := createTestSession(t, s, createTestUser(t, s, "trader", roleUser))
+
+	start := time.Now()
+	if w := doRequest(r, "GET", "/api/orders", token, ""); w.Code != 503 {
+		t.Errorf("matching route: status %d, want 503", w.Code)
+	}
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Errorf("matching route answered after %s, want at least 30ms", elapsed)
+	}
+	if w := doRequest(r, "GET", "/api/prices", "", ""); w.Code != 200 {
+		t.Errorf("other route: status %d, want 200", w.Code)
+	}
+}
+
+func TestFaultInjectionRefusedInProduction(t *testing.T) {
+	t.Setenv("APP_ENV", "production")
+	t.Setenv("FAULT_LATENCY", "100ms")
+	if _, err := loadConfig(); err == nil {
+		t.Fatal("fault injection accepted in production")
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import "github.com/gin-gonic/gin"
+
+// fxBaseCurrency is the currency all mock exchange rates are quoted against
+const fxBaseCurrency = "USD"
+
+// fxRates holds mock exchange rates as units of each currency per 1 USD
+var fxRates = map[string]float64{
+	"USD": 1.0,
+	"INR": 83.20,
+	"EUR": 0.92,
+	"GBP": 0.79,
+	"JPY": 149.50,
+}
+
+// convertCurrency converts an amount between two supported currencies. It
+// assumes both currencies are present in fxRates.
+func convertCurrency(amount float64, from, to string) float64 {
+	if from == to {
+		return amount
+	}
+	return amount / fxRates[from] * fxRates[to]
+}
+
+// getFXRates returns the mock exchange rates used for price conversion
+func (s *Server) getFXRates(c *gin.Context) {
+	c.JSON(200, gin.H{
+		"base":  fxBaseCurrency,
+		"rates": fxRates,
+	})
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ImpersonationResponse is a short-lived token for acting as a user
+type ImpersonationResponse struct {
+	Token          string    `json:"token"`
+	User           User      `json:"user"`
+	ImpersonatedBy uint      `json:"impersonated_by"`
+	ExpiresAt      time.Time `json:"expires_at"`
+}
+
+// impersonateUser issues a support admin a token for seeing the app as a
+// user. The token carries an impersonated_by claim, lasts impersonationTTL,
+// and has its own session, so it shows in the user's sessions and can be
+// revoked. The token is read-only, since orders placed or cancelled with it
+// would look like the user's own, and admins can't be impersonated, so it
+// never shows more than a regular user sees. The admin's own auth cookie is
+// left alone.
+func (s *Server) impersonateUser(c *gin.Context) {
+	adminID, _ := c.Get("user_id")
+	userID, ok := s.entitlementsUser(c)
+	if !ok {
+		return
+	}
+
+	var user User
+	if err := s.db.First(&user, userID).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to fetch user"})
+		return
+	}
+	if user.Role == roleAdmin {
+		c.JSON(403, gin.H{"error": "Admins can't be impersonated"})
+		return
+	}
+
+	session, err := newSession(c, user.ID, s.impersonationTTL)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to generate token"})
+		return
+	}
+	session.ImpersonatedBy = adminID.(uint)
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&session).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, session.ImpersonatedBy, "user.impersonate", "user", user.ID)
+	})
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to start session"})
+		return
+	}
+
+	token, err := issueToken(user, session)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to generate token"})
+		return
+	}
+	log.Printf("Admin %d is impersonating user %d until %s", session.ImpersonatedBy, user.ID, session.ExpiresAt.Format(time.RFC3339))
+
+	c.JSON(200, ImpersonationResponse{
+		Token:          token,
+		User:           user,
+		ImpersonatedBy: session.ImpersonatedBy,
+		ExpiresAt:      session.ExpiresAt,
+	})
+}
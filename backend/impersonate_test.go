@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+// seededAdmin returns the admin user NewServer creates
+func seededAdmin(t *testing.T, s *Server) User {
+	t.Helper()
+	var admin User
+	if err := s.db.Where("username = ?", "admin").First(&admin).Error; err != nil {
+		t.Fatalf("finding admin: %v", err)
+	}
+	return admin
+}
+
+func TestImpersonateUser(t *testing.T) {
+	s, r := newTestRouter(t, nil)
+	admin := seededAdmin(t, s)
+	_, adminToken := createTestSession(t, s, admin)
+	user := createTestUser(t, s, "trader", roleUser)
+
+	w := doRequest(r, "POST", fmt.Sprintf("/api/admin/users/%d/impersonate", user.ID), adminToken, "{}")
+	if w.Code != 200 {
+		t.Fatalf("impersonate: status %d: %s", w.Code, w.Body)
+	}
+	var resp ImpersonationResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := parseToken(resp.Token)
+	if err != nil {
+		t.Fatalf("parseToken: %v", err)
+	}
+	if claims.UserID != user.ID || claims.ImpersonatedBy != admin.ID {
+		t.Fatalf("claims = user %d impersonated by %d, want user %d by %d", claims.UserID, claims.ImpersonatedBy, user.ID, admin.ID)
+	}
+
+	var entries []AuditLog
+	if err := s.db.Where("action = ?", "user.impersonate").Find(&entries).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].ActorID != admin.ID || entries[0].TargetType != "user" || entries[0].TargetID != user.ID {
+		t.Fatalf("audit entries = %+v, want one by admin %d for user %d", entries, admin.ID, user.ID)
+	}
+
+	// The user's profile shows who is impersonating them
+	w = doRequest(r, "GET", "/api/me", resp.Token, "")
+	var profile struct {
+		ID             uint `json:"id"`
+		ImpersonatedBy uint `json:"impersonated_by"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &profile); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != 200 || profile.ID != user.ID || profile.ImpersonatedBy != admin.ID {
+		t.Fatalf("profile: status %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestImpersonationIsReadOnly(t *testing.T) {
+	s, r := newTestRouter(t, nil)
+	admin := seededAdmin(t, s)
+	_, adminToken := createTestSession(t, s, admin)
+	user := createTestUser(t, s, "trader", roleUser)
+
+	w := doRequest(r, "POST", fmt.Sprintf("/api/admin/users/%d/impersonate", user.ID), adminToken, "{}")
+	var resp ImpersonationResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+
+	if w := doRequest(r, "GET", "/api/orders", resp.Token, ""); w.Code != 200 {
+		t.Fatalf("GET /api/orders: status %d", w.Code)
+	}
+	for _, path := range []string{"/api/orders", "/api/orders/cancel-all", "/api/notifications/read-all"} {
+		w := doRequest(r, "POST", path, resp.Token, `{"symbol":"AAPL","side":"buy","quantity":1}`)
+		if w.Code != 403 || !jsonHasCode(w.Body.Bytes(), authCodeReadOnly) {
+			t.Errorf("POST %s: status %d: %s", path, w.Code, w.Body)
+		}
+	}
+
+	var count int64
+	s.db.Model(&Order{}).Where("user_id = ?", user.ID).Count(&count)
+	if count != 0 {
+		t.Fatalf("%d orders placed while impersonating", count)
+	}
+}
+
+func TestAdminsCantBeImpersonated(t *testing.T) {
+	s, r := newTestRouter(t, nil)
+	admin := seededAdmin(t, s)
+	_, adminToken := createTestSession(t, s, admin)
+	other := createTestUser(t, s, "support", roleAdmin)
+
+	if w := doRequest(r, "POST", fmt.Sprintf("/api/admin/users/%d/impersonate", other.ID), adminToken, "{}"); w.Code != 403 {
+		t.Fatalf("status = %d, want 403", w.Code)
+	}
+}
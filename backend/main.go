@@ -1,56 +1,165 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
+	"math"
 	"math/rand"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/gorilla/websocket"
 	"golang.org/x/crypto/bcrypt"
-	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 )
 
+// shutdownTimeout bounds how long shutdown waits for in-flight requests
+const shutdownTimeout = 10 * time.Second
+
+// Build info, injected at build time via
+// -ldflags "-X main.version=... -X main.commit=... -X main.buildTime=..."
+var version, commit, buildTime string
+
 // JWT secret key (in production, use environment variable)
 var jwtSecret = []byte("your-secret-key-change-in-production")
 
 // Stock represents a stock with its current price
 type Stock struct {
-	Symbol string  `json:"symbol"`
-	Price  float64 `json:"price"`
+	Symbol   string  `json:"symbol"`
+	Price    float64 `json:"price"`
+	Currency string  `json:"currency"`  // ISO 4217 code the price is quoted in
+	TickSize float64 `json:"tick_size"` // Minimum price increment for orders
+	Beta     float64 `json:"beta"`      // Sensitivity to the market index
+
+	// PriceDecimals is how many decimal places simulated prices are kept to
+	PriceDecimals int `json:"price_decimals"`
+
+	// QuantityDecimals is how many decimal places order quantities may use
+	QuantityDecimals int `json:"quantity_decimals"`
+
+	// Bid and Ask are derived from Price and SpreadBps by updateQuote
+	Bid       float64 `json:"bid"`
+	Ask       float64 `json:"ask"`
+	SpreadBps float64 `json:"spread_bps"` // Bid/ask spread in basis points of the mid
+
+	// PriceBandPercent is how far, in percent of the current price, a limit
+	// order's price may be before it needs force; 0 allows any price
+	PriceBandPercent float64 `json:"price_band_percent"`
+
+	// MinNotional is the smallest quantity * price an order may have, in
+	// the symbol's currency; 0 allows any. Published in the symbol catalog.
+	MinNotional float64 `json:"-"`
+
+	// Name and Sector describe the symbol for display and grouping, e.g.
+	// "Apple Inc." in "Technology". Published in the symbol catalog; Name
+	// defaults to the symbol and Sector may be empty.
+	Name   string `json:"-"`
+	Sector string `json:"-"`
+
+	// LastUpdate is when Price last changed, so clients can tell how stale
+	// each symbol is
+	LastUpdate time.Time `json:"last_update"`
+
+	// Tradable symbols accept orders; prices are published either way
+	Tradable bool `json:"tradable"`
+
+	// Basket is set for composite symbols priced from other stocks
+	Basket bool `json:"basket,omitempty"`
 }
 
 // User represents a user in the system
 type User struct {
 	ID       uint   `gorm:"primaryKey" json:"id"`
 	Username string `gorm:"unique;not null" json:"username"`
-	Password string `gorm:"not null" json:"-"` // Don't return password in JSON
+	Password string `gorm:"not null" json:"-"`                 // Don't return password in JSON
+	Role     string `gorm:"not null;default:user" json:"role"` // "user" or "admin"
+
+	// AccountMode is "paper" for simulated trading or "live" for a real
+	// broker account
+	AccountMode string `gorm:"not null;default:paper" json:"account_mode"`
+
+	// CreatedAt is when the user signed up; zero for users created before
+	// it was recorded
+	CreatedAt time.Time `json:"created_at"`
+
+	// OrderSeq is the number given to the user's latest order
+	OrderSeq uint `gorm:"not null;default:0" json:"-"`
 }
 
-// Order represents a trading order (database model)
+// User roles
+const (
+	roleUser  = "user"
+	roleAdmin = "admin"
+)
+
+// Order represents a trading order (database model). The database ID is
+// kept internal; clients see the per-user Number instead, so order counts
+// across users don't leak and other users' orders can't be guessed.
 type Order struct {
-	ID        uint      `gorm:"primaryKey" json:"id"`
-	UserID    uint      `gorm:"not null" json:"user_id"`
-	Symbol    string    `gorm:"not null" json:"symbol"`
-	Side      string    `gorm:"not null" json:"side"` // "buy" or "sell"
-	Quantity  int       `gorm:"not null" json:"quantity"`
+	ID     uint `gorm:"primaryKey" json:"-"`
+	UserID uint `gorm:"not null;uniqueIndex:idx_orders_user_client_order_id;uniqueIndex:idx_orders_user_number" json:"user_id"`
+
+	// Number counts the user's orders from 1
+	Number uint `gorm:"not null;default:0;uniqueIndex:idx_orders_user_number" json:"number"`
+
+	Symbol    string    `gorm:"not null;index" json:"symbol"`
+	Side      string    `gorm:"not null" json:"side"` // "buy", "sell", "short" or "cover"
+	Quantity  float64   `gorm:"not null" json:"quantity"`
 	Price     float64   `gorm:"not null" json:"price"`
-	Timestamp time.Time `gorm:"not null" json:"timestamp"`
+	Timestamp time.Time `gorm:"not null;index" json:"timestamp"`
+
+	// ClientOrderID is the client's own reference, unique per user when set
+	ClientOrderID *string `gorm:"uniqueIndex:idx_orders_user_client_order_id" json:"client_order_id,omitempty"`
+
+	// Tag is a short label for grouping orders, e.g. by strategy, and Note
+	// the user's own reason for placing it
+	Tag  string `gorm:"not null;default:'';index" json:"tag,omitempty"`
+	Note string `gorm:"not null;default:''" json:"note,omitempty"`
+
+	// Archived orders are hidden from the default order history
+	Archived bool `gorm:"not null;default:false;index" json:"archived"`
+
+	// Status is where the order is in its lifecycle; see orderStatusFilled
+	Status string `gorm:"not null;default:filled;index" json:"status"`
+
+	// Version increases on every change, so clients can detect that the
+	// order moved on since they last saw it
+	Version uint `gorm:"not null;default:1" json:"version"`
 }
 
 // OrderRequest represents an incoming order request
 type OrderRequest struct {
 	Symbol   string  `json:"symbol"`
 	Side     string  `json:"side"`
-	Quantity int     `json:"quantity"`
+	Quantity float64 `json:"quantity"`
 	Price    float64 `json:"price"`
+
+	// Type is "market" or "limit". Left out, an order without a price is a
+	// market order and one with a price a limit order.
+	Type string `json:"type,omitempty"`
+
+	// ClientOrderID is an optional client reference echoed back on the order
+	ClientOrderID *string `json:"client_order_id,omitempty"`
+
+	// Tag and Note optionally annotate the order
+	Tag  string `json:"tag,omitempty"`
+	Note string `json:"note,omitempty"`
+
+	// Force places a limit order even if its price is outside the band
+	Force bool `json:"force,omitempty"`
 }
 
 // LoginRequest represents a login request
@@ -73,63 +182,236 @@ type LoginResponse struct {
 
 // Server holds the application state
 type Server struct {
-	db          *gorm.DB
-	stocks      map[string]*Stock
-	clients     map[*websocket.Conn]bool
+	db             *gorm.DB
+	passwordPolicy PasswordPolicy
+	usernamePolicy UsernamePolicy
+	hasher         PasswordHasher
+	stocks         map[string]*Stock
+	symbolAliases  map[string]string // Upper-cased alias to tracked symbol; read-only
+	seedStocks     []Stock           // Starting prices, restored by resetSimulation
+	baskets        []Basket
+	stocksLock     sync.RWMutex
+	market         marketIndex    // guarded by stocksLock
+	snapshot       *priceSnapshot // guarded by stocksLock; nil once prices change
+	starterOrders  []symbolValue
+	roundToTick    bool
+	priceDecimals  int // Most decimal places a limit price may have
+	roundQuantity  bool
+	shortSelling   bool
+	qtyIncrement   float64
+	orderLimiter   *dailyOrderLimiter
+	maxOpenOrders  int            // 0 for no cap on working orders per user
+	minAccountAge  time.Duration  // 0 lets new accounts trade straight away
+	symbolLimiter  *windowLimiter // nil unless SYMBOL_ORDER_LIMIT is set
+	exportLimiter  *cooldownLimiter
+	positionsCache *positionsCache
+	retention      retentionPolicy
+	pagination     paginationPolicy
+
+	// settlementDelay is how long new orders stay pending before filling
+	settlementDelay time.Duration
+
+	maintenance atomic.Bool
+
+	// simulatorPaused freezes simulated prices while set
+	simulatorPaused atomic.Bool
+
+	// dbHealthy is cleared while health checks can't reach the database
+	dbHealthy atomic.Bool
+
+	// priceInterval is how often the simulation moves prices, and
+	// broadcastInterval the minimum time between client broadcasts
+	priceInterval     time.Duration
+	broadcastInterval time.Duration
+	pricesDirty       atomic.Bool // Prices changed since the last broadcast
+
+	// candles aggregates broadcast prices for candle subscribers
+	candles *candleBook
+
+	// webhookClient delivers order webhooks; it refuses internal addresses
+	// unless webhookAllowPrivate is set
+	webhookClient       *http.Client
+	webhookAllowPrivate bool
+	webhookBackoff      time.Duration // Wait before the first retry
+
+	// authCookie hands out and accepts tokens in an HttpOnly cookie
+	authCookie         bool
+	authCookieSameSite http.SameSite
+
+	// allowedOrigins may open cookie-authenticated WebSockets, besides the
+	// API's own origin
+	allowedOrigins []string
+
+	// pricesRequireAuth refuses WebSocket connections without a token, as
+	// the price routes do when gated
+	pricesRequireAuth bool
+
+	// impersonationTTL is the lifetime of tokens admins issue to act as a
+	// user
+	impersonationTTL time.Duration
+
+	// welcomeMessage is left in every new user's inbox; empty for none
+	welcomeMessage string
+
+	clients     map[*Client]struct{}
 	clientsLock sync.RWMutex
-	upgrader    websocket.Upgrader
+
+	// subscribers receive every broadcast price snapshot (used by SSE)
+	subscribers     map[chan []Stock]struct{}
+	subscribersLock sync.Mutex
+
+	upgrader websocket.Upgrader
+
+	// wsCompressionLevel is the deflate level for connections that
+	// negotiated compression
+	wsCompressionLevel int
+}
+
+// defaultStocks returns the built-in mock stocks with their starting prices
+func defaultStocks() []Stock {
+	return []Stock{
+		{Symbol: "AAPL", Name: "Apple Inc.", Sector: "Technology", Price: 175.50, Currency: "USD", TickSize: 0.01, Beta: 1.2, PriceDecimals: 2, Tradable: true},
+		{Symbol: "TSLA", Name: "Tesla Inc.", Sector: "Automotive", Price: 245.30, Currency: "USD", TickSize: 0.01, Beta: 2.0, PriceDecimals: 2, Tradable: true},
+		{Symbol: "AMZN", Name: "Amazon.com Inc.", Sector: "Consumer Discretionary", Price: 138.20, Currency: "USD", TickSize: 0.01, Beta: 1.1, PriceDecimals: 2, Tradable: true},
+		{Symbol: "INFY", Name: "Infosys Limited", Sector: "IT Services", Price: 18.75, Currency: "USD", TickSize: 0.01, Beta: 0.8, PriceDecimals: 2, Tradable: true},            // NYSE-listed ADR
+		{Symbol: "TCS", Name: "Tata Consultancy Services", Sector: "IT Services", Price: 3450.00, Currency: "INR", TickSize: 0.05, Beta: 0.6, PriceDecimals: 2, Tradable: true}, // NSE listing
+	}
 }
 
 // NewServer creates a new server instance
-func NewServer(dbPath string) *Server {
+func NewServer(cfg Config) *Server {
+	dbPath := cfg.DBPath
 	if dbPath == "" {
 		dbPath = "trading.db"
 	}
+	if cfg.PriceUpdateInterval == 0 {
+		cfg.PriceUpdateInterval = defaultPriceUpdateInterval
+	}
+	if cfg.WSProtocols == nil {
+		cfg.WSProtocols = knownWSProtocols
+	}
+	if cfg.UsernamePolicy.Pattern == nil {
+		cfg.UsernamePolicy = defaultUsernamePolicy()
+	}
+	if cfg.PasswordHasher == nil {
+		cfg.PasswordHasher = bcryptHasher{cost: bcrypt.DefaultCost}
+	}
 
 	// Initialize database
-	db, err := gorm.Open(sqlite.Open(dbPath), &gorm.Config{})
+	db, err := openDatabase(dbPath, cfg.DBRetry)
 	if err != nil {
 		log.Fatalf("Failed to connect to database (%s): %v", dbPath, err)
 	}
 
-	// Auto-migrate the schema
-	err = db.AutoMigrate(&User{}, &Order{})
+	// Auto-migrate the schema. SQLite rebuilds tables whose column types
+	// change (e.g. orders.quantity from integer to real), copying rows over.
+	// Existing orders are numbered first so the unique index can be built.
+	numbered, err := migrateOrderNumbers(db)
+	if err != nil {
+		log.Fatal("Failed to number existing orders:", err)
+	}
+	err = db.AutoMigrate(&User{}, &Order{}, &Webhook{}, &AuditLog{}, &Entitlement{}, &Session{}, &Notification{})
 	if err != nil {
 		log.Fatal("Failed to migrate database:", err)
 	}
+	if numbered {
+		if err := backfillOrderSeqs(db); err != nil {
+			log.Fatal("Failed to migrate order numbers:", err)
+		}
+	}
 
 	// Create default user if it doesn't exist
 	var userCount int64
 	db.Model(&User{}).Count(&userCount)
 	if userCount == 0 {
-		hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.DefaultCost)
+		hashedPassword, _ := cfg.PasswordHasher.Hash("password123")
 		defaultUser := User{
-			Username: "admin",
-			Password: string(hashedPassword),
+			Username:    "admin",
+			Password:    hashedPassword,
+			Role:        roleAdmin,
+			AccountMode: accountModePaper,
 		}
 		db.Create(&defaultUser)
 		log.Println("Created default user: admin / password123")
+	} else {
+		// Databases created before roles existed seeded "admin" as a plain user
+		db.Model(&User{}).Where("id = ? AND username = ?", 1, "admin").Update("role", roleAdmin)
 	}
 
 	// Initialize mock stocks with starting prices
-	stocks := map[string]*Stock{
-		"AAPL": {Symbol: "AAPL", Price: 175.50},
-		"TSLA": {Symbol: "TSLA", Price: 245.30},
-		"AMZN": {Symbol: "AMZN", Price: 138.20},
-		"INFY": {Symbol: "INFY", Price: 18.75},
-		"TCS":  {Symbol: "TCS", Price: 3450.00},
-	}
-
-	return &Server{
-		db:      db,
-		stocks:  stocks,
-		clients: make(map[*websocket.Conn]bool),
+	seed := cfg.Stocks
+	if len(seed) == 0 {
+		seed = defaultStocks()
+	}
+	stocks := make(map[string]*Stock, len(seed))
+	now := time.Now()
+	for i := range seed {
+		stock := seed[i]
+		stock.LastUpdate = now
+		stock.updateQuote()
+		stocks[stock.Symbol] = &stock
+		symbolDecimals[stock.Symbol] = stock.PriceDecimals
+	}
+
+	server := &Server{
+		db:             db,
+		passwordPolicy: cfg.PasswordPolicy,
+		usernamePolicy: cfg.UsernamePolicy,
+		hasher:         cfg.PasswordHasher,
+		stocks:         stocks,
+		symbolAliases:  cfg.SymbolAliases,
+		seedStocks:     seed,
+		baskets:        cfg.Baskets,
+		market:         newMarketIndex(),
+		starterOrders:  cfg.StarterHoldings,
+		roundToTick:    cfg.TickSizeMode == tickSizeRound,
+		priceDecimals:  cfg.LimitPriceDecimals,
+		roundQuantity:  cfg.QuantityPrecisionMode == tickSizeRound,
+		shortSelling:   cfg.ShortSelling,
+		qtyIncrement:   cfg.QuantityIncrement,
+		orderLimiter:   newDailyOrderLimiter(cfg.MaxOrdersPerDay),
+		maxOpenOrders:  cfg.MaxOpenOrders,
+		minAccountAge:  cfg.MinAccountAge,
+		exportLimiter:  newCooldownLimiter(exportCooldown),
+		positionsCache: newPositionsCache(positionsCacheTTL, positionsCacheSize),
+		retention:      cfg.Retention,
+		pagination:     cfg.Pagination,
+
+		settlementDelay: cfg.SettlementDelay,
+
+		priceInterval:     cfg.PriceUpdateInterval,
+		broadcastInterval: cfg.BroadcastInterval,
+
+		webhookClient:       newWebhookClient(cfg.WebhookAllowPrivate),
+		webhookAllowPrivate: cfg.WebhookAllowPrivate,
+		webhookBackoff:      webhookRetryBackoff,
+		authCookie:          cfg.AuthCookie,
+		authCookieSameSite:  cfg.AuthCookieSameSite,
+		allowedOrigins:      cfg.AllowedOrigins,
+		pricesRequireAuth:   cfg.PricesRequireAuth,
+		impersonationTTL:    cfg.ImpersonationTTL,
+		welcomeMessage:      cfg.WelcomeMessage,
+		candles:             newCandleBook(),
+		clients:             make(map[*Client]struct{}),
+		subscribers:         make(map[chan []Stock]struct{}),
 		upgrader: websocket.Upgrader{
+			// Token-authenticated clients may connect from anywhere;
+			// handleWebSocket checks the origin of cookie-authenticated ones
 			CheckOrigin: func(r *http.Request) bool {
-				return true // Allow all origins for development
+				return true
 			},
+			EnableCompression: cfg.WSCompression,
+			Subprotocols:      cfg.WSProtocols,
 		},
+		wsCompressionLevel: cfg.WSCompressionLevel,
+	}
+	server.maintenance.Store(cfg.MaintenanceMode)
+	server.dbHealthy.Store(true)
+	if cfg.SymbolOrderLimit > 0 {
+		server.symbolLimiter = newWindowLimiter(cfg.SymbolOrderLimit, cfg.SymbolOrderWindow)
 	}
+
+	return server
 }
 
 func main() {
@@ -140,7 +422,14 @@ func main() {
 		jwtSecret = []byte("your-secret-key-change-in-production")
 	}
 
-	dbPath := os.Getenv("DB_PATH")
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Fatal("Invalid configuration: ", err)
+	}
+	jwtLeeway = cfg.TokenLeeway
+	jwtKeyID = cfg.TokenKeyID
+	jwtPreviousKeys = cfg.PreviousTokenKeys
+	moneyRounding, feeRounding = cfg.MoneyRounding, cfg.FeeRounding
 
 	// Get port from environment
 	port := os.Getenv("PORT")
@@ -148,97 +437,245 @@ func main() {
 		port = "8080"
 	}
 
-	server := NewServer(dbPath)
+	server := NewServer(cfg)
 
-	// Start the price update goroutine
-	go server.updatePrices()
+	// Background work runs on the scheduler, which is stopped on shutdown
+	jobs := newScheduler()
+	jobs.register(server.updatePricesJob())
+	if server.broadcastInterval > 0 {
+		jobs.register(server.broadcastJob())
+	}
 
-	// Setup Gin router
-	r := gin.Default()
+	jobs.register(server.sessionCleanupJob())
+	jobs.register(server.settlementJob())
+	if cfg.DBHealthCheck > 0 {
+		jobs.register(server.dbHealthJob(cfg.DBHealthCheck, cfg.DBRetry.Backoff))
+	}
 
-	// CORS middleware
-	config := cors.Config{
-		AllowMethods:     []string{"GET", "POST", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization"},
-		AllowCredentials: true,
+	// Archive orders if a retention limit is configured
+	if cfg.Retention.enabled() {
+		jobs.register(server.archiveJob())
 	}
+	jobs.start()
 
-	if origins := strings.TrimSpace(os.Getenv("ALLOWED_ORIGINS")); origins != "" {
-		originList := strings.Split(origins, ",")
-		for i := range originList {
-			originList[i] = strings.TrimSpace(originList[i])
+	r, err := server.router(cfg)
+	if err != nil {
+		log.Fatal("Invalid configuration: ", err)
+	}
+
+	// Long-lived requests such as event streams watch this context, which
+	// is cancelled when shutdown begins so they don't hold it up
+	streams, closeStreams := context.WithCancel(context.Background())
+	srv := &http.Server{
+		Addr:        ":" + port,
+		Handler:     r,
+		BaseContext: func(net.Listener) context.Context { return streams },
+	}
+	srv.RegisterOnShutdown(closeStreams)
+
+	go func() {
+		log.Printf("Server starting on :%s (DB: %s)", port, cfg.DBPath)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal("Failed to start server:", err)
 		}
-		config.AllowOrigins = originList
+	}()
+
+	// Shut down gracefully on Ctrl-C or SIGTERM: stop accepting requests,
+	// let in-flight ones finish, then stop the background jobs
+	stop, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	<-stop.Done()
+
+	log.Printf("Shutting down")
+	ctx, cancelShutdown := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancelShutdown()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("Error shutting down server: %v", err)
+	}
+	jobs.stop()
+}
+
+// router sets up the middleware and routes of the HTTP API
+func (s *Server) router(cfg Config) (*gin.Engine, error) {
+	// Recovery sits after the request id so panics can be traced in the
+	// logs, and inside the envelope so its errors are enveloped too
+	r := gin.New()
+	if err := r.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		return nil, fmt.Errorf("TRUSTED_PROXIES: %w", err)
+	}
+	r.Use(gin.Logger(), requestID(), responseEnvelope(cfg.ResponseEnvelope), recovery(), s.requireDatabase())
+
+	// CORS middleware. Credentials are only allowed for listed origins;
+	// with none listed any origin may call the API, but without cookies.
+	config := cors.Config{
+		AllowMethods:     []string{"GET", "POST", "DELETE", "OPTIONS"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization", requestIDHeader},
+		ExposeHeaders:    []string{requestIDHeader, "Retry-After", "X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset"},
+		AllowCredentials: len(cfg.AllowedOrigins) > 0,
+	}
+	if len(cfg.AllowedOrigins) > 0 {
+		config.AllowOrigins = cfg.AllowedOrigins
 	} else {
 		config.AllowAllOrigins = true
 	}
 
 	r.Use(cors.New(config))
 
+	// Per-IP request limits, after CORS so rejections are readable cross-origin
+	if cfg.RateLimit.enabled() {
+		r.Use(apiRateLimit(cfg.RateLimit))
+	}
+
+	// Artificial latency and errors for testing clients; never in production
+	if cfg.Faults.enabled() {
+		r.Use(faultInjector(cfg.Faults))
+	}
+
+	// Probes for orchestrators
+	r.GET("/healthz", healthz)
+	r.GET("/readyz", s.readyz)
+
 	// Public routes
-	r.POST("/api/login", server.login)
-	r.POST("/api/signup", server.signup)
-	r.GET("/api/prices", server.getPrices)
-	r.GET("/ws", server.handleWebSocket)
+	r.POST("/api/login", s.login)
+	r.POST("/api/logout", s.logout)
+	r.POST("/api/signup", s.blockDuringMaintenance(), s.signup)
+	r.GET("/api/symbols", s.getSymbols)
+	r.GET("/api/fx", s.getFXRates)
+	r.GET("/api/version", getVersion)
+
+	// Live prices are public unless PRICES_REQUIRE_AUTH is set, in which
+	// case the WebSocket checks for a token itself
+	prices := r.Group("")
+	if cfg.PricesRequireAuth {
+		prices.Use(s.authMiddleware())
+	}
+	{
+		prices.GET("/api/prices", s.getPrices)
+		prices.GET("/api/baskets", s.getBaskets)
+		prices.GET("/api/index", s.getMarketIndex)
+		prices.GET("/api/stream", s.streamPrices)
+		prices.GET("/api/trades/recent", s.getRecentTrades)
+	}
+	r.GET("/ws", s.handleWebSocket)
 
 	// Protected routes (require JWT)
 	api := r.Group("/api")
-	api.Use(server.authMiddleware())
+	api.Use(s.authMiddleware())
 	{
-		api.POST("/orders", server.createOrder)
-		api.GET("/orders", server.getOrders)
+		// A preview changes nothing, and reports maintenance in its body
+		api.POST("/orders/preview", s.previewOrder)
+		api.GET("/orders", s.getOrders)
+		api.GET("/orders/open", s.getOpenOrders)
+		api.GET("/orders/count", s.getOrderCounts)
+		api.GET("/orders/by-symbol", s.getOrdersBySymbol)
+		api.GET("/orders/:number", s.getOrder)
+		api.GET("/me", s.getProfile)
+		api.GET("/me/summary", s.getTradeSummary)
+		api.GET("/me/sessions", s.getSessions)
+		api.GET("/me/export", s.exportAccount)
+		api.GET("/webhooks", s.getWebhook)
+		api.GET("/notifications", s.getNotifications)
 	}
 
-	// Start server
-	log.Printf("Server starting on :%s (DB: %s)", port, dbPath)
-	if err := r.Run(":" + port); err != nil {
-		log.Fatal("Failed to start server:", err)
+	// Routes that change anything are refused during maintenance
+	writes := api.Group("")
+	writes.Use(s.blockDuringMaintenance())
+	{
+		writes.POST("/orders", s.createOrder)
+		writes.POST("/orders/cancel-all", s.cancelAllOrders)
+		writes.POST("/orders/:number/cancel", s.cancelOwnOrder)
+		writes.POST("/portfolio/recalculate", s.recalculatePortfolio)
+		writes.DELETE("/me/sessions/:id", s.revokeSession)
+		writes.POST("/webhooks", s.setWebhook)
+		writes.DELETE("/webhooks", s.deleteWebhook)
+		writes.POST("/notifications/read-all", s.markAllNotificationsRead)
+		writes.POST("/notifications/:id/read", s.markNotificationRead)
+	}
+
+	// Admin routes (require JWT with the admin role)
+	admin := api.Group("/admin")
+	admin.Use(s.requireAdmin())
+	{
+		admin.GET("/maintenance", s.getMaintenance)
+		admin.POST("/maintenance", s.setMaintenance)
+		admin.GET("/simulator", s.getSimulator)
+		admin.POST("/simulator", s.setSimulator)
+		admin.POST("/symbols/:symbol/trading", s.setTrading)
+		admin.GET("/orders", s.listAllOrders)
+		admin.POST("/orders/:id/cancel", s.forceCancelOrder)
+		admin.GET("/subscriptions", s.getSubscriptions)
+		admin.GET("/users", s.listUsers)
+		admin.GET("/users/:id", s.getUser)
+		admin.GET("/users/:id/entitlements", s.getEntitlements)
+		admin.POST("/users/:id/entitlements", s.setEntitlements)
+		admin.POST("/users/:id/account-mode", s.setAccountMode)
+		admin.POST("/users/:id/impersonate", s.impersonateUser)
+
+		// Resetting the simulation is for demos and QA only
+		if cfg.Environment != envProduction {
+			admin.POST("/reset", s.resetDemo)
+		}
 	}
+
+	return r, nil
 }
 
 // authMiddleware validates JWT tokens
 func (s *Server) authMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
+		// The header wins; in cookie auth mode the cookie is the fallback
 		authHeader := c.GetHeader("Authorization")
+		tokenString := ""
 		if authHeader == "" {
-			c.JSON(401, gin.H{"error": "Authorization header required"})
-			c.Abort()
-			return
-		}
+			tokenString = s.cookieToken(c)
+			if tokenString == "" {
+				c.JSON(401, gin.H{"error": "Authorization header required", "code": authCodeMissing})
+				c.Abort()
+				return
+			}
 
-		// Extract token from "Bearer <token>"
-		tokenString := ""
-		if len(authHeader) > 7 && authHeader[:7] == "Bearer " {
+			// Requiring JSON forces a CORS preflight, so another site can't
+			// submit a plain form that rides along on the cookie
+			if c.Request.Method == http.MethodPost && c.ContentType() != "application/json" {
+				c.JSON(415, gin.H{"error": "Content-Type must be application/json"})
+				c.Abort()
+				return
+			}
+		} else if len(authHeader) > 7 && authHeader[:7] == "Bearer " {
+			// Extract token from "Bearer <token>"
 			tokenString = authHeader[7:]
 		} else {
-			c.JSON(401, gin.H{"error": "Invalid authorization header format"})
+			c.JSON(401, gin.H{"error": "Invalid authorization header format", "code": authCodeMalformed})
 			c.Abort()
 			return
 		}
 
-		// Parse and validate token
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, jwt.ErrSignatureInvalid
-			}
-			return jwtSecret, nil
-		})
+		// Parse and validate token, and check its session is still active
+		claims, err := s.authenticate(tokenString)
+		if errors.Is(err, errSessionLookup) {
+			writeSessionLookupError(c)
+			c.Abort()
+			return
+		}
+		if err != nil {
+			code, message := tokenErrorCode(err)
+			c.JSON(401, gin.H{"error": message, "code": code})
+			c.Abort()
+			return
+		}
 
-		if err != nil || !token.Valid {
-			c.JSON(401, gin.H{"error": "Invalid or expired token"})
+		// Support staff acting as a user may look but not touch
+		if claims.ImpersonatedBy != 0 && c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead {
+			c.JSON(403, gin.H{"error": impersonationReadOnlyMessage, "code": authCodeReadOnly})
 			c.Abort()
 			return
 		}
 
-		// Extract user ID from claims
-		if claims, ok := token.Claims.(jwt.MapClaims); ok {
-			if userID, ok := claims["user_id"].(float64); ok {
-				c.Set("user_id", uint(userID))
-			} else {
-				c.JSON(401, gin.H{"error": "Invalid token claims"})
-				c.Abort()
-				return
-			}
+		c.Set("user_id", claims.UserID)
+		c.Set("role", claims.Role)
+		c.Set("token_id", claims.TokenID)
+		if claims.ImpersonatedBy != 0 {
+			c.Set("impersonated_by", claims.ImpersonatedBy)
 		}
 
 		c.Next()
@@ -248,37 +685,45 @@ func (s *Server) authMiddleware() gin.HandlerFunc {
 // login handles user authentication
 func (s *Server) login(c *gin.Context) {
 	var req LoginRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(400, gin.H{"error": "Invalid request"})
+	if !bindStrictJSON(c, &req) {
 		return
 	}
 
-	// Find user
+	// Find user, preferring an exact match over one that differs in case
+	// (accounts created before signup ignored case may differ only in case)
 	var user User
-	if err := s.db.Where("username = ?", req.Username).First(&user).Error; err != nil {
-		c.JSON(401, gin.H{"error": "Invalid credentials"})
-		return
+	username := strings.TrimSpace(req.Username)
+	if err := s.db.Where("username = ?", username).First(&user).Error; err != nil {
+		if err := s.db.Where("LOWER(username) = ?", usernameKey(username)).First(&user).Error; err != nil {
+			c.JSON(401, gin.H{"error": "Invalid credentials"})
+			return
+		}
 	}
 
 	// Check password
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
+	rehash, err := comparePassword(s.hasher, user.Password, req.Password)
+	if err != nil {
 		c.JSON(401, gin.H{"error": "Invalid credentials"})
 		return
 	}
 
-	// Generate JWT token
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"user_id":  user.ID,
-		"username": user.Username,
-		"exp":      time.Now().Add(time.Hour * 24).Unix(), // 24 hour expiration
-	})
+	// Upgrade hashes from an older scheme now that we have the plaintext
+	if rehash {
+		if hashed, err := s.hasher.Hash(req.Password); err != nil {
+			log.Printf("Failed to rehash password for user %d: %v", user.ID, err)
+		} else if err := s.db.Model(&user).Update("password", hashed).Error; err != nil {
+			log.Printf("Failed to store rehashed password for user %d: %v", user.ID, err)
+		}
+	}
 
-	tokenString, err := token.SignedString(jwtSecret)
+	// Start a session and generate its JWT token
+	tokenString, err := s.startSession(c, user)
 	if err != nil {
 		c.JSON(500, gin.H{"error": "Failed to generate token"})
 		return
 	}
 
+	s.setAuthCookie(c, tokenString)
 	c.JSON(200, LoginResponse{
 		Token: tokenString,
 		User:  user,
@@ -288,75 +733,173 @@ func (s *Server) login(c *gin.Context) {
 // signup handles user registration
 func (s *Server) signup(c *gin.Context) {
 	var req SignupRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(400, gin.H{"error": "Invalid request"})
+	if !bindStrictJSON(c, &req) {
 		return
 	}
 
-	// Validate input
-	if req.Username == "" {
-		c.JSON(400, gin.H{"error": "Username is required"})
+	// Validate input, reporting every invalid field at once
+	req.Username = strings.TrimSpace(req.Username)
+	if errs := s.validateSignup(req); len(errs) > 0 {
+		c.JSON(422, gin.H{"errors": errs})
 		return
 	}
 
-	if len(req.Password) < 6 {
-		c.JSON(400, gin.H{"error": "Password must be at least 6 characters"})
-		return
-	}
-
-	// Check if username already exists
+	// Check if username already exists, ignoring case
 	var existingUser User
-	if err := s.db.Where("username = ?", req.Username).First(&existingUser).Error; err == nil {
+	if err := s.db.Where("LOWER(username) = ?", usernameKey(req.Username)).First(&existingUser).Error; err == nil {
 		c.JSON(400, gin.H{"error": "Username already exists"})
 		return
 	}
 
 	// Hash password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	hashedPassword, err := s.hasher.Hash(req.Password)
 	if err != nil {
 		c.JSON(500, gin.H{"error": "Failed to hash password"})
 		return
 	}
 
-	// Create user
+	// Create user along with any configured starter positions
 	user := User{
-		Username: req.Username,
-		Password: string(hashedPassword),
+		Username:    req.Username,
+		Password:    hashedPassword,
+		Role:        roleUser,
+		AccountMode: accountModePaper,
 	}
 
-	if err := s.db.Create(&user).Error; err != nil {
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+		if err := s.createStarterOrders(tx, user.ID); err != nil {
+			return err
+		}
+		return s.createWelcomeNotification(tx, user.ID)
+	})
+	if err != nil {
 		c.JSON(500, gin.H{"error": "Failed to create user"})
 		return
 	}
 
-	// Generate JWT token
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"user_id":  user.ID,
-		"username": user.Username,
-		"exp":      time.Now().Add(time.Hour * 24).Unix(), // 24 hour expiration
-	})
-
-	tokenString, err := token.SignedString(jwtSecret)
+	// Start a session and generate its JWT token
+	tokenString, err := s.startSession(c, user)
 	if err != nil {
 		c.JSON(500, gin.H{"error": "Failed to generate token"})
 		return
 	}
 
+	s.setAuthCookie(c, tokenString)
 	c.JSON(201, LoginResponse{
 		Token: tokenString,
 		User:  user,
 	})
 }
 
-// getPrices returns current prices for all stocks
+// createStarterOrders records a "buy" order at the current market price for
+// each configured starter holding, skipping symbols that aren't tracked
+func (s *Server) createStarterOrders(tx *gorm.DB, userID uint) error {
+	for _, holding := range s.starterOrders {
+		price, ok := s.currentPrice(holding.Symbol)
+		if !ok {
+			log.Printf("Skipping starter holding for unknown symbol %s", holding.Symbol)
+			continue
+		}
+		number, err := nextOrderNumber(tx, userID)
+		if err != nil {
+			return err
+		}
+
+		order := Order{
+			UserID:    userID,
+			Number:    number,
+			Symbol:    holding.Symbol,
+			Side:      "buy",
+			Quantity:  holding.Value,
+			Price:     price,
+			Timestamp: time.Now(),
+			Status:    orderStatusFilled,
+		}
+		if err := tx.Create(&order).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getPrices returns current prices for all stocks, optionally converted
+// into a single target currency via ?currency=XXX
 func (s *Server) getPrices(c *gin.Context) {
-	prices := make([]Stock, 0, len(s.stocks))
-	for _, stock := range s.stocks {
-		prices = append(prices, *stock)
+	snapshot := s.currentPrices()
+	prices := snapshot.prices
+
+	// Signed-in users restricted to some symbols only see those
+	var entitled map[string]bool
+	if userID, ok := s.optionalUserID(c); ok {
+		var err error
+		if entitled, err = s.entitledSymbols(userID); err != nil {
+			c.JSON(500, gin.H{"error": "Failed to fetch entitlements"})
+			return
+		}
+		prices = filterPrices(prices, entitled)
+	}
+
+	// ?sector=Technology narrows the prices to one sector
+	sector := strings.TrimSpace(c.Query("sector"))
+	if sector != "" {
+		prices = filterSector(prices, sector)
+	}
+
+	target := strings.ToUpper(c.Query("currency"))
+
+	// Everyone asking for all prices as quoted gets the same bytes
+	if msg, ok := snapshot.encoded[wsProtocolV1]; ok && entitled == nil && sector == "" && target == "" {
+		c.Data(200, "application/json; charset=utf-8", msg)
+		return
+	}
+
+	if target != "" {
+		if _, ok := fxRates[target]; !ok {
+			c.JSON(400, gin.H{"error": "Unsupported currency"})
+			return
+		}
+		prices = slices.Clone(prices)
+		for i := range prices {
+			convert := func(price float64) float64 {
+				return roundDecimals(convertCurrency(price, prices[i].Currency, target), prices[i].PriceDecimals)
+			}
+			prices[i].Price = convert(prices[i].Price)
+			prices[i].Bid = convert(prices[i].Bid)
+			prices[i].Ask = convert(prices[i].Ask)
+			prices[i].Currency = target
+		}
 	}
+
 	c.JSON(200, prices)
 }
 
+// snapshotPrices returns the current prices for all stocks. The slice is
+// shared with other readers and must not be modified.
+func (s *Server) snapshotPrices() []Stock {
+	return s.currentPrices().prices
+}
+
+// lookupStock returns a copy of the stock for a symbol
+func (s *Server) lookupStock(symbol string) (Stock, bool) {
+	s.stocksLock.RLock()
+	defer s.stocksLock.RUnlock()
+
+	stock, ok := s.stocks[symbol]
+	if !ok {
+		return Stock{}, false
+	}
+	return *stock, true
+}
+
+// currentPrice returns the latest price for a symbol
+func (s *Server) currentPrice(symbol string) (float64, bool) {
+	stock, ok := s.lookupStock(symbol)
+	return stock.Price, ok
+}
+
 // createOrder handles order creation
 func (s *Server) createOrder(c *gin.Context) {
 	// Get user ID from context (set by auth middleware)
@@ -367,39 +910,17 @@ func (s *Server) createOrder(c *gin.Context) {
 	}
 
 	var req OrderRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(400, gin.H{"error": "Invalid request"})
-		return
-	}
-
-	// Validate order
-	if req.Side != "buy" && req.Side != "sell" {
-		c.JSON(400, gin.H{"error": "Side must be 'buy' or 'sell'"})
-		return
-	}
-
-	if req.Quantity <= 0 {
-		c.JSON(400, gin.H{"error": "Quantity must be positive"})
-		return
-	}
-
-	if req.Price <= 0 {
-		c.JSON(400, gin.H{"error": "Price must be positive"})
+	if !bindStrictJSON(c, &req) {
 		return
 	}
 
-	// Create order in database
-	order := Order{
-		UserID:    userID.(uint),
-		Symbol:    req.Symbol,
-		Side:      req.Side,
-		Quantity:  req.Quantity,
-		Price:     req.Price,
-		Timestamp: time.Now(),
-	}
-
-	if err := s.db.Create(&order).Error; err != nil {
-		c.JSON(500, gin.H{"error": "Failed to create order"})
+	role, _ := c.Get("role")
+	order, err := s.placeOrder(userID.(uint), role == roleAdmin, req)
+	if err != nil {
+		if !err.RetryAt.IsZero() {
+			c.Header("Retry-After", strconv.Itoa(int(time.Until(err.RetryAt).Seconds())+1))
+		}
+		c.JSON(err.Status, err.body())
 		return
 	}
 
@@ -415,8 +936,14 @@ func (s *Server) getOrders(c *gin.Context) {
 		return
 	}
 
+	// Archived orders are only included when asked for with ?archived=true
+	query := s.userOrders(c, userID)
+	if c.Query("archived") != "true" {
+		query = query.Where("archived = ?", false)
+	}
+
 	var orders []Order
-	if err := s.db.Where("user_id = ?", userID).Order("timestamp DESC").Find(&orders).Error; err != nil {
+	if err := query.Order("timestamp DESC").Find(&orders).Error; err != nil {
 		c.JSON(500, gin.H{"error": "Failed to fetch orders"})
 		return
 	}
@@ -424,90 +951,112 @@ func (s *Server) getOrders(c *gin.Context) {
 	c.JSON(200, orders)
 }
 
-// handleWebSocket handles WebSocket connections
-func (s *Server) handleWebSocket(c *gin.Context) {
-	conn, err := s.upgrader.Upgrade(c.Writer, c.Request, nil)
-	if err != nil {
-		log.Printf("WebSocket upgrade error: %v", err)
-		return
+// broadcastPrices sends prices to all connected clients
+func (s *Server) broadcastPrices() {
+	snapshot := s.currentPrices()
+	prices := snapshot.prices
+	s.publishPrices(prices)
+
+	// Each client gets the prices it subscribed to in its protocol's format.
+	// Encodings are shared between clients with the same subscriptions and
+	// protocol.
+	encoded := make(map[string][]byte, len(snapshot.encoded))
+	for protocol, msg := range snapshot.encoded {
+		encoded[protocol+"|*"] = msg
 	}
-	defer conn.Close()
-
-	// Register client
-	s.clientsLock.Lock()
-	s.clients[conn] = true
-	s.clientsLock.Unlock()
-
-	// Send initial prices
-	s.sendPricesToClient(conn)
-
-	// Keep connection alive and handle client messages
-	for {
-		_, _, err := conn.ReadMessage()
+	s.broadcastEach(func(client *Client) []byte {
+		symbols := client.subscribedSymbols()
+		key := client.protocol + "|*"
+		view := prices
+		if symbols != nil {
+			// Unsubscribed from everything
+			if len(symbols) == 0 {
+				return nil
+			}
+			key = client.protocol + "|" + strings.Join(symbols, ",")
+			view = filterPrices(prices, symbolSet(symbols))
+		}
+		if cached, ok := encoded[key]; ok {
+			return cached
+		}
+		msg, err := encodePrices(view, client.protocol)
 		if err != nil {
-			break
+			log.Printf("Error encoding prices: %v", err)
+			return nil
 		}
-	}
+		encoded[key] = msg
+		return msg
+	})
 
-	// Unregister client
-	s.clientsLock.Lock()
-	delete(s.clients, conn)
-	s.clientsLock.Unlock()
+	s.broadcastCandles(prices, time.Now())
 }
 
-// sendPricesToClient sends current prices to a specific client
-func (s *Server) sendPricesToClient(conn *websocket.Conn) {
-	prices := make([]Stock, 0, len(s.stocks))
-	for _, stock := range s.stocks {
-		prices = append(prices, *stock)
-	}
-	if err := conn.WriteJSON(prices); err != nil {
-		log.Printf("Error sending prices: %v", err)
+// updatePricesJob simulates live price updates
+func (s *Server) updatePricesJob() job {
+	// Only the job's goroutine uses rng
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	return job{
+		name:     "update-prices",
+		interval: s.priceInterval,
+		run: func(context.Context) {
+			if !s.stepPrices(rng) {
+				return
+			}
+
+			// Broadcast updated prices to all clients
+			s.pricesChanged()
+		},
 	}
 }
 
-// broadcastPrices sends prices to all connected clients
-func (s *Server) broadcastPrices() {
-	prices := make([]Stock, 0, len(s.stocks))
-	for _, stock := range s.stocks {
-		prices = append(prices, *stock)
+// stepPrices advances the simulation by one tick. The market index takes a
+// random step, and each stock moves by its beta times the market move plus
+// its own idiosyncratic noise, so stocks tend to rise and fall together.
+// Baskets then follow their constituents. It does nothing, and returns
+// false, while the simulator is paused.
+func (s *Server) stepPrices(rng *rand.Rand) bool {
+	if s.simulatorPaused.Load() {
+		return false
 	}
 
-	s.clientsLock.RLock()
-	defer s.clientsLock.RUnlock()
+	s.stocksLock.Lock()
+	defer s.stocksLock.Unlock()
+
+	// Market move between -1% and +1%
+	marketChange := (rng.Float64()*2 - 1) / 100
+	s.market.apply(marketChange)
 
-	for client := range s.clients {
-		if err := client.WriteJSON(prices); err != nil {
-			log.Printf("Error broadcasting to client: %v", err)
-			client.Close()
-			delete(s.clients, client)
+	now := time.Now()
+	for symbol, stock := range s.stocks {
+		if stock.Basket {
+			continue
 		}
-	}
-}
 
-// updatePrices simulates live price updates
-func (s *Server) updatePrices() {
-	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
-	ticker := time.NewTicker(3 * time.Second) // Update every 3 seconds
-	defer ticker.Stop()
-
-	for range ticker.C {
-		// Update each stock price
-		for symbol, stock := range s.stocks {
-			// Random price change between -2% and +2%
-			changePercent := (rng.Float64()*4 - 2) / 100 // -2% to +2%
-			newPrice := stock.Price * (1 + changePercent)
-
-			// Ensure price doesn't go below a minimum
-			if newPrice < 1.0 {
-				newPrice = 1.0
-			}
+		// Idiosyncratic move between -1% and +1% on top of the market
+		ownChange := (rng.Float64()*2 - 1) / 100
+		changePercent := stock.Beta*marketChange + ownChange
+		newPrice := roundDecimals(stock.Price*(1+changePercent), stock.PriceDecimals)
 
-			stock.Price = newPrice
-			log.Printf("Updated %s price to %.2f", symbol, newPrice)
+		// Ensure price doesn't go below a minimum
+		if newPrice < 1.0 {
+			newPrice = 1.0
 		}
 
-		// Broadcast updated prices to all clients
-		s.broadcastPrices()
+		if newPrice != stock.Price {
+			stock.Price = newPrice
+			stock.LastUpdate = now
+		}
+		stock.updateQuote()
+		log.Printf("Updated %s price to %.*f", symbol, stock.PriceDecimals, newPrice)
 	}
+	s.updateBaskets(now)
+	s.snapshot = nil
+	return true
+}
+
+// roundDecimals rounds v to the given number of decimal places. Prices and
+// quantities both go through it so they round the same way.
+func roundDecimals(v float64, decimals int) float64 {
+	scale := math.Pow(10, float64(decimals))
+	return math.Round(v*scale) / scale
 }
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// newTestConfig loads the configuration from env on top of the defaults,
+// for a fresh database, and sets the globals main sets from it
+func newTestConfig(t testing.TB, env map[string]string) Config {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	for key, value := range env {
+		t.Setenv(key, value)
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	cfg.DBPath = filepath.Join(t.TempDir(), "test.db")
+	cfg.PasswordHasher = bcryptHasher{cost: bcrypt.MinCost}
+
+	jwtSecret = []byte("test-secret")
+	jwtLeeway = cfg.TokenLeeway
+	jwtKeyID = cfg.TokenKeyID
+	jwtPreviousKeys = cfg.PreviousTokenKeys
+	moneyRounding, feeRounding = cfg.MoneyRounding, cfg.FeeRounding
+	return cfg
+}
+
+// newTestServer builds a server on a fresh database, configured from env
+func newTestServer(t testing.TB, env map[string]string) *Server {
+	t.Helper()
+	s, _ := newTestRouter(t, env)
+	return s
+}
+
+// newTestRouter builds a server configured from env and its HTTP API
+func newTestRouter(t testing.TB, env map[string]string) (*Server, *gin.Engine) {
+	t.Helper()
+	cfg := newTestConfig(t, env)
+	s := NewServer(cfg)
+	t.Cleanup(func() {
+		if sqlDB, err := s.db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	r, err := s.router(cfg)
+	if err != nil {
+		t.Fatalf("router: %v", err)
+	}
+	return s, r
+}
+
+// doRequest sends a request to h, with a Bearer token unless token is
+// empty, and a JSON body unless body is empty
+func doRequest(h http.Handler, method, path, token, body string) *httptest.ResponseRecorder {
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+// createTestUser adds a user with the given role straight to the database
+func createTestUser(t testing.TB, s *Server, username, role string) User {
+	t.Helper()
+	user := User{Username: username, Password: "-", Role: role, AccountMode: accountModePaper}
+	if err := s.db.Create(&user).Error; err != nil {
+		t.Fatalf("creating user %s: %v", username, err)
+	}
+	return user
+}
+
+// createFilledOrder records a filled order for the user without going
+// through placeOrder's checks
+func createFilledOrder(t testing.TB, s *Server, userID uint, symbol, side string, quantity, price float64) Order {
+	t.Helper()
+	var order Order
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		number, err := nextOrderNumber(tx, userID)
+		if err != nil {
+			return err
+		}
+		order = Order{UserID: userID, Number: number, Symbol: symbol, Side: side, Quantity: quantity, Price: price, Timestamp: time.Now(), Status: orderStatusFilled, Version: 1}
+		return tx.Create(&order).Error
+	})
+	if err != nil {
+		t.Fatalf("creating order: %v", err)
+	}
+	s.positionsCache.invalidate(userID)
+	return order
+}
+
+// newTestWebSocketServer serves the server's WebSocket endpoint
+func newTestWebSocketServer(t testing.TB, s *Server) *httptest.Server {
+	t.Helper()
+	r := gin.New()
+	r.GET("/ws", s.handleWebSocket)
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// dialTestWebSocket connects to srv's WebSocket with token, if any, and
+// reads the price snapshot, by which time the client is registered
+func dialTestWebSocket(t testing.TB, srv *httptest.Server, token string) *websocket.Conn {
+	t.Helper()
+	target := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws"
+	if token != "" {
+		target += "?token=" + url.QueryEscape(token)
+	}
+	conn, resp, err := websocket.DefaultDialer.Dial(target, nil)
+	if err != nil {
+		status := 0
+		if resp != nil {
+			status = resp.StatusCode
+		}
+		t.Fatalf("dial: %v (status %d)", err, status)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, _, err := conn.ReadMessage(); err != nil {
+		t.Fatalf("reading snapshot: %v", err)
+	}
+	return conn
+}
+
+// countClients returns how many WebSocket clients are connected
+func countClients(s *Server) int {
+	s.clientsLock.RLock()
+	defer s.clientsLock.RUnlock()
+	return len(s.clients)
+}
+
+// jsonHasCode reports whether a JSON error body carries the given code
+func jsonHasCode(body []byte, code string) bool {
+	var resp struct {
+		Code string `json:"code"`
+	}
+	return json.Unmarshal(body, &resp) == nil && resp.Code == code
+}
+
+// quietLogs discards the server's log output for the rest of the test, for
+// benchmarks that would otherwise log every price update
+func quietLogs(t testing.TB) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+}
+
+// gatedPriceFeeds are the REST price feeds PRICES_REQUIRE_AUTH puts behind
+// a token, less the event stream, which never ends when it is open
+var gatedPriceFeeds = []string{"/api/prices", "/api/baskets", "/api/index", "/api/trades/recent"}
+
+func TestPriceFeedsPublicByDefault(t *testing.T) {
+	s, r := newTestRouter(t, nil)
+	for _, path := range gatedPriceFeeds {
+		if w := doRequest(r, "GET", path, "", ""); w.Code != 200 {
+			t.Errorf("%s: status %d, want 200", path, w.Code)
+		}
+	}
+
+	dialTestWebSocket(t, newTestWebSocketServer(t, s), "")
+}
+
+func TestPriceFeedsRequireAuth(t *testing.T) {
+	s, r := newTestRouter(t, map[string]string{"PRICES_REQUIRE_AUTH": "true"})
+	user := createTestUser(t, s, "trader", roleUser)
+	_, token := createTestSession(t, s, user)
+
+	for _, path := range append(gatedPriceFeeds, "/api/stream") {
+		if w := doRequest(r, "GET", path, "", ""); w.Code != 401 || !jsonHasCode(w.Body.Bytes(), authCodeMissing) {
+			t.Errorf("%s without a token: status %d: %s", path, w.Code, w.Body)
+		}
+	}
+	for _, path := range gatedPriceFeeds {
+		if w := doRequest(r, "GET", path, token, ""); w.Code != 200 {
+			t.Errorf("%s with a token: status %d, want 200", path, w.Code)
+		}
+	}
+
+	srv := newTestWebSocketServer(t, s)
+	_, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", nil)
+	if err == nil {
+		t.Fatal("WebSocket without a token accepted")
+	}
+	if resp == nil || resp.StatusCode != 401 {
+		t.Fatalf("WebSocket without a token: response %v, want 401", resp)
+	}
+	dialTestWebSocket(t, srv, token)
+}
+
+func TestRecentTradesAreFilledOrders(t *testing.T) {
+	s, r := newTestRouter(t, map[string]string{"SETTLEMENT_DELAY": "1h"})
+	user := createTestUser(t, s, "trader", roleUser)
+	createFilledOrder(t, s, user.ID, "AAPL", sideBuy, 2, 100)
+	pending := placePendingOrder(t, s, user.ID)
+	cancelled := placePendingOrder(t, s, user.ID)
+	if _, err := s.cancelOrder(cancelled.ID, user.ID, user.ID, nil); err != nil {
+		t.Fatalf("cancelOrder: %s", err.Message)
+	}
+
+	w := doRequest(r, "GET", "/api/trades/recent", "", "")
+	var trades []Trade
+	if err := json.Unmarshal(w.Body.Bytes(), &trades); w.Code != 200 || err != nil {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	if len(trades) != 1 || trades[0].Quantity != 2 {
+		t.Fatalf("trades = %+v, want only the filled order", trades)
+	}
+
+	// Once the pending order settles it trades
+	if n, err := s.settleOrders(pending.Timestamp.Add(2 * time.Hour)); err != nil || n != 1 {
+		t.Fatalf("settleOrders = %d, %v", n, err)
+	}
+	w = doRequest(r, "GET", "/api/trades/recent", "", "")
+	if err := json.Unmarshal(w.Body.Bytes(), &trades); err != nil || len(trades) != 2 {
+		t.Fatalf("trades after settlement = %s", w.Body)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// maintenanceRetryAfter is the Retry-After hint, in seconds, sent while
+	// the system is in maintenance mode
+	maintenanceRetryAfter = 300
+
+	maintenanceMessage = "System is under maintenance, please retry later"
+)
+
+// maintenanceNotice is broadcast to WebSocket clients when the mode changes
+type maintenanceNotice struct {
+	Type    string `json:"type"` // "maintenance"
+	Enabled bool   `json:"enabled"`
+}
+
+// MaintenanceRequest toggles maintenance mode
+type MaintenanceRequest struct {
+	Enabled *bool `json:"enabled"`
+}
+
+// blockDuringMaintenance rejects mutating requests with 503 while the
+// system is in maintenance mode
+func (s *Server) blockDuringMaintenance() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if s.maintenance.Load() {
+			c.Header("Retry-After", strconv.Itoa(maintenanceRetryAfter))
+			c.JSON(503, gin.H{"error": maintenanceMessage, "code": orderCodeMaintenance})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// getMaintenance reports whether maintenance mode is on
+func (s *Server) getMaintenance(c *gin.Context) {
+	c.JSON(200, gin.H{"enabled": s.maintenance.Load()})
+}
+
+// setMaintenance turns maintenance mode on or off and notifies clients
+func (s *Server) setMaintenance(c *gin.Context) {
+	var req MaintenanceRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Enabled == nil {
+		c.JSON(400, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	enabled := *req.Enabled
+	if s.maintenance.Swap(enabled) != enabled {
+		userID, _ := c.Get("user_id")
+		log.Printf("Maintenance mode set to %t by user %v", enabled, userID)
+
+		if msg, err := json.Marshal(maintenanceNotice{Type: "maintenance", Enabled: enabled}); err == nil {
+			s.broadcast(msg)
+		}
+	}
+
+	c.JSON(200, gin.H{"enabled": enabled})
+}
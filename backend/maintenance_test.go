@@ -0,0 +1,67 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMaintenanceBlocksWritesNotReads(t *testing.T) {
+	s, r := newTestRouter(t, map[string]string{"MAINTENANCE_MODE": "true"})
+	user := createTestUser(t, s, "trader", roleUser)
+	_, token := createTestSession(t, s, user)
+	admin := seededAdmin(t, s)
+	_, adminToken := createTestSession(t, s, admin)
+
+	writes := []struct{ method, path, body string }{
+		{"POST", "/api/orders", `{"symbol":"AAPL","side":"buy","quantity":1}`},
+		{"POST", "/api/orders/cancel-all", ""},
+		{"POST", "/api/orders/1/cancel", `{"version":1}`},
+		{"POST", "/api/portfolio/recalculate", ""},
+		{"POST", "/api/webhooks", `{"url":"https://example.com/hook"}`},
+		{"DELETE", "/api/webhooks", ""},
+		{"POST", "/api/notifications/read-all", ""},
+		{"POST", "/api/notifications/1/read", ""},
+		{"DELETE", "/api/me/sessions/1", ""},
+	}
+	for _, req := range writes {
+		w := doRequest(r, req.method, req.path, token, req.body)
+		if w.Code != 503 || !jsonHasCode(w.Body.Bytes(), orderCodeMaintenance) || w.Header().Get("Retry-After") != "300" {
+			t.Errorf("%s %s: status %d, Retry-After %q: %s", req.method, req.path, w.Code, w.Header().Get("Retry-After"), w.Body)
+		}
+	}
+	if w := doRequest(r, "POST", "/api/signup", "", `{"username":"newcomer","password":"Correct-Horse-9"}`); w.Code != 503 {
+		t.Errorf("signup: status %d, want 503", w.Code)
+	}
+
+	for _, path := range []string{"/api/prices", "/api/orders", "/api/me", "/api/notifications", "/api/trades/recent"} {
+		if w := doRequest(r, "GET", path, token, ""); w.Code != 200 {
+			t.Errorf("GET %s: status %d, want it served", path, w.Code)
+		}
+	}
+	if w := doRequest(r, "POST", "/api/orders/preview", token, `{"symbol":"AAPL","side":"buy","quantity":1}`); w.Code != 200 {
+		t.Errorf("preview: status %d, want 200", w.Code)
+	}
+
+	// The WebSocket still streams, but refuses orders
+	conn := dialTestWebSocket(t, newTestWebSocketServer(t, s), token)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var notice maintenanceNotice
+	if err := conn.ReadJSON(&notice); err != nil || notice.Type != "maintenance" || !notice.Enabled {
+		t.Fatalf("notice = %+v, %v", notice, err)
+	}
+	if err := conn.WriteJSON(clientMessage{Action: "order", Order: &OrderRequest{Symbol: "AAPL", Side: sideBuy, Quantity: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	var reply errorMessage
+	if err := conn.ReadJSON(&reply); err != nil || reply.Status != 503 || reply.Code != orderCodeMaintenance {
+		t.Fatalf("order over the WebSocket = %+v, %v", reply, err)
+	}
+
+	// Turning it off lets orders through again
+	if w := doRequest(r, "POST", "/api/admin/maintenance", adminToken, `{"enabled":false}`); w.Code != 200 {
+		t.Fatalf("turning maintenance off: status %d: %s", w.Code, w.Body)
+	}
+	if w := doRequest(r, "POST", "/api/orders", token, `{"symbol":"AAPL","side":"buy","quantity":1}`); w.Code != 201 {
+		t.Fatalf("order after maintenance: status %d: %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// marketIndexBase is the starting level of the synthetic market index
+const marketIndexBase = 1000.0
+
+// marketIndex is a synthetic index that random-walks every tick and drives
+// the correlated part of each stock's move
+type marketIndex struct {
+	Value         float64   `json:"value"`
+	ChangePercent float64   `json:"change_percent"` // Move on the last tick
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// newMarketIndex creates an index at its base level
+func newMarketIndex() marketIndex {
+	return marketIndex{Value: marketIndexBase, UpdatedAt: time.Now()}
+}
+
+// apply moves the index by change (a fraction, e.g. 0.01 for +1%)
+func (m *marketIndex) apply(change float64) {
+	m.Value *= 1 + change
+	m.ChangePercent = change * 100
+	m.UpdatedAt = time.Now()
+}
+
+// getMarketIndex returns the current synthetic market index
+func (s *Server) getMarketIndex(c *gin.Context) {
+	s.stocksLock.RLock()
+	index := s.market
+	s.stocksLock.RUnlock()
+
+	c.JSON(200, index)
+}
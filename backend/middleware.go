@@ -0,0 +1,54 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"regexp"
+	"runtime/debug"
+
+	"github.com/gin-gonic/gin"
+)
+
+// requestIDHeader carries the id that ties a response to the server's logs
+const requestIDHeader = "X-Request-ID"
+
+// requestIDPattern accepts caller-supplied request ids that are safe to log
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9_.:-]{1,64}$`)
+
+// requestID tags each request with an id, reusing the caller's X-Request-ID
+// when it is safe to log, and echoes it on the response
+func requestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(requestIDHeader)
+		if !requestIDPattern.MatchString(id) {
+			b := make([]byte, 8)
+			if _, err := rand.Read(b); err != nil {
+				log.Printf("Error generating request id: %v", err)
+			}
+			id = hex.EncodeToString(b)
+		}
+
+		c.Set("request_id", id)
+		c.Header(requestIDHeader, id)
+		c.Next()
+	}
+}
+
+// recovery turns a panicking handler into a JSON 500. The panic and stack
+// are logged with the request id; the client only sees a generic error.
+func recovery() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Printf("Panic handling %s %s (request %s): %v\n%s", c.Request.Method, c.Request.URL.Path, c.GetString("request_id"), r, debug.Stack())
+				if c.Writer.Written() {
+					c.Abort()
+					return
+				}
+				c.AbortWithStatusJSON(500, gin.H{"error": "Internal server error", "code": "INTERNAL"})
+			}
+		}()
+		c.Next()
+	}
+}
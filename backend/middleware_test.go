@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestPanicRecovery(t *testing.T) {
+	_, r := newTestRouter(t, nil)
+	r.GET("/api/panic", func(c *gin.Context) {
+		panic("secret detail")
+	})
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	req := httptest.NewRequest("GET", "/api/panic", nil)
+	req.Header.Set(requestIDHeader, "trace-42")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	var body map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); w.Code != 500 || err != nil {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	if body["error"] != "Internal server error" || body["code"] != "INTERNAL" || len(body) != 2 {
+		t.Errorf("body = %s", w.Body)
+	}
+	if strings.Contains(w.Body.String(), "secret detail") || strings.Contains(w.Body.String(), "goroutine") {
+		t.Errorf("response leaks the panic: %s", w.Body)
+	}
+	if got := w.Header().Get(requestIDHeader); got != "trace-42" {
+		t.Errorf("request id header = %q, want trace-42", got)
+	}
+
+	// The panic and its stack are logged against the request id
+	logged := logs.String()
+	if !strings.Contains(logged, "request trace-42") || !strings.Contains(logged, "secret detail") || !strings.Contains(logged, "goroutine") {
+		t.Errorf("log = %q", logged)
+	}
+
+	// The server keeps serving after a panic
+	if w := doRequest(r, "GET", "/api/prices", "", ""); w.Code != 200 {
+		t.Errorf("after panic: status %d", w.Code)
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	_, r := newTestRouter(t, nil)
+	tests := []struct {
+		name   string
+		header string
+		reused bool
+	}{
+		{"safe id reused", "abc-123.x:y_z", true},
+		{"missing", "", false},
+		{"unsafe characters", "evil\nid", false},
+		{"too long", strings.Repeat("a", 65), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/prices", nil)
+			if tt.header != "" {
+				req.Header.Set(requestIDHeader, tt.header)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			got := w.Header().Get(requestIDHeader)
+			if tt.reused && got != tt.header {
+				t.Fatalf("request id = %q, want %q", got, tt.header)
+			}
+			if !tt.reused && (got == tt.header || !requestIDPattern.MatchString(got)) {
+				t.Fatalf("request id = %q, want a generated one", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strings"
+)
+
+// Rounding modes for money amounts. Amounts are rounded by magnitude, so
+// negative amounts round the same way as their positive counterparts.
+const (
+	roundHalfUp   = "half-up"   // Halves away from zero: 0.125 -> 0.13
+	roundHalfEven = "half-even" // Halves to the even digit (banker's rounding): 0.125 -> 0.12
+	roundUp       = "up"        // Any remainder away from zero: 0.121 -> 0.13
+)
+
+// moneyEpsilon absorbs float error when deciding whether an amount sits
+// exactly on a half or a whole unit, so 1.005 (stored as 1.00499999...)
+// still counts as a half
+const moneyEpsilon = 1e-6
+
+// moneyRounding rounds notionals and totals, and feeRounding rounds fees.
+// They are set once from MONEY_ROUNDING and FEE_ROUNDING at startup.
+var (
+	moneyRounding = roundHalfUp
+	feeRounding   = roundHalfUp
+)
+
+// loadRoundingModes reads MONEY_ROUNDING and FEE_ROUNDING. Fees round like
+// every other amount unless FEE_ROUNDING says otherwise.
+func loadRoundingModes() (money, fee string, err error) {
+	if money, err = parseRoundingMode("MONEY_ROUNDING", roundHalfUp); err != nil {
+		return money, fee, err
+	}
+	fee, err = parseRoundingMode("FEE_ROUNDING", money)
+	return money, fee, err
+}
+
+// parseRoundingMode reads a rounding mode from the environment variable key,
+// returning def when unset
+func parseRoundingMode(key, def string) (string, error) {
+	mode := strings.ToLower(strings.TrimSpace(os.Getenv(key)))
+	switch mode {
+	case "":
+		return def, nil
+	case roundHalfUp, roundHalfEven, roundUp:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("%s must be %q, %q or %q, got %q", key, roundHalfUp, roundHalfEven, roundUp, mode)
+	}
+}
+
+// orderNotional is the value of quantity shares of symbol at price, in the
+// symbol's currency, rounded with moneyRounding to the symbol's precision
+func orderNotional(symbol string, quantity, price float64) float64 {
+	return roundMoney(quantity*price, decimalsFor(symbol), moneyRounding)
+}
+
+// roundMoney rounds a money amount to decimals places using mode
+func roundMoney(v float64, decimals int, mode string) float64 {
+	sign := 1.0
+	if v < 0 {
+		sign, v = -1, -v
+	}
+
+	scale := math.Pow(10, float64(decimals))
+	scaled := v * scale
+	units := math.Floor(scaled)
+	remainder := scaled - units
+
+	switch mode {
+	case roundUp:
+		if remainder > moneyEpsilon {
+			units++
+		}
+	case roundHalfEven:
+		switch {
+		case math.Abs(remainder-0.5) < moneyEpsilon:
+			if math.Mod(units, 2) != 0 {
+				units++
+			}
+		case remainder > 0.5:
+			units++
+		}
+	default:
+		if remainder > 0.5-moneyEpsilon {
+			units++
+		}
+	}
+	return sign * units / scale
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestRoundMoneyModes(t *testing.T) {
+	tests := []struct {
+		amount float64
+		mode   string
+		want   float64
+	}{
+		// 0.125 sits exactly on a half: half-up rounds away from zero,
+		// banker's rounding to the even cent
+		{0.125, roundHalfUp, 0.13},
+		{0.125, roundHalfEven, 0.12},
+		{0.135, roundHalfUp, 0.14},
+		{0.135, roundHalfEven, 0.14},
+		{-0.125, roundHalfUp, -0.13},
+		{-0.125, roundHalfEven, -0.12},
+
+		// 1.005 is stored just under the half but still counts as one
+		{1.005, roundHalfUp, 1.01},
+		{1.005, roundHalfEven, 1.00},
+
+		// Off the half both agree, and up rounds any remainder away
+		{0.124, roundHalfUp, 0.12},
+		{0.124, roundHalfEven, 0.12},
+		{0.121, roundUp, 0.13},
+		{0.12, roundUp, 0.12},
+	}
+	for _, tt := range tests {
+		if got := roundMoney(tt.amount, 2, tt.mode); got != tt.want {
+			t.Errorf("roundMoney(%g, 2, %s) = %g, want %g", tt.amount, tt.mode, got, tt.want)
+		}
+	}
+}
+
+func TestOrderNotionalFollowsMoneyRounding(t *testing.T) {
+	defer func(mode string) { moneyRounding = mode }(moneyRounding)
+
+	// 0.5 shares at 0.25 is 0.125
+	order := Order{Symbol: "AAPL", Quantity: 0.5, Price: 0.25}
+	for mode, want := range map[string]string{roundHalfUp: "0.13", roundHalfEven: "0.12"} {
+		moneyRounding = mode
+		body, err := json.Marshal(order)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded struct {
+			Notional json.Number `json:"notional"`
+		}
+		if err := json.Unmarshal(body, &decoded); err != nil {
+			t.Fatal(err)
+		}
+		if decoded.Notional.String() != want {
+			t.Errorf("%s: notional = %s, want %s", mode, decoded.Notional, want)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Notification kinds
+const (
+	notificationWelcome = "welcome"
+	notificationFill    = "fill"
+)
+
+// defaultWelcomeMessage greets new users when WELCOME_MESSAGE isn't set
+const defaultWelcomeMessage = "Welcome to the Trading Dashboard! Place your first order to get started."
+
+// Notification is a message in a user's in-app inbox, such as a fill
+type Notification struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	UserID    uint       `gorm:"not null;index" json:"-"`
+	Kind      string     `gorm:"not null" json:"kind"`
+	Message   string     `gorm:"not null" json:"message"`
+	ReadAt    *time.Time `json:"read_at"` // nil while unread
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// NotificationPage is one page of a user's inbox
+type NotificationPage struct {
+	Notifications []Notification `json:"notifications"`
+	Total         int64          `json:"total"`  // Matching notifications across all pages
+	Unread        int64          `json:"unread"` // Unread notifications, whatever the filter
+	Limit         int            `json:"limit"`
+	Offset        int            `json:"offset"`
+}
+
+// notificationMessage pushes a new notification to the user's WebSocket
+// connections
+type notificationMessage struct {
+	Type         string       `json:"type"` // "notification"
+	Notification Notification `json:"notification"`
+}
+
+// fillVerbs describes a filled order's side in its notification
+var fillVerbs = map[string]string{
+	sideBuy:   "Bought",
+	sideSell:  "Sold",
+	sideShort: "Shorted",
+	sideCover: "Covered",
+}
+
+// createWelcomeNotification leaves the welcome message in a new user's
+// inbox, unless WELCOME_MESSAGE turned it off
+func (s *Server) createWelcomeNotification(tx *gorm.DB, userID uint) error {
+	if s.welcomeMessage == "" {
+		return nil
+	}
+	return tx.Create(&Notification{UserID: userID, Kind: notificationWelcome, Message: s.welcomeMessage}).Error
+}
+
+// notify stores a notification and pushes it to the user's WebSocket
+// connections. Failing to store it is only logged; whatever it reports has
+// already happened.
+func (s *Server) notify(userID uint, kind, message string) {
+	notification := Notification{UserID: userID, Kind: kind, Message: message}
+	if err := s.db.Create(&notification).Error; err != nil {
+		log.Printf("Error storing notification for user %d: %v", userID, err)
+		return
+	}
+	s.notifyUser(userID, notificationMessage{Type: "notification", Notification: notification})
+}
+
+// notifyFill tells the order's owner that it filled
+func (s *Server) notifyFill(order Order) {
+	message := fmt.Sprintf("%s %g %s at %.*f (order #%d)", fillVerbs[order.Side], order.Quantity, order.Symbol, decimalsFor(order.Symbol), order.Price, order.Number)
+	s.notify(order.UserID, notificationFill, message)
+}
+
+// getNotifications returns a page of the authenticated user's inbox, newest
+// first. ?unread=true leaves out notifications already read.
+func (s *Server) getNotifications(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(401, gin.H{"error": "Unauthorized"})
+		return
+	}
+	requested, ok := s.pagination.parsePage(c)
+	if !ok {
+		return
+	}
+	page := NotificationPage{Notifications: []Notification{}, Limit: requested.Limit, Offset: requested.Offset}
+
+	if err := s.db.Model(&Notification{}).Where("user_id = ? AND read_at IS NULL", userID).Count(&page.Unread).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to fetch notifications"})
+		return
+	}
+	query := s.db.Model(&Notification{}).Where("user_id = ?", userID)
+	if c.Query("unread") == "true" {
+		query = query.Where("read_at IS NULL")
+	}
+	if err := query.Count(&page.Total).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to fetch notifications"})
+		return
+	}
+	if err := query.Order("id DESC").Limit(page.Limit).Offset(page.Offset).Find(&page.Notifications).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to fetch notifications"})
+		return
+	}
+
+	c.JSON(200, page)
+}
+
+// markNotificationRead marks one of the authenticated user's notifications
+// read. Marking it again keeps the time it was first read; other users'
+// notifications are reported as not found.
+func (s *Server) markNotificationRead(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(401, gin.H{"error": "Unauthorized"})
+		return
+	}
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil || id == 0 {
+		c.JSON(400, gin.H{"error": "Invalid notification id"})
+		return
+	}
+
+	var notification Notification
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("id = ? AND user_id = ?", id, userID).First(&notification).Error; err != nil {
+			return err
+		}
+		if notification.ReadAt != nil {
+			return nil
+		}
+		now := time.Now()
+		notification.ReadAt = &now
+		return tx.Model(&notification).Update("read_at", now).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(404, gin.H{"error": "Notification not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to update notification"})
+		return
+	}
+
+	c.JSON(200, notification)
+}
+
+// markAllNotificationsRead marks every unread notification of the
+// authenticated user read
+func (s *Server) markAllNotificationsRead(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(401, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	res := s.db.Model(&Notification{}).Where("user_id = ? AND read_at IS NULL", userID).Update("read_at", time.Now())
+	if res.Error != nil {
+		c.JSON(500, gin.H{"error": "Failed to update notifications"})
+		return
+	}
+
+	c.JSON(200, gin.H{"marked": res.RowsAffected})
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// signUp creates an account through the API and returns its token
+func signUp(t *testing.T, h http.Handler, username string) (string, User) {
+	t.Helper()
+	w := doRequest(h, "POST", "/api/signup", "", `{"username":"`+username+`","password":"Correct-Horse-9"}`)
+	if w.Code != 201 {
+		t.Fatalf("signup: status %d: %s", w.Code, w.Body)
+	}
+	var resp LoginResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp.Token, resp.User
+}
+
+// listNotifications fetches the inbox at path with token
+func listNotifications(t *testing.T, h http.Handler, token, path string) NotificationPage {
+	t.Helper()
+	w := doRequest(h, "GET", path, token, "")
+	if w.Code != 200 {
+		t.Fatalf("GET %s: status %d: %s", path, w.Code, w.Body)
+	}
+	var page NotificationPage
+	if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+		t.Fatal(err)
+	}
+	return page
+}
+
+func TestNotificationInbox(t *testing.T) {
+	s, r := newTestRouter(t, nil)
+	token, user := signUp(t, r, "trader")
+
+	// Signing up leaves the welcome message
+	page := listNotifications(t, r, token, "/api/notifications")
+	if page.Total != 1 || page.Unread != 1 || page.Notifications[0].Kind != notificationWelcome || page.Notifications[0].Message != defaultWelcomeMessage {
+		t.Fatalf("inbox after signup = %+v", page)
+	}
+	welcome := page.Notifications[0]
+
+	// A fill adds one, listed first
+	if _, err := s.placeOrder(user.ID, false, OrderRequest{Symbol: "AAPL", Side: sideBuy, Quantity: 2}); err != nil {
+		t.Fatalf("placeOrder: %s", err.Message)
+	}
+	page = listNotifications(t, r, token, "/api/notifications")
+	if page.Total != 2 || page.Unread != 2 {
+		t.Fatalf("inbox after a fill = %+v", page)
+	}
+	fill := page.Notifications[0]
+	if fill.Kind != notificationFill || !strings.HasPrefix(fill.Message, "Bought 2 AAPL at ") || fill.ReadAt != nil {
+		t.Fatalf("fill notification = %+v", fill)
+	}
+
+	// Marking one read leaves it in the inbox but not the unread filter
+	path := "/api/notifications/" + strconv.FormatUint(uint64(welcome.ID), 10) + "/read"
+	w := doRequest(r, "POST", path, token, "")
+	if w.Code != 200 {
+		t.Fatalf("mark read: status %d: %s", w.Code, w.Body)
+	}
+	var read Notification
+	if err := json.Unmarshal(w.Body.Bytes(), &read); err != nil || read.ReadAt == nil {
+		t.Fatalf("marked notification = %s", w.Body)
+	}
+	w = doRequest(r, "POST", path, token, "")
+	var again Notification
+	if err := json.Unmarshal(w.Body.Bytes(), &again); err != nil || again.ReadAt == nil || !again.ReadAt.Equal(*read.ReadAt) {
+		t.Fatalf("marking again changed the read time: %s", w.Body)
+	}
+	page = listNotifications(t, r, token, "/api/notifications?unread=true")
+	if page.Total != 1 || page.Unread != 1 || page.Notifications[0].ID != fill.ID {
+		t.Fatalf("unread inbox = %+v", page)
+	}
+
+	// Read-all clears the rest
+	w = doRequest(r, "POST", "/api/notifications/read-all", token, "")
+	if w.Code != 200 || strings.TrimSpace(w.Body.String()) != `{"marked":1}` {
+		t.Fatalf("read-all: status %d: %s", w.Code, w.Body)
+	}
+	if page = listNotifications(t, r, token, "/api/notifications"); page.Total != 2 || page.Unread != 0 {
+		t.Fatalf("inbox after read-all = %+v", page)
+	}
+}
+
+func TestNotificationsArePrivate(t *testing.T) {
+	_, r := newTestRouter(t, nil)
+	owner, _ := signUp(t, r, "owner")
+	other, _ := signUp(t, r, "other")
+
+	page := listNotifications(t, r, owner, "/api/notifications")
+	path := "/api/notifications/" + strconv.FormatUint(uint64(page.Notifications[0].ID), 10) + "/read"
+	if w := doRequest(r, "POST", path, other, ""); w.Code != 404 {
+		t.Fatalf("marking another user's notification: status %d, want 404", w.Code)
+	}
+	if page := listNotifications(t, r, owner, "/api/notifications"); page.Unread != 1 {
+		t.Fatalf("owner's unread = %d, want 1", page.Unread)
+	}
+}
+
+func TestWelcomeMessageDisabled(t *testing.T) {
+	_, r := newTestRouter(t, map[string]string{"WELCOME_MESSAGE": ""})
+	token, _ := signUp(t, r, "trader")
+
+	if page := listNotifications(t, r, token, "/api/notifications"); page.Total != 0 {
+		t.Fatalf("inbox = %+v, want empty", page)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// nextOrderNumber reserves the user's next order number in tx. Bumping the
+// counter on the user row takes SQLite's write lock first, so concurrent
+// orders for the same user get distinct numbers.
+func nextOrderNumber(tx *gorm.DB, userID uint) (uint, error) {
+	if err := tx.Model(&User{}).Where("id = ?", userID).Update("order_seq", gorm.Expr("order_seq + 1")).Error; err != nil {
+		return 0, err
+	}
+	var user User
+	if err := tx.Select("order_seq").First(&user, userID).Error; err != nil {
+		return 0, err
+	}
+	return user.OrderSeq, nil
+}
+
+// migrateOrderNumbers numbers the orders of databases created before order
+// numbers existed, oldest first per user, so the unique index AutoMigrate
+// adds afterwards can be built. It reports whether users' counters need
+// catching up with backfillOrderSeqs once AutoMigrate has added them.
+func migrateOrderNumbers(db *gorm.DB) (bool, error) {
+	migrator := db.Migrator()
+	if !migrator.HasTable(&Order{}) || migrator.HasColumn(&Order{}, "Number") {
+		return false, nil
+	}
+	if err := migrator.AddColumn(&Order{}, "Number"); err != nil {
+		return false, err
+	}
+	err := db.Exec(`UPDATE orders SET number = (
+		SELECT COUNT(*) FROM orders AS earlier
+		WHERE earlier.user_id = orders.user_id AND earlier.id <= orders.id
+	)`).Error
+	return err == nil, err
+}
+
+// backfillOrderSeqs starts each user's order counter after their highest
+// existing order number
+func backfillOrderSeqs(db *gorm.DB) error {
+	return db.Exec(`UPDATE users SET order_seq = (
+		SELECT COALESCE(MAX(number), 0) FROM orders WHERE orders.user_id = users.id
+	)`).Error
+}
+
+// orderNumberParam parses the :number route parameter
+func orderNumberParam(c *gin.Context) (uint, bool) {
+	number, err := strconv.ParseUint(c.Param("number"), 10, 64)
+	if err != nil || number == 0 {
+		return 0, false
+	}
+	return uint(number), true
+}
+
+// findOwnOrder looks up one of the user's orders by its order number
+func (s *Server) findOwnOrder(userID, number uint) (Order, bool, error) {
+	var found []Order
+	if err := s.db.Where("user_id = ? AND number = ?", userID, number).Limit(1).Find(&found).Error; err != nil {
+		return Order{}, false, err
+	}
+	if len(found) == 0 {
+		return Order{}, false, nil
+	}
+	return found[0], true, nil
+}
+
+// getOrder returns one of the authenticated user's orders by its order
+// number. Numbers are per user, so other users' orders can't be reached.
+func (s *Server) getOrder(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(401, gin.H{"error": "Unauthorized"})
+		return
+	}
+	number, ok := orderNumberParam(c)
+	if !ok {
+		c.JSON(400, gin.H{"error": "Invalid order number"})
+		return
+	}
+
+	order, found, err := s.findOwnOrder(userID.(uint), number)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to fetch order"})
+		return
+	}
+	if !found {
+		c.JSON(404, gin.H{"error": "Order not found", "code": orderCodeOrderNotFound})
+		return
+	}
+	c.JSON(200, order)
+}
@@ -0,0 +1,576 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Order statuses. Without a SETTLEMENT_DELAY an order is filled as soon as
+// it is placed. With one it starts pending, and the settlement job moves it
+// to filled once the delay has passed; until then it can be cancelled. Open
+// is reserved for resting orders, which nothing places yet.
+const (
+	orderStatusOpen      = "open"
+	orderStatusPending   = "pending"
+	orderStatusFilled    = "filled"
+	orderStatusCancelled = "cancelled"
+)
+
+// errOrderRejected rolls back placeOrder's transaction when an order fails
+// a check made inside it
+var errOrderRejected = errors.New("order rejected")
+
+// workingOrderStatuses are the statuses of orders that haven't finished
+var workingOrderStatuses = []string{orderStatusOpen, orderStatusPending}
+
+// placeOrder validates and records an order for a user. It is the single
+// order-entry path shared by the REST and WebSocket APIs.
+func (s *Server) placeOrder(userID uint, isAdmin bool, req OrderRequest) (Order, *orderError) {
+	if err := s.checkOrder(userID, isAdmin, &req); err != nil {
+		return Order{}, err
+	}
+
+	// With a settlement delay orders wait as pending for settlementJob
+	status := orderStatusFilled
+	if s.settlementDelay > 0 {
+		status = orderStatusPending
+	}
+
+	order := Order{
+		UserID:        userID,
+		Symbol:        req.Symbol,
+		Side:          req.Side,
+		Quantity:      req.Quantity,
+		Price:         req.Price,
+		Timestamp:     time.Now(),
+		ClientOrderID: req.ClientOrderID,
+		Tag:           req.Tag,
+		Note:          req.Note,
+		Status:        status,
+		Version:       1,
+	}
+
+	// The transaction holds the write lock, so the checks repeated in it
+	// can't race a concurrent order claiming the same shares or client
+	// order id. Only an order that passes them is charged to the limiters.
+	var failure *orderError
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		number, err := nextOrderNumber(tx, userID)
+		if err != nil {
+			return err
+		}
+		if failure = s.checkPosition(tx, userID, req); failure != nil {
+			return errOrderRejected
+		}
+		if failure = checkClientOrderID(tx, userID, req); failure != nil {
+			return errOrderRejected
+		}
+		if failure = s.chargeOrderLimits(userID, isAdmin, req.Symbol); failure != nil {
+			return errOrderRejected
+		}
+		order.Number = number
+		return tx.Create(&order).Error
+	})
+	if failure != nil {
+		return Order{}, failure
+	}
+	if err != nil {
+		// The unique index catches a duplicate that raced the check above
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return Order{}, &orderError{Status: 409, Code: orderCodeDuplicateOrder, Message: "Duplicate client_order_id"}
+		}
+		return Order{}, &orderError{Status: 500, Code: orderCodeInternal, Message: "Failed to create order"}
+	}
+	s.positionsCache.invalidate(userID)
+
+	if order.Status == orderStatusFilled {
+		s.notifyFill(order)
+		s.notifyOrderFilled(order)
+	}
+	return order, nil
+}
+
+// checkOrder runs every check an order must pass before it is recorded,
+// normalizing req on the way. The rate limiters are only consulted here, so
+// previewOrder reports exactly what placeOrder would do without using them
+// up; placeOrder charges them once its own checks pass.
+func (s *Server) checkOrder(userID uint, isAdmin bool, req *OrderRequest) *orderError {
+	if err := s.validateOrder(req); err != nil {
+		return err
+	}
+	if err := s.checkEntitlement(userID, req.Symbol); err != nil {
+		return err
+	}
+	if err := s.checkPosition(s.db, userID, *req); err != nil {
+		return err
+	}
+	if err := s.checkAccountMode(userID); err != nil {
+		return err
+	}
+	if err := checkClientOrderID(s.db, userID, *req); err != nil {
+		return err
+	}
+
+	// Enforce the minimum account age and the cap on resting orders, then
+	// the rate limits; admins are exempt from all of them
+	if isAdmin {
+		return nil
+	}
+	if err := s.checkAccountAge(userID); err != nil {
+		return err
+	}
+	if err := s.checkOpenOrders(userID); err != nil {
+		return err
+	}
+	return s.peekOrderLimits(userID, req.Symbol)
+}
+
+// checkClientOrderID rejects a reused client order id, which is most likely
+// a retry of an order that already went through, rather than filling twice
+func checkClientOrderID(db *gorm.DB, userID uint, req OrderRequest) *orderError {
+	if req.ClientOrderID == nil {
+		return nil
+	}
+	var count int64
+	if err := db.Model(&Order{}).Where("user_id = ? AND client_order_id = ?", userID, *req.ClientOrderID).Count(&count).Error; err != nil {
+		return &orderError{Status: 500, Code: orderCodeInternal, Message: "Failed to create order"}
+	}
+	if count > 0 {
+		return &orderError{Status: 409, Code: orderCodeDuplicateOrder, Message: "Duplicate client_order_id"}
+	}
+	return nil
+}
+
+// symbolLimitKey is the user's bucket in the per-symbol order limiter
+func symbolLimitKey(userID uint, symbol string) string {
+	return strconv.FormatUint(uint64(userID), 10) + "|" + symbol
+}
+
+// peekOrderLimits reports whether the short-term cap per symbol, which keeps
+// one stock from being flooded, and the per-user daily cap would let another
+// order through, without counting one
+func (s *Server) peekOrderLimits(userID uint, symbol string) *orderError {
+	now := time.Now()
+	if s.symbolLimiter != nil {
+		if ok, resetAt := s.symbolLimiter.peek(symbolLimitKey(userID, symbol), now); !ok {
+			return &orderError{Status: 429, Code: orderCodeSymbolThrottled, Message: "Too many orders for " + symbol + ", slow down", RetryAt: resetAt}
+		}
+	}
+	if ok, resetAt := s.orderLimiter.peek(userID, now); !ok {
+		return &orderError{Status: 429, Code: orderCodeDailyLimit, Message: "Daily order limit reached", RetryAt: resetAt}
+	}
+	return nil
+}
+
+// chargeOrderLimits counts an order against the rate limits, refusing it if
+// either is used up. Both are checked before either is charged, so an order
+// one of them refuses doesn't use up the other. Admins are exempt.
+func (s *Server) chargeOrderLimits(userID uint, isAdmin bool, symbol string) *orderError {
+	if isAdmin {
+		return nil
+	}
+	if err := s.peekOrderLimits(userID, symbol); err != nil {
+		return err
+	}
+	now := time.Now()
+	if s.symbolLimiter != nil {
+		if ok, _, resetAt := s.symbolLimiter.allow(symbolLimitKey(userID, symbol), now); !ok {
+			return &orderError{Status: 429, Code: orderCodeSymbolThrottled, Message: "Too many orders for " + symbol + ", slow down", RetryAt: resetAt}
+		}
+	}
+	if ok, resetAt := s.orderLimiter.allow(userID, now); !ok {
+		return &orderError{Status: 429, Code: orderCodeDailyLimit, Message: "Daily order limit reached", RetryAt: resetAt}
+	}
+	return nil
+}
+
+// checkOpenOrders refuses an order that would take the user past
+// maxOpenOrders working orders. Without a settlement delay orders fill
+// straight away and never rest, so there is nothing to cap.
+func (s *Server) checkOpenOrders(userID uint) *orderError {
+	if s.maxOpenOrders == 0 || s.settlementDelay == 0 {
+		return nil
+	}
+	var open int64
+	if err := s.db.Model(&Order{}).Where("user_id = ? AND status IN ?", userID, workingOrderStatuses).Count(&open).Error; err != nil {
+		return &orderError{Status: 500, Code: orderCodeInternal, Message: "Failed to count open orders"}
+	}
+	if open >= int64(s.maxOpenOrders) {
+		return &orderError{Status: 429, Code: orderCodeOpenLimit, Message: fmt.Sprintf("At most %d open orders are allowed; wait for some to fill or cancel them", s.maxOpenOrders)}
+	}
+	return nil
+}
+
+// userOrders scopes an order query to the given user and, with ?symbol=
+// or ?tag=, to a single symbol or tag
+func (s *Server) userOrders(c *gin.Context, userID interface{}) *gorm.DB {
+	query := s.db.Where("user_id = ?", userID)
+	if symbol := strings.ToUpper(strings.TrimSpace(s.resolveSymbol(c.Query("symbol")))); symbol != "" {
+		query = query.Where("symbol = ?", symbol)
+	}
+	if tag := strings.TrimSpace(c.Query("tag")); tag != "" {
+		query = query.Where("tag = ?", tag)
+	}
+	return query
+}
+
+// getOpenOrders returns the authenticated user's working (open or pending)
+// orders, oldest first
+func (s *Server) getOpenOrders(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(401, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	orders := []Order{}
+	err := s.userOrders(c, userID).
+		Where("status IN ?", workingOrderStatuses).
+		Order("timestamp ASC").
+		Find(&orders).Error
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to fetch orders"})
+		return
+	}
+
+	c.JSON(200, orders)
+}
+
+// OrderCounts is how many orders a user has, cheap enough to poll to tell
+// when the order list needs fetching again
+type OrderCounts struct {
+	Total  int64 `json:"total"`
+	Open   int64 `json:"open"` // Open or pending
+	Filled int64 `json:"filled"`
+}
+
+// getOrderCounts counts the authenticated user's orders, taking the same
+// ?symbol= and ?archived= filters as getOrders
+func (s *Server) getOrderCounts(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(401, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	query := s.userOrders(c, userID).Model(&Order{})
+	if c.Query("archived") != "true" {
+		query = query.Where("archived = ?", false)
+	}
+
+	var rows []struct {
+		Status string
+		Count  int64
+	}
+	if err := query.Select("status, COUNT(*) AS count").Group("status").Scan(&rows).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to count orders"})
+		return
+	}
+
+	var counts OrderCounts
+	for _, row := range rows {
+		counts.Total += row.Count
+		switch row.Status {
+		case orderStatusOpen, orderStatusPending:
+			counts.Open += row.Count
+		case orderStatusFilled:
+			counts.Filled += row.Count
+		}
+	}
+	c.JSON(200, counts)
+}
+
+// orderCancelledMessage tells an order's owner over the WebSocket that it
+// was cancelled, possibly by an admin
+type orderCancelledMessage struct {
+	Type  string `json:"type"` // "order_cancelled"
+	Order Order  `json:"order"`
+}
+
+// CancelRequest names the order version the client is cancelling
+type CancelRequest struct {
+	Version *uint `json:"version"`
+}
+
+// cancelOrder cancels a working order. ownerID limits the lookup to one
+// user's orders; 0 matches any owner, for admins, and then actorID is
+// recorded in the audit log in the same transaction. If version is set the
+// order must still be at that version. The owner's WebSocket connections are
+// notified once the cancellation commits.
+func (s *Server) cancelOrder(orderID, ownerID, actorID uint, version *uint) (Order, *orderError) {
+	var order Order
+	var failure *orderError
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		query := tx.Where("id = ?", orderID)
+		if ownerID != 0 {
+			query = query.Where("user_id = ?", ownerID)
+		}
+		var found []Order
+		if err := query.Limit(1).Find(&found).Error; err != nil {
+			return err
+		}
+		if len(found) == 0 {
+			failure = &orderError{Status: 404, Code: orderCodeOrderNotFound, Message: "Order not found"}
+			return nil
+		}
+		order = found[0]
+
+		if version != nil && *version != order.Version {
+			failure = &orderError{Status: 409, Code: orderCodeVersionConflict, Message: fmt.Sprintf("Order has changed, current version is %d", order.Version)}
+			return nil
+		}
+		if checkTransition(order.Status, orderStatusCancelled) != nil {
+			failure = &orderError{Status: 409, Code: orderCodeOrderNotWorking, Message: "Order is already " + order.Status}
+			return nil
+		}
+
+		// Compare-and-swap on the version read above, so a concurrent change
+		// can't be overwritten
+		moved, err := transitionOrder(tx, &order, orderStatusCancelled)
+		if err != nil {
+			return err
+		}
+		if !moved {
+			failure = &orderError{Status: 409, Code: orderCodeVersionConflict, Message: "Order has changed, fetch it and retry"}
+			return nil
+		}
+
+		if ownerID == 0 {
+			return recordAudit(tx, actorID, "order.cancel", "order", order.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return Order{}, &orderError{Status: 500, Code: orderCodeInternal, Message: "Failed to cancel order"}
+	}
+	if failure != nil {
+		return Order{}, failure
+	}
+	s.positionsCache.invalidate(order.UserID)
+
+	s.notifyUser(order.UserID, orderCancelledMessage{Type: "order_cancelled", Order: order})
+	return order, nil
+}
+
+// body is the JSON error response for a rejected order or cancel
+func (e *orderError) body() gin.H {
+	body := gin.H{"error": e.Message, "code": e.Code}
+	if !e.RetryAt.IsZero() {
+		body["reset_at"] = e.RetryAt
+	}
+	return body
+}
+
+// orderIDParam parses the :id route parameter
+func orderIDParam(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil || id == 0 {
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// cancelOwnOrder cancels one of the authenticated user's working orders,
+// identified by its order number. The body must carry the version of the
+// order the client last saw.
+func (s *Server) cancelOwnOrder(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(401, gin.H{"error": "Unauthorized"})
+		return
+	}
+	number, ok := orderNumberParam(c)
+	if !ok {
+		c.JSON(400, gin.H{"error": "Invalid order number"})
+		return
+	}
+
+	var req CancelRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Version == nil {
+		c.JSON(400, gin.H{"error": "Request must include the order version"})
+		return
+	}
+
+	target, found, lookupErr := s.findOwnOrder(userID.(uint), number)
+	if lookupErr != nil {
+		c.JSON(500, gin.H{"error": "Failed to fetch order"})
+		return
+	}
+	if !found {
+		c.JSON(404, gin.H{"error": "Order not found", "code": orderCodeOrderNotFound})
+		return
+	}
+
+	order, err := s.cancelOrder(target.ID, userID.(uint), userID.(uint), req.Version)
+	if err != nil {
+		c.JSON(err.Status, err.body())
+		return
+	}
+	c.JSON(200, order)
+}
+
+// CancelAllRequest optionally limits a bulk cancel to one symbol
+type CancelAllRequest struct {
+	Symbol string `json:"symbol"`
+}
+
+// CancelAllResponse reports how many orders a bulk cancel cancelled
+type CancelAllResponse struct {
+	Cancelled int `json:"cancelled"`
+}
+
+// cancelAllOrders cancels every working order of the authenticated user,
+// or only those in the body's symbol, in one transaction. Orders that move
+// on (e.g. settle) while it runs are skipped rather than failing the rest.
+// Each cancellation is notified over the WebSocket once it commits.
+func (s *Server) cancelAllOrders(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(401, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	// The body is optional; without one every symbol is cancelled
+	var req CancelAllRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(400, gin.H{"error": "Invalid request"})
+		return
+	}
+	symbol := strings.ToUpper(strings.TrimSpace(s.resolveSymbol(req.Symbol)))
+	if symbol != "" {
+		if _, ok := s.lookupStock(symbol); !ok {
+			c.JSON(400, gin.H{"error": "Unknown symbol", "code": orderCodeUnknownSymbol})
+			return
+		}
+	}
+
+	var cancelled []Order
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		query := tx.Where("user_id = ? AND status IN ?", userID, workingOrderStatuses)
+		if symbol != "" {
+			query = query.Where("symbol = ?", symbol)
+		}
+		var working []Order
+		if err := query.Order("timestamp ASC").Find(&working).Error; err != nil {
+			return err
+		}
+
+		for i := range working {
+			moved, err := transitionOrder(tx, &working[i], orderStatusCancelled)
+			if err != nil {
+				return err
+			}
+			if moved {
+				cancelled = append(cancelled, working[i])
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to cancel orders", "code": orderCodeInternal})
+		return
+	}
+
+	if len(cancelled) > 0 {
+		s.positionsCache.invalidate(userID.(uint))
+	}
+	for _, order := range cancelled {
+		s.notifyUser(order.UserID, orderCancelledMessage{Type: "order_cancelled", Order: order})
+	}
+	c.JSON(200, CancelAllResponse{Cancelled: len(cancelled)})
+}
+
+// forceCancelOrder lets an admin cancel any user's working order
+func (s *Server) forceCancelOrder(c *gin.Context) {
+	adminID, _ := c.Get("user_id")
+	orderID, ok := orderIDParam(c)
+	if !ok {
+		c.JSON(400, gin.H{"error": "Invalid order id"})
+		return
+	}
+
+	// The version is optional here: during an incident the order should go
+	// whatever state it is in
+	var req CancelRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(400, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	order, err := s.cancelOrder(orderID, 0, adminID.(uint), req.Version)
+	if err != nil {
+		c.JSON(err.Status, err.body())
+		return
+	}
+	log.Printf("Order %d of user %d force-cancelled by admin %v", order.ID, order.UserID, adminID)
+	c.JSON(200, order)
+}
+
+// OrderPreview is the estimated cost of an order that hasn't been placed
+type OrderPreview struct {
+	Symbol       string  `json:"symbol"`
+	Side         string  `json:"side"`
+	Quantity     float64 `json:"quantity"`
+	Price        float64 `json:"price"`
+	Notional     float64 `json:"notional"`
+	Fee          float64 `json:"fee"`
+	Total        float64 `json:"total"` // Cash paid for a buy or cover, received for a sell or short
+	WouldSucceed bool    `json:"would_succeed"`
+	Reason       string  `json:"reason,omitempty"` // Why the order would be rejected
+	ReasonCode   string  `json:"reason_code,omitempty"`
+}
+
+// orderFee returns the trading fee for an order's notional value. No fees
+// are charged yet, but preview and placement share this single definition.
+func orderFee(notional float64) float64 {
+	return 0
+}
+
+// previewOrder runs placeOrder's checks for the authenticated user, without
+// using up any rate limit, and returns the order's estimated cost without
+// persisting anything
+func (s *Server) previewOrder(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(401, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var req OrderRequest
+	if !bindStrictJSON(c, &req) {
+		return
+	}
+
+	// Maintenance refuses orders before they reach placeOrder
+	preview := OrderPreview{WouldSucceed: true}
+	role, _ := c.Get("role")
+	if s.maintenance.Load() {
+		preview.WouldSucceed = false
+		preview.Reason, preview.ReasonCode = maintenanceMessage, orderCodeMaintenance
+	} else if err := s.checkOrder(userID.(uint), role == roleAdmin, &req); err != nil {
+		preview.WouldSucceed = false
+		preview.Reason, preview.ReasonCode = err.Message, err.Code
+	}
+
+	preview.Symbol = req.Symbol
+	preview.Side = req.Side
+	preview.Quantity = req.Quantity
+	preview.Price = req.Price
+	preview.Notional = orderNotional(req.Symbol, req.Quantity, req.Price)
+	preview.Fee = roundMoney(orderFee(preview.Notional), decimalsFor(req.Symbol), feeRounding)
+	if !sideBuys(req.Side) {
+		preview.Total = preview.Notional - preview.Fee
+	} else {
+		preview.Total = preview.Notional + preview.Fee
+	}
+
+	c.JSON(200, preview)
+}
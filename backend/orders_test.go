@@ -0,0 +1,625 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// placePendingOrder places a buy that stays pending under the settlement
+// delay, so it can still be cancelled
+func placePendingOrder(t *testing.T, s *Server, userID uint) Order {
+	t.Helper()
+	order, err := s.placeOrder(userID, false, OrderRequest{Symbol: "AAPL", Side: sideBuy, Quantity: 1})
+	if err != nil {
+		t.Fatalf("placeOrder: %s", err.Message)
+	}
+	if order.Status != orderStatusPending {
+		t.Fatalf("status = %s, want %s", order.Status, orderStatusPending)
+	}
+	return order
+}
+
+func TestConcurrentCancelsAtSameVersion(t *testing.T) {
+	s := newTestServer(t, map[string]string{"SETTLEMENT_DELAY": "1h"})
+	user := createTestUser(t, s, "trader", roleUser)
+	order := placePendingOrder(t, s, user.ID)
+
+	const cancellers = 8
+	errs := make([]*orderError, cancellers)
+	var wg sync.WaitGroup
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			version := order.Version
+			_, errs[i] = s.cancelOrder(order.ID, user.ID, user.ID, &version)
+		}(i)
+	}
+	wg.Wait()
+
+	won := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			won++
+		case err.Status != 409:
+			t.Errorf("cancel failed with %d %s: %s", err.Status, err.Code, err.Message)
+		}
+	}
+	if won != 1 {
+		t.Fatalf("%d cancels won, want 1", won)
+	}
+
+	var stored Order
+	if err := s.db.First(&stored, order.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status != orderStatusCancelled || stored.Version != order.Version+1 {
+		t.Fatalf("stored order is %s at version %d, want cancelled at %d", stored.Status, stored.Version, order.Version+1)
+	}
+}
+
+func TestCancelRacingSettlement(t *testing.T) {
+	s := newTestServer(t, map[string]string{"SETTLEMENT_DELAY": "1h"})
+	user := createTestUser(t, s, "trader", roleUser)
+	order := placePendingOrder(t, s, user.ID)
+
+	var cancelErr *orderError
+	var settled int
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		version := order.Version
+		_, cancelErr = s.cancelOrder(order.ID, user.ID, user.ID, &version)
+	}()
+	go func() {
+		defer wg.Done()
+		var err error
+		if settled, err = s.settleOrders(time.Now().Add(2 * time.Hour)); err != nil {
+			t.Errorf("settleOrders: %v", err)
+		}
+	}()
+	wg.Wait()
+
+	var stored Order
+	if err := s.db.First(&stored, order.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	switch {
+	case cancelErr == nil && settled == 0:
+		if stored.Status != orderStatusCancelled {
+			t.Fatalf("cancel won but order is %s", stored.Status)
+		}
+	case cancelErr != nil && settled == 1:
+		if cancelErr.Status != 409 || stored.Status != orderStatusFilled {
+			t.Fatalf("settlement won but cancel got %d and order is %s", cancelErr.Status, stored.Status)
+		}
+	default:
+		t.Fatalf("cancel = %v, settled = %d: want exactly one to win", cancelErr, settled)
+	}
+	if stored.Version != order.Version+1 {
+		t.Fatalf("version = %d, want %d", stored.Version, order.Version+1)
+	}
+}
+
+func TestCancelWithStaleVersion(t *testing.T) {
+	s, r := newTestRouter(t, map[string]string{"SETTLEMENT_DELAY": "1h"})
+	user := createTestUser(t, s, "trader", roleUser)
+	_, token := createTestSession(t, s, user)
+	order := placePendingOrder(t, s, user.ID)
+
+	path := "/api/orders/" + strconv.FormatUint(uint64(order.Number), 10) + "/cancel"
+	if w := doRequest(r, "POST", path, token, `{}`); w.Code != 400 {
+		t.Fatalf("cancel without version: status %d, want 400", w.Code)
+	}
+	if w := doRequest(r, "POST", path, token, `{"version":99}`); w.Code != 409 || !jsonHasCode(w.Body.Bytes(), orderCodeVersionConflict) {
+		t.Fatalf("cancel at stale version: status %d: %s", w.Code, w.Body)
+	}
+	if w := doRequest(r, "POST", path, token, `{"version":1}`); w.Code != 200 {
+		t.Fatalf("cancel at current version: status %d: %s", w.Code, w.Body)
+	}
+
+	// The cancelled order is now at version 2, and final
+	if w := doRequest(r, "POST", path, token, `{"version":2}`); w.Code != 409 || !jsonHasCode(w.Body.Bytes(), orderCodeOrderNotWorking) {
+		t.Fatalf("cancelling twice: status %d: %s", w.Code, w.Body)
+	}
+}
+
+// workingCount returns how many working orders the user has in symbol, or
+// in every symbol if it is empty
+func workingCount(t *testing.T, s *Server, userID uint, symbol string) int64 {
+	t.Helper()
+	query := s.db.Model(&Order{}).Where("user_id = ? AND status IN ?", userID, workingOrderStatuses)
+	if symbol != "" {
+		query = query.Where("symbol = ?", symbol)
+	}
+	var count int64
+	if err := query.Count(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func TestCancelAllOrders(t *testing.T) {
+	s, r := newTestRouter(t, map[string]string{"SETTLEMENT_DELAY": "1h", "SYMBOL_ALIASES": "APPLE:AAPL"})
+	user := createTestUser(t, s, "trader", roleUser)
+	other := createTestUser(t, s, "other", roleUser)
+	_, token := createTestSession(t, s, user)
+	for _, symbol := range []string{"AAPL", "AAPL", "TSLA"} {
+		if _, err := s.placeOrder(user.ID, false, OrderRequest{Symbol: symbol, Side: sideBuy, Quantity: 1}); err != nil {
+			t.Fatalf("placeOrder: %s", err.Message)
+		}
+	}
+	placePendingOrder(t, s, other.ID)
+
+	// An unknown symbol is refused rather than cancelling nothing
+	if w := doRequest(r, "POST", "/api/orders/cancel-all", token, `{"symbol":"BANANA"}`); w.Code != 400 || !jsonHasCode(w.Body.Bytes(), orderCodeUnknownSymbol) {
+		t.Fatalf("unknown symbol: status %d: %s", w.Code, w.Body)
+	}
+
+	// A symbol, here given by alias, limits it to that symbol
+	w := doRequest(r, "POST", "/api/orders/cancel-all", token, `{"symbol":"apple"}`)
+	if w.Code != 200 || strings.TrimSpace(w.Body.String()) != `{"cancelled":2}` {
+		t.Fatalf("cancel AAPL: status %d: %s", w.Code, w.Body)
+	}
+	if n := workingCount(t, s, user.ID, "TSLA"); n != 1 {
+		t.Fatalf("%d TSLA orders working, want 1", n)
+	}
+
+	// Without a body it cancels the rest, leaving other users' orders
+	w = doRequest(r, "POST", "/api/orders/cancel-all", token, "")
+	if w.Code != 200 || strings.TrimSpace(w.Body.String()) != `{"cancelled":1}` {
+		t.Fatalf("cancel all: status %d: %s", w.Code, w.Body)
+	}
+	if n := workingCount(t, s, user.ID, ""); n != 0 {
+		t.Fatalf("%d orders still working", n)
+	}
+	if n := workingCount(t, s, other.ID, ""); n != 1 {
+		t.Fatalf("other user has %d working orders, want 1", n)
+	}
+
+	// Nothing left to cancel is not an error
+	if w := doRequest(r, "POST", "/api/orders/cancel-all", token, `{}`); w.Code != 200 || strings.TrimSpace(w.Body.String()) != `{"cancelled":0}` {
+		t.Fatalf("cancel with nothing working: status %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestRefusedOrdersDontUseUpLimits(t *testing.T) {
+	s := newTestServer(t, map[string]string{"SHORT_SELLING": "true", "MAX_ORDERS_PER_DAY": "2"})
+	user := createTestUser(t, s, "trader", roleUser)
+	createFilledOrder(t, s, user.ID, "AAPL", sideBuy, 10, 100)
+
+	// Only one of the racing sells can have the shares. The others may pass
+	// the first position check and be refused in the transaction, and must
+	// not be counted when they are.
+	const sellers = 8
+	var wg sync.WaitGroup
+	for i := 0; i < sellers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.placeOrder(user.ID, false, OrderRequest{Symbol: "AAPL", Side: sideSell, Quantity: 10})
+		}()
+	}
+	wg.Wait()
+
+	if _, err := s.placeOrder(user.ID, false, OrderRequest{Symbol: "AAPL", Side: sideBuy, Quantity: 1}); err != nil {
+		t.Fatalf("second order of the day: %s", err.Message)
+	}
+	if _, err := s.placeOrder(user.ID, false, OrderRequest{Symbol: "AAPL", Side: sideBuy, Quantity: 1}); err == nil || err.Code != orderCodeDailyLimit {
+		t.Fatalf("third order of the day = %v, want %s", err, orderCodeDailyLimit)
+	}
+}
+
+func TestDuplicateClientOrderIDDoesntUseUpLimits(t *testing.T) {
+	s := newTestServer(t, map[string]string{"MAX_ORDERS_PER_DAY": "2"})
+	user := createTestUser(t, s, "trader", roleUser)
+	id := "retry-1"
+	req := OrderRequest{Symbol: "AAPL", Side: sideBuy, Quantity: 1, ClientOrderID: &id}
+
+	if _, err := s.placeOrder(user.ID, false, req); err != nil {
+		t.Fatalf("first order: %s", err.Message)
+	}
+	if _, err := s.placeOrder(user.ID, false, req); err == nil || err.Code != orderCodeDuplicateOrder {
+		t.Fatalf("retry = %v, want %s", err, orderCodeDuplicateOrder)
+	}
+	if _, err := s.placeOrder(user.ID, false, OrderRequest{Symbol: "AAPL", Side: sideBuy, Quantity: 1}); err != nil {
+		t.Fatalf("second order of the day: %s", err.Message)
+	}
+}
+
+func TestOrderNumbersArePerUser(t *testing.T) {
+	s, r := newTestRouter(t, map[string]string{"SETTLEMENT_DELAY": "1h"})
+	alice := createTestUser(t, s, "alice", roleUser)
+	bob := createTestUser(t, s, "bob", roleUser)
+	_, bobToken := createTestSession(t, s, bob)
+	for i := 0; i < 3; i++ {
+		placePendingOrder(t, s, alice.ID)
+	}
+	own := placePendingOrder(t, s, bob.ID)
+	if own.Number != 1 {
+		t.Fatalf("bob's first order is number %d, want 1", own.Number)
+	}
+
+	// Bob's number 1 is his own order, not alice's
+	w := doRequest(r, "GET", "/api/orders/1", bobToken, "")
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(w.Body.Bytes(), &fields); w.Code != 200 || err != nil {
+		t.Fatalf("own order: status %d: %s", w.Code, w.Body)
+	}
+	if _, ok := fields["id"]; ok {
+		t.Fatalf("order exposes its internal id: %s", w.Body)
+	}
+	if string(fields["user_id"]) != strconv.FormatUint(uint64(bob.ID), 10) {
+		t.Fatalf("order 1 belongs to user %s, want %d", fields["user_id"], bob.ID)
+	}
+
+	// Guessing alice's other numbers finds nothing, to fetch or to cancel
+	for _, number := range []string{"2", "3", "4"} {
+		if w := doRequest(r, "GET", "/api/orders/"+number, bobToken, ""); w.Code != 404 || !jsonHasCode(w.Body.Bytes(), orderCodeOrderNotFound) {
+			t.Errorf("GET order %s: status %d: %s", number, w.Code, w.Body)
+		}
+		if w := doRequest(r, "POST", "/api/orders/"+number+"/cancel", bobToken, `{"version":1}`); w.Code != 404 {
+			t.Errorf("cancel order %s: status %d: %s", number, w.Code, w.Body)
+		}
+	}
+	if n := workingCount(t, s, alice.ID, ""); n != 3 {
+		t.Fatalf("alice has %d working orders, want 3", n)
+	}
+}
+
+func TestFractionalQuantities(t *testing.T) {
+	s, r := newTestRouter(t, map[string]string{"SHORT_SELLING": "true"})
+	user := createTestUser(t, s, "trader", roleUser)
+	_, token := createTestSession(t, s, user)
+
+	if _, err := s.placeOrder(user.ID, false, OrderRequest{Symbol: "TCS", Side: sideBuy, Quantity: 0.5}); err != nil {
+		t.Fatalf("buying 0.5 TCS: %s", err.Message)
+	}
+	if _, err := s.placeOrder(user.ID, false, OrderRequest{Symbol: "TCS", Side: sideSell, Quantity: 0.125}); err != nil {
+		t.Fatalf("selling 0.125 TCS: %s", err.Message)
+	}
+	if got := netPosition(t, s, user.ID, "TCS"); got != 0.375 {
+		t.Fatalf("net position = %g, want 0.375", got)
+	}
+
+	// Only the fraction held can be sold outright
+	if _, err := s.placeOrder(user.ID, false, OrderRequest{Symbol: "TCS", Side: sideSell, Quantity: 0.4}); err == nil || err.Code != orderCodeNoPosition {
+		t.Fatalf("overselling = %v, want %s", err, orderCodeNoPosition)
+	}
+
+	// Volumes keep their fractions
+	w := doRequest(r, "GET", "/api/me/summary", token, "")
+	var summary TradeSummary
+	if err := json.Unmarshal(w.Body.Bytes(), &summary); w.Code != 200 || err != nil {
+		t.Fatalf("summary: status %d: %s", w.Code, w.Body)
+	}
+	if summary.BuyVolume != 0.5 || summary.SellVolume != 0.125 {
+		t.Errorf("volumes = %g bought, %g sold; want 0.5 and 0.125", summary.BuyVolume, summary.SellVolume)
+	}
+}
+
+func TestQuantityIncrement(t *testing.T) {
+	tests := []struct {
+		increment string
+		quantity  float64
+		ok        bool
+	}{
+		{"", 0.001, true},
+		{"", 0.0005, false},
+		{"", 1.2345, false},
+		{"0.5", 1.5, true},
+		{"0.5", 0.25, false},
+		{"1", 2, true},
+		{"1", 0.5, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.increment+"/"+strconv.FormatFloat(tt.quantity, 'g', -1, 64), func(t *testing.T) {
+			s := newTestServer(t, map[string]string{"QUANTITY_INCREMENT": tt.increment})
+			user := createTestUser(t, s, "trader", roleUser)
+			_, err := s.placeOrder(user.ID, false, OrderRequest{Symbol: "AAPL", Side: sideBuy, Quantity: tt.quantity})
+			if tt.ok && err != nil {
+				t.Fatalf("rejected: %s", err.Message)
+			}
+			if !tt.ok && (err == nil || err.Status != 400 || err.Code != orderCodeInvalidQuantity) {
+				t.Fatalf("error = %v, want 400 %s", err, orderCodeInvalidQuantity)
+			}
+		})
+	}
+}
+
+func TestClientOrderID(t *testing.T) {
+	s, r := newTestRouter(t, nil)
+	user := createTestUser(t, s, "trader", roleUser)
+	_, token := createTestSession(t, s, user)
+	other := createTestUser(t, s, "other", roleUser)
+	_, otherToken := createTestSession(t, s, other)
+	order := `{"symbol":"AAPL","side":"buy","quantity":1,"client_order_id":"batch-7:leg.1"}`
+
+	// The id is echoed on the created order and when it's fetched again
+	w := doRequest(r, "POST", "/api/orders", token, order)
+	var created Order
+	if err := json.Unmarshal(w.Body.Bytes(), &created); w.Code != 201 || err != nil {
+		t.Fatalf("placing: status %d: %s", w.Code, w.Body)
+	}
+	if created.ClientOrderID == nil || *created.ClientOrderID != "batch-7:leg.1" {
+		t.Fatalf("created client_order_id = %v", created.ClientOrderID)
+	}
+	if got := fetchOrder(t, r, token, created.Number); got.ClientOrderID == nil || *got.ClientOrderID != "batch-7:leg.1" {
+		t.Errorf("fetched client_order_id = %v", got.ClientOrderID)
+	}
+
+	// It is unique per user, not across users
+	if w := doRequest(r, "POST", "/api/orders", token, order); w.Code != 409 || !jsonHasCode(w.Body.Bytes(), orderCodeDuplicateOrder) {
+		t.Errorf("reused id: status %d: %s", w.Code, w.Body)
+	}
+	if w := doRequest(r, "POST", "/api/orders", otherToken, order); w.Code != 201 {
+		t.Errorf("another user's id: status %d: %s", w.Code, w.Body)
+	}
+
+	// Orders without one don't collide
+	for i := 0; i < 2; i++ {
+		if w := doRequest(r, "POST", "/api/orders", token, `{"symbol":"AAPL","side":"buy","quantity":1}`); w.Code != 201 {
+			t.Fatalf("order without an id: status %d: %s", w.Code, w.Body)
+		}
+	}
+
+	for _, id := range []string{`""`, `"has space"`, `"` + strings.Repeat("x", maxClientOrderIDLength+1) + `"`} {
+		body := `{"symbol":"AAPL","side":"buy","quantity":1,"client_order_id":` + id + `}`
+		if w := doRequest(r, "POST", "/api/orders", token, body); w.Code != 400 || !jsonHasCode(w.Body.Bytes(), orderCodeInvalidClientID) {
+			t.Errorf("client_order_id %s: status %d: %s", id, w.Code, w.Body)
+		}
+	}
+}
+
+func TestClientOrderIDOverWebSocket(t *testing.T) {
+	s := newTestServer(t, nil)
+	user := createTestUser(t, s, "trader", roleUser)
+	_, token := createTestSession(t, s, user)
+	conn := dialTestWebSocket(t, newTestWebSocketServer(t, s), token)
+	id := "ws-1"
+
+	// readType reads messages until one of the given type arrives
+	readType := func(want string) []byte {
+		t.Helper()
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				t.Fatalf("waiting for %s: %v", want, err)
+			}
+			var msg struct {
+				Type string `json:"type"`
+			}
+			if json.Unmarshal(data, &msg) == nil && msg.Type == want {
+				return data
+			}
+		}
+	}
+
+	// The ack carries the id, and so does the rejection when it's reused
+	for _, want := range []string{"order_ack", "error"} {
+		if err := conn.WriteJSON(clientMessage{Action: "order", Order: &OrderRequest{Symbol: "AAPL", Side: sideBuy, Quantity: 1, ClientOrderID: &id}}); err != nil {
+			t.Fatal(err)
+		}
+		var reply struct {
+			Code          string  `json:"code"`
+			ClientOrderID *string `json:"client_order_id"`
+			Order         Order   `json:"order"`
+		}
+		json.Unmarshal(readType(want), &reply)
+		echoed := reply.ClientOrderID
+		if want == "order_ack" {
+			echoed = reply.Order.ClientOrderID
+		} else if reply.Code != orderCodeDuplicateOrder {
+			t.Errorf("rejection code = %s, want %s", reply.Code, orderCodeDuplicateOrder)
+		}
+		if echoed == nil || *echoed != id {
+			t.Errorf("%s client_order_id = %v, want %s", want, echoed, id)
+		}
+	}
+}
+
+func TestQuantityDecimals(t *testing.T) {
+	env := map[string]string{"QUANTITY_DECIMALS": "TCS:0,INFY:4", "QUANTITY_INCREMENT": "0.0001"}
+	tests := []struct {
+		mode     string
+		symbol   string
+		quantity float64
+		want     float64 // 0 when the order is rejected
+	}{
+		{"reject", "TCS", 1, 1},
+		{"reject", "TCS", 0.5, 0},
+		{"reject", "AAPL", 0.001, 0.001},
+		{"reject", "AAPL", 0.0001, 0},
+		{"reject", "AAPL", 1.2345, 0},
+		{"reject", "INFY", 0.0001, 0.0001},
+		{"reject", "INFY", 1.2345, 1.2345},
+		{"reject", "INFY", 1.23456, 0},
+		{"round", "TCS", 2.6, 3},
+		{"round", "TCS", 0.4, 0},
+		{"round", "AAPL", 1.0004, 1},
+		{"round", "AAPL", 1.2346, 1.235},
+		{"round", "INFY", 0.00004, 0},
+	}
+	for _, tt := range tests {
+		name := fmt.Sprintf("%s/%s/%g", tt.mode, tt.symbol, tt.quantity)
+		t.Run(name, func(t *testing.T) {
+			env["QUANTITY_PRECISION_MODE"] = tt.mode
+			s := newTestServer(t, env)
+			user := createTestUser(t, s, "trader", roleUser)
+			order, err := s.placeOrder(user.ID, false, OrderRequest{Symbol: tt.symbol, Side: sideBuy, Quantity: tt.quantity})
+			if tt.want == 0 {
+				if err == nil || err.Code != orderCodeInvalidQuantity {
+					t.Fatalf("error = %v, want %s", err, orderCodeInvalidQuantity)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("rejected: %s", err.Message)
+			}
+			if order.Quantity != tt.want {
+				t.Fatalf("quantity = %g, want %g", order.Quantity, tt.want)
+			}
+		})
+	}
+}
+
+func TestFractionalPositionsStayExact(t *testing.T) {
+	s := newTestServer(t, map[string]string{"SHORT_SELLING": "true"})
+	user := createTestUser(t, s, "trader", roleUser)
+
+	// 0.1 + 0.2 isn't 0.3 in floating point, but positions must net to zero
+	for _, quantity := range []float64{0.1, 0.2} {
+		if _, err := s.placeOrder(user.ID, false, OrderRequest{Symbol: "AAPL", Side: sideBuy, Quantity: quantity}); err != nil {
+			t.Fatalf("buying %g: %s", quantity, err.Message)
+		}
+	}
+	if _, err := s.placeOrder(user.ID, false, OrderRequest{Symbol: "AAPL", Side: sideSell, Quantity: 0.3}); err != nil {
+		t.Fatalf("selling all 0.3: %s", err.Message)
+	}
+	if got := netPosition(t, s, user.ID, "AAPL"); got != 0 {
+		t.Errorf("net position = %v, want 0", got)
+	}
+}
+
+func TestQuantityDecimalsConfig(t *testing.T) {
+	for key, value := range map[string]string{
+		"QUANTITY_DECIMALS":       "TCS:9",
+		"QUANTITY_PRECISION_MODE": "truncate",
+	} {
+		t.Run(key, func(t *testing.T) {
+			t.Setenv(key, value)
+			if _, err := loadConfig(); err == nil {
+				t.Fatalf("%s=%s accepted", key, value)
+			}
+		})
+	}
+}
+
+// fetchOrderCounts returns GET /api/orders/count with query
+func fetchOrderCounts(t *testing.T, h http.Handler, token, query string) OrderCounts {
+	t.Helper()
+	w := doRequest(h, "GET", "/api/orders/count"+query, token, "")
+	var counts OrderCounts
+	if err := json.Unmarshal(w.Body.Bytes(), &counts); w.Code != 200 || err != nil {
+		t.Fatalf("counts%s: status %d: %s", query, w.Code, w.Body)
+	}
+	return counts
+}
+
+func TestOrderCounts(t *testing.T) {
+	s, r := newTestRouter(t, map[string]string{"SETTLEMENT_DELAY": "1h"})
+	user := createTestUser(t, s, "trader", roleUser)
+	_, token := createTestSession(t, s, user)
+	_, otherToken := createTestSession(t, s, createTestUser(t, s, "other", roleUser))
+
+	if got := fetchOrderCounts(t, r, token, ""); got != (OrderCounts{}) {
+		t.Fatalf("before any orders: %+v", got)
+	}
+
+	archived := createFilledOrder(t, s, user.ID, "AAPL", sideBuy, 1, 175.5)
+	createFilledOrder(t, s, user.ID, "AAPL", sideBuy, 1, 175.5)
+	createFilledOrder(t, s, user.ID, "TSLA", sideBuy, 1, 245.3)
+	placePendingOrder(t, s, user.ID)
+	cancelled := placePendingOrder(t, s, user.ID)
+	if _, err := s.cancelOrder(cancelled.ID, user.ID, user.ID, nil); err != nil {
+		t.Fatalf("cancel: %s", err.Message)
+	}
+
+	if got, want := fetchOrderCounts(t, r, token, ""), (OrderCounts{Total: 5, Open: 1, Filled: 3}); got != want {
+		t.Errorf("after placing: %+v, want %+v", got, want)
+	}
+	if got, want := fetchOrderCounts(t, r, token, "?symbol=aapl"), (OrderCounts{Total: 4, Open: 1, Filled: 2}); got != want {
+		t.Errorf("AAPL: %+v, want %+v", got, want)
+	}
+	if got := fetchOrderCounts(t, r, otherToken, ""); got != (OrderCounts{}) {
+		t.Errorf("another user: %+v, want none", got)
+	}
+
+	// Archived orders are only counted when asked for, like in getOrders
+	s.db.Model(&archived).Update("archived", true)
+	if got, want := fetchOrderCounts(t, r, token, ""), (OrderCounts{Total: 4, Open: 1, Filled: 2}); got != want {
+		t.Errorf("without archived: %+v, want %+v", got, want)
+	}
+	if got, want := fetchOrderCounts(t, r, token, "?archived=true"), (OrderCounts{Total: 5, Open: 1, Filled: 3}); got != want {
+		t.Errorf("with archived: %+v, want %+v", got, want)
+	}
+
+	// The total matches the list the count stands in for
+	for _, query := range []string{"", "?symbol=AAPL", "?archived=true"} {
+		w := doRequest(r, "GET", "/api/orders"+query, token, "")
+		var orders []Order
+		if err := json.Unmarshal(w.Body.Bytes(), &orders); w.Code != 200 || err != nil {
+			t.Fatalf("orders%s: status %d: %s", query, w.Code, w.Body)
+		}
+		if got := fetchOrderCounts(t, r, token, query); got.Total != int64(len(orders)) {
+			t.Errorf("count%s total %d, but the list has %d", query, got.Total, len(orders))
+		}
+	}
+}
+
+func TestOrderTagsAndNotes(t *testing.T) {
+	s, r := newTestRouter(t, nil)
+	user := createTestUser(t, s, "trader", roleUser)
+	_, token := createTestSession(t, s, user)
+
+	place := func(body string) *httptest.ResponseRecorder {
+		return doRequest(r, "POST", "/api/orders", token, body)
+	}
+	w := place(`{"symbol":"AAPL","side":"buy","quantity":1,"tag":" momentum ","note":"Breakout above resistance"}`)
+	var order Order
+	if err := json.Unmarshal(w.Body.Bytes(), &order); w.Code != 201 || err != nil {
+		t.Fatalf("tagged order: status %d: %s", w.Code, w.Body)
+	}
+	if order.Tag != "momentum" || order.Note != "Breakout above resistance" {
+		t.Errorf("tag %q, note %q", order.Tag, order.Note)
+	}
+	if got := fetchOrder(t, r, token, order.Number); got.Tag != "momentum" || got.Note != "Breakout above resistance" {
+		t.Errorf("stored tag %q, note %q", got.Tag, got.Note)
+	}
+	place(`{"symbol":"TSLA","side":"buy","quantity":1,"tag":"momentum"}`)
+	place(`{"symbol":"AAPL","side":"buy","quantity":1,"tag":"hedge"}`)
+	place(`{"symbol":"AAPL","side":"buy","quantity":1}`)
+
+	for query, want := range map[string]int{"": 4, "?tag=momentum": 2, "?tag=hedge": 1, "?tag=momentum&symbol=AAPL": 1, "?tag=none": 0} {
+		w := doRequest(r, "GET", "/api/orders"+query, token, "")
+		var orders []Order
+		if err := json.Unmarshal(w.Body.Bytes(), &orders); w.Code != 200 || err != nil {
+			t.Fatalf("orders%s: status %d: %s", query, w.Code, w.Body)
+		}
+		if len(orders) != want {
+			t.Errorf("orders%s returned %d, want %d", query, len(orders), want)
+		}
+		for _, o := range orders {
+			if strings.Contains(query, "tag=momentum") && o.Tag != "momentum" {
+				t.Errorf("orders%s included tag %q", query, o.Tag)
+			}
+		}
+	}
+
+	// Lengths are counted in characters, not bytes
+	limits := []struct {
+		body   string
+		status int
+	}{
+		{`{"symbol":"AAPL","side":"buy","quantity":1,"tag":"` + strings.Repeat("é", maxOrderTagLength) + `"}`, 201},
+		{`{"symbol":"AAPL","side":"buy","quantity":1,"tag":"` + strings.Repeat("é", maxOrderTagLength+1) + `"}`, 400},
+		{`{"symbol":"AAPL","side":"buy","quantity":1,"tag":"a\tb"}`, 400},
+		{`{"symbol":"AAPL","side":"buy","quantity":1,"note":"` + strings.Repeat("ü", maxOrderNoteLength) + `"}`, 201},
+		{`{"symbol":"AAPL","side":"buy","quantity":1,"note":"` + strings.Repeat("ü", maxOrderNoteLength+1) + `"}`, 400},
+	}
+	for i, tt := range limits {
+		if w := place(tt.body); w.Code != tt.status {
+			t.Errorf("case %d: status %d, want %d: %s", i, w.Code, tt.status, w.Body)
+		}
+	}
+}
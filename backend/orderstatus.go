@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+
+	"gorm.io/gorm"
+)
+
+// orderTransitions lists the statuses an order in each status may move to.
+// Filled and cancelled orders are final. A new status must be added here
+// before any code can move orders into or out of it.
+var orderTransitions = map[string][]string{
+	orderStatusOpen:      {orderStatusFilled, orderStatusCancelled},
+	orderStatusPending:   {orderStatusFilled, orderStatusCancelled},
+	orderStatusFilled:    nil,
+	orderStatusCancelled: nil,
+}
+
+// checkTransition reports whether an order may move from one status to
+// another, returning an error describing the illegal move if not
+func checkTransition(from, to string) error {
+	next, ok := orderTransitions[from]
+	if !ok {
+		return fmt.Errorf("unknown order status %q", from)
+	}
+	if !slices.Contains(next, to) {
+		return fmt.Errorf("order can't move from %s to %s", from, to)
+	}
+	return nil
+}
+
+// transitionOrder is the only way an existing order's status changes. It
+// checks the move is legal and applies it in tx with a compare-and-swap on
+// the order's version and status, bumping the version. It reports false,
+// leaving order untouched, if the order changed since it was read.
+func transitionOrder(tx *gorm.DB, order *Order, to string) (bool, error) {
+	if err := checkTransition(order.Status, to); err != nil {
+		return false, err
+	}
+
+	res := tx.Model(&Order{}).
+		Where("id = ? AND version = ? AND status = ?", order.ID, order.Version, order.Status).
+		Updates(map[string]interface{}{
+			"status":  to,
+			"version": gorm.Expr("version + 1"),
+		})
+	if res.Error != nil {
+		return false, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return false, nil
+	}
+
+	order.Status = to
+	order.Version++
+	return true, nil
+}
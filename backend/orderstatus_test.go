@@ -0,0 +1,78 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+var allOrderStatuses = []string{orderStatusOpen, orderStatusPending, orderStatusFilled, orderStatusCancelled}
+
+func TestOrderTransitions(t *testing.T) {
+	legal := map[[2]string]bool{
+		{orderStatusOpen, orderStatusFilled}:       true,
+		{orderStatusOpen, orderStatusCancelled}:    true,
+		{orderStatusPending, orderStatusFilled}:    true,
+		{orderStatusPending, orderStatusCancelled}: true,
+	}
+
+	// Every pair of statuses, including staying put, is either listed as
+	// legal above or refused
+	for _, from := range allOrderStatuses {
+		for _, to := range allOrderStatuses {
+			err := checkTransition(from, to)
+			if want := legal[[2]string{from, to}]; want && err != nil {
+				t.Errorf("%s -> %s refused: %v", from, to, err)
+			} else if !want && err == nil {
+				t.Errorf("%s -> %s allowed", from, to)
+			}
+		}
+	}
+
+	if err := checkTransition("expired", orderStatusCancelled); err == nil {
+		t.Error("transition from an unknown status allowed")
+	}
+	if err := checkTransition(orderStatusOpen, "expired"); err == nil {
+		t.Error("transition to an unknown status allowed")
+	}
+}
+
+func TestTransitionOrder(t *testing.T) {
+	s := newTestServer(t, nil)
+	user := createTestUser(t, s, "trader", roleUser)
+	order := Order{UserID: user.ID, Number: 1, Symbol: "AAPL", Side: sideBuy, Quantity: 1, Price: 100, Timestamp: time.Now(), Status: orderStatusPending, Version: 1}
+	if err := s.db.Create(&order).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	stale := order
+	moved, err := transitionOrder(s.db, &order, orderStatusFilled)
+	if err != nil || !moved {
+		t.Fatalf("pending -> filled: moved %v, err %v", moved, err)
+	}
+	if order.Status != orderStatusFilled || order.Version != 2 {
+		t.Fatalf("order is %s at version %d, want filled at 2", order.Status, order.Version)
+	}
+
+	// A copy read before the fill loses the compare-and-swap and is left as
+	// it was
+	moved, err = transitionOrder(s.db, &stale, orderStatusCancelled)
+	if err != nil || moved {
+		t.Fatalf("stale pending -> cancelled: moved %v, err %v", moved, err)
+	}
+	if stale.Status != orderStatusPending || stale.Version != 1 {
+		t.Fatalf("stale copy changed to %s at version %d", stale.Status, stale.Version)
+	}
+
+	// Filled is final
+	if moved, err := transitionOrder(s.db, &order, orderStatusOpen); err == nil || moved {
+		t.Fatalf("filled -> open: moved %v, err %v", moved, err)
+	}
+
+	var stored Order
+	if err := s.db.First(&stored, order.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status != orderStatusFilled || stored.Version != 2 {
+		t.Fatalf("stored order is %s at version %d, want filled at 2", stored.Status, stored.Version)
+	}
+}
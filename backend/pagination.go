@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Page sizes for list endpoints when PAGE_SIZE_DEFAULT and PAGE_SIZE_MAX
+// aren't set
+const (
+	defaultPageSize    = 50
+	defaultMaxPageSize = 200
+)
+
+// paginationCodeInvalid is the code for ?limit= or ?offset= values that
+// can't be used
+const paginationCodeInvalid = "INVALID_PAGINATION"
+
+// paginationPolicy is the page size list endpoints use when the client
+// doesn't ask for one, and the largest they return
+type paginationPolicy struct {
+	Default, Max int
+}
+
+// loadPaginationPolicy reads the page sizes from the environment
+func loadPaginationPolicy() (paginationPolicy, error) {
+	var policy paginationPolicy
+	var err error
+
+	if policy.Default, err = envInt("PAGE_SIZE_DEFAULT", defaultPageSize); err != nil {
+		return policy, err
+	}
+	if policy.Max, err = envInt("PAGE_SIZE_MAX", defaultMaxPageSize); err != nil {
+		return policy, err
+	}
+	if policy.Default < 1 || policy.Max < 1 {
+		return policy, fmt.Errorf("PAGE_SIZE_DEFAULT and PAGE_SIZE_MAX must be positive")
+	}
+	if policy.Default > policy.Max {
+		return policy, fmt.Errorf("PAGE_SIZE_DEFAULT must not exceed PAGE_SIZE_MAX")
+	}
+	return policy, nil
+}
+
+// pageRequest is the slice of a list a client asked for
+type pageRequest struct {
+	Limit  int
+	Offset int
+}
+
+// parsePage reads ?limit= and ?offset= for a list endpoint. limit defaults
+// to the policy's page size and larger values are capped at its maximum;
+// offset defaults to 0. Values that aren't integers in range get 400 and
+// parsePage reports false.
+func (p paginationPolicy) parsePage(c *gin.Context) (pageRequest, bool) {
+	page := pageRequest{Limit: p.Default}
+	if raw := c.Query("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 {
+			c.JSON(400, gin.H{"error": "limit must be a positive integer", "code": paginationCodeInvalid})
+			return page, false
+		}
+		page.Limit = min(n, p.Max)
+	}
+	if raw := c.Query("offset"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			c.JSON(400, gin.H{"error": "offset must be a non-negative integer", "code": paginationCodeInvalid})
+			return page, false
+		}
+		page.Offset = n
+	}
+	return page, true
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestParsePage(t *testing.T) {
+	policy := paginationPolicy{Default: 50, Max: 200}
+	tests := []struct {
+		query string
+		want  pageRequest
+		ok    bool
+	}{
+		{"", pageRequest{Limit: 50}, true},
+		{"limit=1", pageRequest{Limit: 1}, true},
+		{"limit=200", pageRequest{Limit: 200}, true},
+		{"limit=201", pageRequest{Limit: 200}, true},
+		{"limit=1000000", pageRequest{Limit: 200}, true},
+		{"offset=0", pageRequest{Limit: 50}, true},
+		{"offset=75&limit=25", pageRequest{Limit: 25, Offset: 75}, true},
+		{"limit=0", pageRequest{}, false},
+		{"limit=-5", pageRequest{}, false},
+		{"limit=ten", pageRequest{}, false},
+		{"limit=1.5", pageRequest{}, false},
+		{"limit=99999999999999999999", pageRequest{}, false},
+		{"offset=-1", pageRequest{}, false},
+		{"offset=x", pageRequest{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest("GET", "/?"+tt.query, nil)
+
+			page, ok := policy.parsePage(c)
+			if ok != tt.ok {
+				t.Fatalf("ok = %t, want %t", ok, tt.ok)
+			}
+			if !ok {
+				if w.Code != 400 || !jsonHasCode(w.Body.Bytes(), paginationCodeInvalid) {
+					t.Fatalf("status %d: %s; want 400 %s", w.Code, w.Body, paginationCodeInvalid)
+				}
+				return
+			}
+			if page != tt.want {
+				t.Fatalf("page = %+v, want %+v", page, tt.want)
+			}
+		})
+	}
+}
+
+func TestPaginationPolicyConfig(t *testing.T) {
+	t.Setenv("PAGE_SIZE_DEFAULT", "10")
+	t.Setenv("PAGE_SIZE_MAX", "20")
+	policy, err := loadPaginationPolicy()
+	if err != nil || policy != (paginationPolicy{Default: 10, Max: 20}) {
+		t.Fatalf("policy = %+v, %v", policy, err)
+	}
+
+	for _, env := range []map[string]string{
+		{"PAGE_SIZE_DEFAULT": "30", "PAGE_SIZE_MAX": "20"},
+		{"PAGE_SIZE_DEFAULT": "0"},
+		{"PAGE_SIZE_MAX": "-1"},
+		{"PAGE_SIZE_MAX": "many"},
+	} {
+		for key, value := range env {
+			t.Setenv(key, value)
+		}
+		if _, err := loadPaginationPolicy(); err == nil {
+			t.Errorf("%v accepted", env)
+		}
+		t.Setenv("PAGE_SIZE_DEFAULT", "10")
+		t.Setenv("PAGE_SIZE_MAX", "20")
+	}
+}
+
+func TestPaginatedEndpointsShareLimits(t *testing.T) {
+	s, r := newTestRouter(t, map[string]string{"PAGE_SIZE_DEFAULT": "2", "PAGE_SIZE_MAX": "3"})
+	_, adminToken := createTestSession(t, s, seededAdmin(t, s))
+	for _, path := range []string{"/api/trades/recent", "/api/admin/orders", "/api/admin/users", "/api/notifications"} {
+		if w := doRequest(r, "GET", path+"?limit=0", adminToken, ""); w.Code != 400 || !jsonHasCode(w.Body.Bytes(), paginationCodeInvalid) {
+			t.Errorf("%s?limit=0: status %d: %s", path, w.Code, w.Body)
+		}
+		if w := doRequest(r, "GET", path+"?limit=3&offset=1", adminToken, ""); w.Code != 200 {
+			t.Errorf("%s?limit=3&offset=1: status %d: %s", path, w.Code, w.Body)
+		}
+	}
+
+	// The recent trades use the configured default and cap
+	user := createTestUser(t, s, "trader", roleUser)
+	for i := 0; i < 5; i++ {
+		createFilledOrder(t, s, user.ID, "AAPL", sideBuy, 1, 175.5)
+	}
+	for query, want := range map[string]int{"": 2, "?limit=10": 3, "?limit=1": 1, "?offset=4": 1} {
+		w := doRequest(r, "GET", "/api/trades/recent"+query, "", "")
+		var trades []Trade
+		if err := json.Unmarshal(w.Body.Bytes(), &trades); w.Code != 200 || err != nil {
+			t.Fatalf("trades%s: status %d: %s", query, w.Code, w.Body)
+		}
+		if len(trades) != want {
+			t.Errorf("trades%s returned %d, want %d", query, len(trades), want)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Password hashing algorithms. Each encoded hash starts with a prefix that
+// identifies its algorithm ("$2a$..." for bcrypt, "$argon2id$..." for
+// argon2id), so hashes from different schemes can coexist in the users table.
+const (
+	hashBcrypt   = "bcrypt"
+	hashArgon2id = "argon2id"
+)
+
+// errPasswordMismatch is returned when a password doesn't match its hash
+var errPasswordMismatch = errors.New("password does not match")
+
+// PasswordHasher hashes and verifies passwords for a single algorithm
+type PasswordHasher interface {
+	// Algorithm returns the identifier of the hashing scheme
+	Algorithm() string
+	// Hash returns the encoded hash of password
+	Hash(password string) (string, error)
+	// Compare returns nil if password matches the encoded hash
+	Compare(hash, password string) error
+}
+
+// newPasswordHasher returns the hasher for a configured algorithm name
+func newPasswordHasher(algorithm string) (PasswordHasher, error) {
+	switch algorithm {
+	case hashBcrypt:
+		return bcryptHasher{cost: bcrypt.DefaultCost}, nil
+	case hashArgon2id:
+		return defaultArgon2idHasher(), nil
+	default:
+		return nil, fmt.Errorf("unknown password hasher %q", algorithm)
+	}
+}
+
+// hashAlgorithm identifies the algorithm an encoded hash was produced with
+func hashAlgorithm(hash string) string {
+	switch {
+	case strings.HasPrefix(hash, "$argon2id$"):
+		return hashArgon2id
+	case strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"), strings.HasPrefix(hash, "$2y$"):
+		return hashBcrypt
+	default:
+		return ""
+	}
+}
+
+// comparePassword verifies password against a hash of any supported
+// algorithm and reports whether the hash should be upgraded to preferred
+func comparePassword(preferred PasswordHasher, hash, password string) (rehash bool, err error) {
+	algorithm := hashAlgorithm(hash)
+	hasher := preferred
+	if algorithm != preferred.Algorithm() {
+		if hasher, err = newPasswordHasher(algorithm); err != nil {
+			return false, err
+		}
+	}
+
+	if err := hasher.Compare(hash, password); err != nil {
+		return false, err
+	}
+	return algorithm != preferred.Algorithm(), nil
+}
+
+// bcryptHasher hashes passwords with bcrypt
+type bcryptHasher struct {
+	cost int
+}
+
+func (h bcryptHasher) Algorithm() string {
+	return hashBcrypt
+}
+
+func (h bcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.cost)
+	return string(hash), err
+}
+
+func (h bcryptHasher) Compare(hash, password string) error {
+	if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)); err != nil {
+		return errPasswordMismatch
+	}
+	return nil
+}
+
+// argon2idHasher hashes passwords with argon2id, encoding the parameters in
+// the PHC string format: $argon2id$v=19$m=65536,t=1,p=4$<salt>$<key>
+type argon2idHasher struct {
+	time    uint32
+	memory  uint32 // KiB
+	threads uint8
+	keyLen  uint32
+	saltLen int
+}
+
+// defaultArgon2idHasher uses the parameters recommended by RFC 9106
+func defaultArgon2idHasher() argon2idHasher {
+	return argon2idHasher{time: 1, memory: 64 * 1024, threads: 4, keyLen: 32, saltLen: 16}
+}
+
+func (h argon2idHasher) Algorithm() string {
+	return hashArgon2id
+}
+
+func (h argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.saltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, h.time, h.memory, h.threads, h.keyLen)
+
+	b64 := base64.RawStdEncoding
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.memory, h.time, h.threads, b64.EncodeToString(salt), b64.EncodeToString(key)), nil
+}
+
+// Compare re-derives the key with the parameters stored in the hash, so
+// hashes made with older parameters still verify
+func (h argon2idHasher) Compare(hash, password string) error {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != hashArgon2id {
+		return errors.New("malformed argon2id hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return errors.New("unsupported argon2id version")
+	}
+	var memory, time uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil {
+		return errors.New("malformed argon2id parameters")
+	}
+
+	b64 := base64.RawStdEncoding
+	salt, err := b64.DecodeString(parts[4])
+	if err != nil {
+		return errors.New("malformed argon2id salt")
+	}
+	want, err := b64.DecodeString(parts[5])
+	if err != nil {
+		return errors.New("malformed argon2id key")
+	}
+
+	got := argon2.IDKey([]byte(password), salt, time, memory, threads, uint32(len(want)))
+	if subtle.ConstantTimeCompare(got, want) != 1 {
+		return errPasswordMismatch
+	}
+	return nil
+}
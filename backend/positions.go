@@ -0,0 +1,268 @@
+package main
+
+import (
+	"container/list"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Positions cache settings. Entries are dropped as soon as the user's
+// orders change, so the TTL only bounds staleness from changes made
+// outside the server.
+const (
+	positionsCacheTTL  = 5 * time.Second
+	positionsCacheSize = 1024
+)
+
+// positionsCache holds recently computed positions per user, evicting the
+// least recently used user once it is full
+type positionsCache struct {
+	mu       sync.Mutex
+	ttl      time.Duration
+	capacity int
+	entries  map[uint]*list.Element
+	lru      *list.List // Front is the most recently used
+
+	// generation counts invalidations, so positions computed while the
+	// orders changed under them aren't cached
+	generation uint64
+}
+
+// positionsEntry is one user's cached positions
+type positionsEntry struct {
+	userID    uint
+	positions []SymbolAggregate
+	expiresAt time.Time
+}
+
+// newPositionsCache creates a cache holding up to capacity users for ttl
+func newPositionsCache(ttl time.Duration, capacity int) *positionsCache {
+	return &positionsCache{
+		ttl:      ttl,
+		capacity: capacity,
+		entries:  make(map[uint]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+// get returns the user's cached positions if they haven't expired
+func (c *positionsCache) get(userID uint, now time.Time) ([]SymbolAggregate, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[userID]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*positionsEntry)
+	if !now.Before(entry.expiresAt) {
+		c.lru.Remove(elem)
+		delete(c.entries, userID)
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	return entry.positions, true
+}
+
+// currentGeneration returns the generation to pass to put for positions
+// about to be computed
+func (c *positionsCache) currentGeneration() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.generation
+}
+
+// put caches the user's positions, evicting the least recently used user
+// if the cache is full. Positions computed before the latest invalidation
+// (an older generation) are discarded.
+func (c *positionsCache) put(userID uint, positions []SymbolAggregate, generation uint64, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+
+	entry := &positionsEntry{userID: userID, positions: positions, expiresAt: now.Add(c.ttl)}
+	if elem, ok := c.entries[userID]; ok {
+		elem.Value = entry
+		c.lru.MoveToFront(elem)
+		return
+	}
+
+	c.entries[userID] = c.lru.PushFront(entry)
+	if c.lru.Len() > c.capacity {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*positionsEntry).userID)
+	}
+}
+
+// invalidate drops the user's cached positions
+func (c *positionsCache) invalidate(userID uint) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	if elem, ok := c.entries[userID]; ok {
+		c.lru.Remove(elem)
+		delete(c.entries, userID)
+	}
+}
+
+// clear drops every cached entry, after changes that touch many users
+func (c *positionsCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	c.entries = make(map[uint]*list.Element)
+	c.lru.Init()
+}
+
+// positions returns the user's filled orders, archived ones included,
+// aggregated per symbol, sorted by symbol, from the cache when possible.
+// The result is shared with the cache and must not be modified.
+func (s *Server) positions(userID uint) ([]SymbolAggregate, error) {
+	now := time.Now()
+	if cached, ok := s.positionsCache.get(userID, now); ok {
+		return cached, nil
+	}
+	generation := s.positionsCache.currentGeneration()
+
+	var rows []struct {
+		Symbol       string
+		BuyQuantity  float64
+		SellQuantity float64
+		BuyNotional  float64
+		SellNotional float64
+	}
+	err := s.db.Model(&Order{}).
+		Select(`symbol,
+			COALESCE(SUM(CASE WHEN side IN ('buy', 'cover') THEN quantity END), 0) AS buy_quantity,
+			COALESCE(SUM(CASE WHEN side IN ('sell', 'short') THEN quantity END), 0) AS sell_quantity,
+			COALESCE(SUM(CASE WHEN side IN ('buy', 'cover') THEN quantity * price END), 0) AS buy_notional,
+			COALESCE(SUM(CASE WHEN side IN ('sell', 'short') THEN quantity * price END), 0) AS sell_notional`).
+		Where("user_id = ? AND status = ?", userID, orderStatusFilled).
+		Group("symbol").
+		Order("symbol").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	aggregates := make([]SymbolAggregate, 0, len(rows))
+	for _, row := range rows {
+		agg := SymbolAggregate{
+			Symbol:       row.Symbol,
+			BuyQuantity:  trimFloat(row.BuyQuantity),
+			SellQuantity: trimFloat(row.SellQuantity),
+			NetQuantity:  trimFloat(row.BuyQuantity - row.SellQuantity),
+		}
+		// Averages are taken over the rounded amounts paid and received
+		decimals := decimalsFor(row.Symbol)
+		if row.BuyQuantity > 0 {
+			agg.AvgBuyPrice = roundMoney(row.BuyNotional, decimals, moneyRounding) / row.BuyQuantity
+		}
+		if row.SellQuantity > 0 {
+			agg.AvgSellPrice = roundMoney(row.SellNotional, decimals, moneyRounding) / row.SellQuantity
+		}
+		aggregates = append(aggregates, agg)
+	}
+
+	s.positionsCache.put(userID, aggregates, generation, now)
+	return aggregates, nil
+}
+
+// checkPosition keeps sells and covers from crossing a position through
+// zero when short selling is enabled: a sell may only close out shares held
+// and a cover only a short, so going short always takes a short order. The
+// position is that of filled orders, archived or not, less whatever working
+// sells or covers already claim. placeOrder checks again in its transaction,
+// after taking the write lock, so concurrent orders can't both claim it.
+func (s *Server) checkPosition(db *gorm.DB, userID uint, req OrderRequest) *orderError {
+	if !s.shortSelling || (req.Side != sideSell && req.Side != sideCover) {
+		return nil
+	}
+
+	var position struct {
+		Net      float64
+		Selling  float64
+		Covering float64
+	}
+	err := db.Model(&Order{}).
+		Select(`COALESCE(SUM(CASE WHEN status = ? THEN
+				CASE WHEN side IN ('buy', 'cover') THEN quantity ELSE -quantity END END), 0) AS net,
+			COALESCE(SUM(CASE WHEN status IN ? AND side = 'sell' THEN quantity END), 0) AS selling,
+			COALESCE(SUM(CASE WHEN status IN ? AND side = 'cover' THEN quantity END), 0) AS covering`,
+			orderStatusFilled, workingOrderStatuses, workingOrderStatuses).
+		Where("user_id = ? AND symbol = ?", userID, req.Symbol).
+		Scan(&position).Error
+	if err != nil {
+		return &orderError{Status: 500, Code: orderCodeInternal, Message: "Failed to check position"}
+	}
+
+	if held := trimFloat(position.Net - position.Selling); req.Side == sideSell && req.Quantity > held {
+		return &orderError{Status: 409, Code: orderCodeNoPosition, Message: fmt.Sprintf("Can only sell the %g %s held and not already being sold; use a short order to go short", max(held, 0), req.Symbol)}
+	}
+	if short := trimFloat(-position.Net - position.Covering); req.Side == sideCover && req.Quantity > short {
+		return &orderError{Status: 409, Code: orderCodeNoPosition, Message: fmt.Sprintf("Can only cover the %g %s sold short and not already being covered", max(short, 0), req.Symbol)}
+	}
+	return nil
+}
+
+// PortfolioSnapshot is a user's positions as recomputed from their orders
+type PortfolioSnapshot struct {
+	UserID         uint              `json:"user_id"`
+	Positions      []SymbolAggregate `json:"positions"`
+	RecalculatedAt time.Time         `json:"recalculated_at"`
+}
+
+// recalculatePortfolio drops the user's cached positions and rebuilds them
+// from their order history, for use after orders have been corrected behind
+// the cache's back. Users recalculate their own portfolio; admins may pass
+// ?user_id= for someone else's.
+func (s *Server) recalculatePortfolio(c *gin.Context) {
+	callerID, _ := c.Get("user_id")
+	userID := callerID.(uint)
+	if raw := c.Query("user_id"); raw != "" {
+		if role, _ := c.Get("role"); role != roleAdmin {
+			c.JSON(403, gin.H{"error": "Admin access required"})
+			return
+		}
+		id, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil || id == 0 {
+			c.JSON(400, gin.H{"error": "Invalid user_id"})
+			return
+		}
+		var count int64
+		if err := s.db.Model(&User{}).Where("id = ?", id).Count(&count).Error; err != nil {
+			c.JSON(500, gin.H{"error": "Failed to fetch user"})
+			return
+		}
+		if count == 0 {
+			c.JSON(404, gin.H{"error": "User not found"})
+			return
+		}
+		userID = uint(id)
+	}
+
+	s.positionsCache.invalidate(userID)
+	positions, err := s.positions(userID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to aggregate orders"})
+		return
+	}
+	if userID != callerID.(uint) {
+		log.Printf("Portfolio of user %d recalculated by admin %d", userID, callerID)
+	}
+
+	c.JSON(200, PortfolioSnapshot{UserID: userID, Positions: positions, RecalculatedAt: time.Now()})
+}
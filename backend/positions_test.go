@@ -0,0 +1,307 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+// netPosition returns the user's net filled quantity in symbol
+func netPosition(t *testing.T, s *Server, userID uint, symbol string) float64 {
+	t.Helper()
+	positions, err := s.positions(userID)
+	if err != nil {
+		t.Fatalf("positions: %v", err)
+	}
+	for _, position := range positions {
+		if position.Symbol == symbol {
+			return position.NetQuantity
+		}
+	}
+	return 0
+}
+
+func TestShortThenCoverRoundtrip(t *testing.T) {
+	s := newTestServer(t, map[string]string{"SHORT_SELLING": "true"})
+	user := createTestUser(t, s, "trader", roleUser)
+
+	if _, err := s.placeOrder(user.ID, false, OrderRequest{Symbol: "AAPL", Side: sideShort, Quantity: 5}); err != nil {
+		t.Fatalf("short: %v", err.Message)
+	}
+	if net := netPosition(t, s, user.ID, "AAPL"); net != -5 {
+		t.Fatalf("net after short = %g, want -5", net)
+	}
+
+	// Nothing is held, so the short can't be closed with a sell, and it
+	// can't be covered past zero
+	if _, err := s.placeOrder(user.ID, false, OrderRequest{Symbol: "AAPL", Side: sideSell, Quantity: 1}); err == nil || err.Code != orderCodeNoPosition {
+		t.Fatalf("sell while short = %v, want %s", err, orderCodeNoPosition)
+	}
+	if _, err := s.placeOrder(user.ID, false, OrderRequest{Symbol: "AAPL", Side: sideCover, Quantity: 6}); err == nil || err.Code != orderCodeNoPosition {
+		t.Fatalf("cover past zero = %v, want %s", err, orderCodeNoPosition)
+	}
+
+	if _, err := s.placeOrder(user.ID, false, OrderRequest{Symbol: "AAPL", Side: sideCover, Quantity: 5}); err != nil {
+		t.Fatalf("cover: %v", err.Message)
+	}
+	if net := netPosition(t, s, user.ID, "AAPL"); net != 0 {
+		t.Fatalf("net after cover = %g, want 0", net)
+	}
+	if _, err := s.placeOrder(user.ID, false, OrderRequest{Symbol: "AAPL", Side: sideCover, Quantity: 1}); err == nil || err.Code != orderCodeNoPosition {
+		t.Fatalf("cover when flat = %v, want %s", err, orderCodeNoPosition)
+	}
+}
+
+func TestPendingSellsClaimPosition(t *testing.T) {
+	s := newTestServer(t, map[string]string{"SHORT_SELLING": "true", "SETTLEMENT_DELAY": "1h"})
+	user := createTestUser(t, s, "trader", roleUser)
+	createFilledOrder(t, s, user.ID, "AAPL", sideBuy, 10, 100)
+
+	order, err := s.placeOrder(user.ID, false, OrderRequest{Symbol: "AAPL", Side: sideSell, Quantity: 10})
+	if err != nil {
+		t.Fatalf("sell: %v", err.Message)
+	}
+	if order.Status != orderStatusPending {
+		t.Fatalf("status = %s, want %s", order.Status, orderStatusPending)
+	}
+
+	// The pending sell already claims every share held
+	if _, err := s.placeOrder(user.ID, false, OrderRequest{Symbol: "AAPL", Side: sideSell, Quantity: 1}); err == nil || err.Code != orderCodeNoPosition {
+		t.Fatalf("second sell = %v, want %s", err, orderCodeNoPosition)
+	}
+}
+
+func TestConcurrentSellsClaimPositionOnce(t *testing.T) {
+	s := newTestServer(t, map[string]string{"SHORT_SELLING": "true"})
+	user := createTestUser(t, s, "trader", roleUser)
+	createFilledOrder(t, s, user.ID, "AAPL", sideBuy, 10, 100)
+
+	const sellers = 8
+	errs := make([]*orderError, sellers)
+	var wg sync.WaitGroup
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = s.placeOrder(user.ID, false, OrderRequest{Symbol: "AAPL", Side: sideSell, Quantity: 10})
+		}(i)
+	}
+	wg.Wait()
+
+	filled := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			filled++
+		case err.Code != orderCodeNoPosition:
+			t.Errorf("sell failed with %s: %s", err.Code, err.Message)
+		}
+	}
+	if filled != 1 {
+		t.Fatalf("%d sells filled, want 1", filled)
+	}
+	if net := netPosition(t, s, user.ID, "AAPL"); net != 0 {
+		t.Fatalf("net = %g, want 0", net)
+	}
+}
+
+// cachedPositions reports whether the user's positions are in the cache
+func cachedPositions(s *Server, userID uint) bool {
+	_, ok := s.positionsCache.get(userID, time.Now())
+	return ok
+}
+
+func TestPositionsCacheInvalidation(t *testing.T) {
+	s := newTestServer(t, map[string]string{"SETTLEMENT_DELAY": "1h"})
+	user := createTestUser(t, s, "trader", roleUser)
+	other := createTestUser(t, s, "other", roleUser)
+	createFilledOrder(t, s, user.ID, "AAPL", sideBuy, 2, 100)
+
+	if net := netPosition(t, s, user.ID, "AAPL"); net != 2 {
+		t.Fatalf("net = %g, want 2", net)
+	}
+	netPosition(t, s, other.ID, "AAPL")
+
+	// A new order drops the cache even while it is pending
+	pending, err := s.placeOrder(user.ID, false, OrderRequest{Symbol: "AAPL", Side: sideBuy, Quantity: 3})
+	if err != nil {
+		t.Fatalf("placeOrder: %s", err.Message)
+	}
+	if cachedPositions(s, user.ID) {
+		t.Fatal("positions still cached after a new order")
+	}
+	if !cachedPositions(s, other.ID) {
+		t.Fatal("another user's positions were dropped")
+	}
+	if net := netPosition(t, s, user.ID, "AAPL"); net != 2 {
+		t.Fatalf("net with the order pending = %g, want 2", net)
+	}
+
+	// Its fill does too, and the position picks it up
+	if n, err := s.settleOrders(time.Now().Add(2 * time.Hour)); err != nil || n != 1 {
+		t.Fatalf("settleOrders = %d, %v", n, err)
+	}
+	if cachedPositions(s, user.ID) {
+		t.Fatal("positions still cached after a fill")
+	}
+	if net := netPosition(t, s, user.ID, "AAPL"); net != 5 {
+		t.Fatalf("net after the fill = %g, want 5", net)
+	}
+
+	// And so does a cancellation
+	pending, err = s.placeOrder(user.ID, false, OrderRequest{Symbol: "AAPL", Side: sideBuy, Quantity: 1})
+	if err != nil {
+		t.Fatalf("placeOrder: %s", err.Message)
+	}
+	netPosition(t, s, user.ID, "AAPL")
+	if _, err := s.cancelOrder(pending.ID, user.ID, user.ID, nil); err != nil {
+		t.Fatalf("cancelOrder: %s", err.Message)
+	}
+	if cachedPositions(s, user.ID) {
+		t.Fatal("positions still cached after a cancellation")
+	}
+	if net := netPosition(t, s, user.ID, "AAPL"); net != 5 {
+		t.Fatalf("net after the cancellation = %g, want 5", net)
+	}
+}
+
+func TestPositionsCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := newPositionsCache(time.Minute, 2)
+	now := time.Now()
+
+	cache.put(1, nil, cache.currentGeneration(), now)
+	cache.put(2, nil, cache.currentGeneration(), now)
+	cache.get(1, now)
+	cache.put(3, nil, cache.currentGeneration(), now)
+
+	if _, ok := cache.get(2, now); ok {
+		t.Fatal("least recently used user kept")
+	}
+	for _, id := range []uint{1, 3} {
+		if _, ok := cache.get(id, now); !ok {
+			t.Fatalf("user %d evicted", id)
+		}
+	}
+	if _, ok := cache.get(1, now.Add(time.Minute)); ok {
+		t.Fatal("expired entry returned")
+	}
+}
+
+// benchmarkPositions times positions for a user with 1000 filled orders,
+// dropping the cache before each call unless cached is set
+func benchmarkPositions(b *testing.B, cached bool) {
+	quietLogs(b)
+	s := newTestServer(b, nil)
+	user := createTestUser(b, s, "trader", roleUser)
+	symbols := []string{"AAPL", "TSLA", "MSFT", "GOOGL"}
+	orders := make([]Order, 1000)
+	for i := range orders {
+		orders[i] = Order{UserID: user.ID, Number: uint(i + 1), Symbol: symbols[i%len(symbols)], Side: sideBuy, Quantity: 1, Price: 100, Timestamp: time.Now(), Status: orderStatusFilled, Version: 1}
+	}
+	if err := s.db.CreateInBatches(orders, 200).Error; err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if !cached {
+			s.positionsCache.invalidate(user.ID)
+		}
+		if _, err := s.positions(user.ID); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkPositionsUncached(b *testing.B) { benchmarkPositions(b, false) }
+
+func BenchmarkPositionsCached(b *testing.B) { benchmarkPositions(b, true) }
+
+func TestArchivedBuyCanBeSold(t *testing.T) {
+	s := newTestServer(t, map[string]string{"SHORT_SELLING": "true", "ORDER_RETENTION_COUNT": "1"})
+	user := createTestUser(t, s, "trader", roleUser)
+	createFilledOrder(t, s, user.ID, "AAPL", sideBuy, 10, 100)
+	createFilledOrder(t, s, user.ID, "TSLA", sideBuy, 1, 200)
+	if n, err := s.archiveOrders(time.Now()); err != nil || n != 1 {
+		t.Fatalf("archiveOrders = %d, %v, want the AAPL buy archived", n, err)
+	}
+
+	// The archived shares are still held
+	if _, err := s.placeOrder(user.ID, false, OrderRequest{Symbol: "AAPL", Side: sideSell, Quantity: 10}); err != nil {
+		t.Fatalf("selling archived shares: %s", err.Message)
+	}
+	if _, err := s.placeOrder(user.ID, false, OrderRequest{Symbol: "AAPL", Side: sideSell, Quantity: 1}); err == nil || err.Code != orderCodeNoPosition {
+		t.Fatalf("selling past the position = %v, want %s", err, orderCodeNoPosition)
+	}
+}
+
+// bySymbol returns the user's AAPL aggregate from GET /api/orders/by-symbol
+func bySymbol(t *testing.T, h http.Handler, token string) SymbolAggregate {
+	t.Helper()
+	w := doRequest(h, "GET", "/api/orders/by-symbol?symbol=AAPL", token, "")
+	var aggregates []SymbolAggregate
+	if err := json.Unmarshal(w.Body.Bytes(), &aggregates); w.Code != 200 || err != nil || len(aggregates) != 1 {
+		t.Fatalf("by-symbol: status %d: %s", w.Code, w.Body)
+	}
+	return aggregates[0]
+}
+
+func TestRecalculateRepairsCorruptedCache(t *testing.T) {
+	s, r := newTestRouter(t, nil)
+	user := createTestUser(t, s, "trader", roleUser)
+	_, token := createTestSession(t, s, user)
+	order := createFilledOrder(t, s, user.ID, "AAPL", sideBuy, 10, 100)
+	createFilledOrder(t, s, user.ID, "AAPL", sideBuy, 10, 200)
+	if got := bySymbol(t, r, token); got.NetQuantity != 20 || got.AvgBuyPrice != 150 {
+		t.Fatalf("before the correction: %+v", got)
+	}
+
+	// Correct an order behind the cache's back, and corrupt the cache too
+	s.db.Model(&order).Update("quantity", 30)
+	s.positionsCache.put(user.ID, []SymbolAggregate{{Symbol: "AAPL", NetQuantity: 999}}, s.positionsCache.currentGeneration(), time.Now())
+	if got := bySymbol(t, r, token); got.NetQuantity != 999 {
+		t.Fatalf("corrupted cache not served: %+v", got)
+	}
+
+	w := doRequest(r, "POST", "/api/portfolio/recalculate", token, "")
+	var snapshot PortfolioSnapshot
+	if err := json.Unmarshal(w.Body.Bytes(), &snapshot); w.Code != 200 || err != nil {
+		t.Fatalf("recalculate: status %d: %s", w.Code, w.Body)
+	}
+	want := SymbolAggregate{Symbol: "AAPL", BuyQuantity: 40, NetQuantity: 40, AvgBuyPrice: 125}
+	if snapshot.UserID != user.ID || len(snapshot.Positions) != 1 || snapshot.Positions[0] != want {
+		t.Fatalf("snapshot = %+v, want user %d with %+v", snapshot, user.ID, want)
+	}
+	if got := bySymbol(t, r, token); got != want {
+		t.Errorf("after recalculating: %+v, want %+v", got, want)
+	}
+}
+
+func TestRecalculateOtherUsers(t *testing.T) {
+	s, r := newTestRouter(t, nil)
+	_, adminToken := createTestSession(t, s, seededAdmin(t, s))
+	user := createTestUser(t, s, "trader", roleUser)
+	_, token := createTestSession(t, s, user)
+	other := createTestUser(t, s, "other", roleUser)
+	createFilledOrder(t, s, other.ID, "AAPL", sideBuy, 5, 100)
+	s.positionsCache.put(other.ID, nil, s.positionsCache.currentGeneration(), time.Now())
+
+	path := fmt.Sprintf("/api/portfolio/recalculate?user_id=%d", other.ID)
+	if w := doRequest(r, "POST", path, token, ""); w.Code != 403 {
+		t.Errorf("user recalculating another user: status %d, want 403", w.Code)
+	}
+	w := doRequest(r, "POST", path, adminToken, "")
+	var snapshot PortfolioSnapshot
+	if err := json.Unmarshal(w.Body.Bytes(), &snapshot); w.Code != 200 || err != nil {
+		t.Fatalf("admin recalculating: status %d: %s", w.Code, w.Body)
+	}
+	if snapshot.UserID != other.ID || len(snapshot.Positions) != 1 || snapshot.Positions[0].NetQuantity != 5 {
+		t.Errorf("snapshot = %+v", snapshot)
+	}
+	if w := doRequest(r, "POST", "/api/portfolio/recalculate?user_id=999", adminToken, ""); w.Code != 404 {
+		t.Errorf("unknown user: status %d, want 404", w.Code)
+	}
+}
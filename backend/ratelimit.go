@@ -0,0 +1,272 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultRateLimitWindow is the window request rate limits are counted over
+const defaultRateLimitWindow = time.Minute
+
+// defaultSymbolOrderWindow is the window per-symbol order limits are
+// counted over
+const defaultSymbolOrderWindow = 5 * time.Second
+
+// rateLimitCode is returned with 429 when a client exceeds a request limit
+const rateLimitCode = "RATE_LIMITED"
+
+// dailyOrderLimiter caps how many orders each user may place per UTC day.
+// Counts are kept in memory and start over at midnight UTC or on restart.
+type dailyOrderLimiter struct {
+	mu     sync.Mutex
+	limit  int
+	day    time.Time
+	counts map[uint]int
+}
+
+// newDailyOrderLimiter creates a limiter allowing limit orders per user per
+// day; a limit of 0 allows everything
+func newDailyOrderLimiter(limit int) *dailyOrderLimiter {
+	return &dailyOrderLimiter{
+		limit:  limit,
+		counts: make(map[uint]int),
+	}
+}
+
+// allow records an order for the user if they are under today's limit. When
+// the limit is reached it reports false along with the time it resets.
+func (l *dailyOrderLimiter) allow(userID uint, now time.Time) (bool, time.Time) {
+	today := now.UTC().Truncate(24 * time.Hour)
+	resetAt := today.Add(24 * time.Hour)
+	if l.limit == 0 {
+		return true, resetAt
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Forget yesterday's counts once the day rolls over
+	if !today.Equal(l.day) {
+		l.day = today
+		l.counts = make(map[uint]int)
+	}
+
+	if l.counts[userID] >= l.limit {
+		return false, resetAt
+	}
+	l.counts[userID]++
+	return true, resetAt
+}
+
+// peek reports whether allow would currently succeed, without recording
+// an order
+func (l *dailyOrderLimiter) peek(userID uint, now time.Time) (bool, time.Time) {
+	today := now.UTC().Truncate(24 * time.Hour)
+	resetAt := today.Add(24 * time.Hour)
+	if l.limit == 0 {
+		return true, resetAt
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !today.Equal(l.day) {
+		return true, resetAt
+	}
+	return l.counts[userID] < l.limit, resetAt
+}
+
+// reset forgets every user's count for the current day
+func (l *dailyOrderLimiter) reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.counts = make(map[uint]int)
+}
+
+// cooldownLimiter lets each user do something at most once per interval,
+// for expensive requests. Like dailyOrderLimiter it only lives in memory.
+type cooldownLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	last     map[uint]time.Time
+}
+
+// newCooldownLimiter creates a limiter allowing one call per user per interval
+func newCooldownLimiter(interval time.Duration) *cooldownLimiter {
+	return &cooldownLimiter{
+		interval: interval,
+		last:     make(map[uint]time.Time),
+	}
+}
+
+// allow records a call for the user unless they made one within the
+// interval, in which case it reports false and when they may try again
+func (l *cooldownLimiter) allow(userID uint, now time.Time) (bool, time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if next := l.last[userID].Add(l.interval); now.Before(next) {
+		return false, next
+	}
+	l.last[userID] = now
+
+	// Drop users whose cooldown is over so the map doesn't grow forever
+	for id, at := range l.last {
+		if !now.Before(at.Add(l.interval)) {
+			delete(l.last, id)
+		}
+	}
+	return true, time.Time{}
+}
+
+// rateLimitPolicy caps how many /api requests each client IP may make per
+// window, counting reads (GET, HEAD, OPTIONS) and writes separately
+type rateLimitPolicy struct {
+	Read, Write int // 0 leaves that kind of request unlimited
+	Window      time.Duration
+}
+
+// enabled reports whether any request limit is configured
+func (p rateLimitPolicy) enabled() bool {
+	return p.Read > 0 || p.Write > 0
+}
+
+// loadRateLimitPolicy reads the request rate limits from the environment
+func loadRateLimitPolicy() (rateLimitPolicy, error) {
+	var policy rateLimitPolicy
+	var err error
+
+	if policy.Read, err = envInt("RATE_LIMIT_READ", 0); err != nil {
+		return policy, err
+	}
+	if policy.Write, err = envInt("RATE_LIMIT_WRITE", 0); err != nil {
+		return policy, err
+	}
+	if policy.Read < 0 || policy.Write < 0 {
+		return policy, fmt.Errorf("RATE_LIMIT_READ and RATE_LIMIT_WRITE must not be negative")
+	}
+	if policy.Window, err = envDuration("RATE_LIMIT_WINDOW", defaultRateLimitWindow); err != nil {
+		return policy, err
+	}
+	if policy.Window <= 0 {
+		return policy, fmt.Errorf("RATE_LIMIT_WINDOW must be positive")
+	}
+	return policy, nil
+}
+
+// windowLimiter allows each key a fixed number of calls per window. Windows
+// start at a key's first call, and counts only live in memory.
+type windowLimiter struct {
+	mu        sync.Mutex
+	limit     int
+	window    time.Duration
+	windows   map[string]*rateWindow
+	lastSweep time.Time
+}
+
+// rateWindow is one key's count in its current window
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+// newWindowLimiter creates a limiter allowing limit calls per key per window
+func newWindowLimiter(limit int, window time.Duration) *windowLimiter {
+	return &windowLimiter{
+		limit:   limit,
+		window:  window,
+		windows: make(map[string]*rateWindow),
+	}
+}
+
+// allow records a call for key if it is under the limit. It also reports how
+// many calls the key has left and when its window resets.
+func (l *windowLimiter) allow(key string, now time.Time) (ok bool, remaining int, resetAt time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Forget keys whose windows have ended, at most once per window
+	if now.Sub(l.lastSweep) >= l.window {
+		for k, w := range l.windows {
+			if now.Sub(w.start) >= l.window {
+				delete(l.windows, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	w, found := l.windows[key]
+	if !found || now.Sub(w.start) >= l.window {
+		w = &rateWindow{start: now}
+		l.windows[key] = w
+	}
+	resetAt = w.start.Add(l.window)
+
+	if w.count >= l.limit {
+		return false, 0, resetAt
+	}
+	w.count++
+	return true, l.limit - w.count, resetAt
+}
+
+// peek reports whether allow would currently succeed for key, and when its
+// window resets, without recording a call
+func (l *windowLimiter) peek(key string, now time.Time) (ok bool, resetAt time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	w, found := l.windows[key]
+	if !found || now.Sub(w.start) >= l.window {
+		return true, now.Add(l.window)
+	}
+	return w.count < l.limit, w.start.Add(l.window)
+}
+
+// apiRateLimit applies the policy's read or write limit to every /api
+// request; health probes and the WebSocket sit outside /api and are exempt
+func apiRateLimit(policy rateLimitPolicy) gin.HandlerFunc {
+	var reads, writes *windowLimiter
+	if policy.Read > 0 {
+		reads = newWindowLimiter(policy.Read, policy.Window)
+	}
+	if policy.Write > 0 {
+		writes = newWindowLimiter(policy.Write, policy.Window)
+	}
+
+	return func(c *gin.Context) {
+		if !strings.HasPrefix(c.Request.URL.Path, "/api/") {
+			return
+		}
+		limiter := writes
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			limiter = reads
+		}
+		if limiter != nil {
+			limitRequest(c, limiter)
+		}
+	}
+}
+
+// limitRequest counts the request against limiter for the client's IP,
+// reporting the limit in X-RateLimit-* headers and aborting with 429 once it
+// is used up. Other per-route limits can reuse it with their own limiter.
+func limitRequest(c *gin.Context, limiter *windowLimiter) {
+	now := time.Now()
+	ok, remaining, resetAt := limiter.allow(c.ClientIP(), now)
+
+	c.Header("X-RateLimit-Limit", strconv.Itoa(limiter.limit))
+	c.Header("X-RateLimit-Remaining", strconv.Itoa(remaining))
+	c.Header("X-RateLimit-Reset", strconv.FormatInt(resetAt.Unix(), 10))
+	if !ok {
+		c.Header("Retry-After", strconv.Itoa(int(resetAt.Sub(now).Seconds())+1))
+		c.AbortWithStatusJSON(429, gin.H{"error": "Too many requests, please slow down", "code": rateLimitCode, "reset_at": resetAt})
+	}
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRateLimitHeadersAndBurst(t *testing.T) {
+	_, r := newTestRouter(t, map[string]string{"RATE_LIMIT_READ": "3", "RATE_LIMIT_WRITE": "1"})
+
+	start := time.Now()
+	for i := 1; i <= 3; i++ {
+		w := doRequest(r, "GET", "/api/symbols", "", "")
+		if w.Code != 200 {
+			t.Fatalf("read %d: status %d", i, w.Code)
+		}
+		if got := w.Header().Get("X-RateLimit-Limit"); got != "3" {
+			t.Fatalf("read %d: X-RateLimit-Limit = %q, want 3", i, got)
+		}
+		if got, want := w.Header().Get("X-RateLimit-Remaining"), strconv.Itoa(3-i); got != want {
+			t.Fatalf("read %d: X-RateLimit-Remaining = %q, want %s", i, got, want)
+		}
+		reset, err := strconv.ParseInt(w.Header().Get("X-RateLimit-Reset"), 10, 64)
+		if err != nil || reset < start.Add(time.Minute).Unix()-1 || reset > time.Now().Add(time.Minute).Unix() {
+			t.Fatalf("read %d: X-RateLimit-Reset = %q, want about a minute from now", i, w.Header().Get("X-RateLimit-Reset"))
+		}
+	}
+
+	w := doRequest(r, "GET", "/api/symbols", "", "")
+	if w.Code != 429 || !jsonHasCode(w.Body.Bytes(), rateLimitCode) {
+		t.Fatalf("read over the limit: status %d: %s", w.Code, w.Body)
+	}
+	if w.Header().Get("X-RateLimit-Remaining") != "0" {
+		t.Fatalf("X-RateLimit-Remaining = %q, want 0", w.Header().Get("X-RateLimit-Remaining"))
+	}
+	if retry, err := strconv.Atoi(w.Header().Get("Retry-After")); err != nil || retry < 1 || retry > 61 {
+		t.Fatalf("Retry-After = %q", w.Header().Get("Retry-After"))
+	}
+
+	// Writes are counted in their own bucket, so they still get through
+	if w := doRequest(r, "POST", "/api/login", "", `{"username":"nobody","password":"wrong"}`); w.Code == 429 {
+		t.Fatal("write limited by the read bucket")
+	} else if w.Header().Get("X-RateLimit-Limit") != "1" {
+		t.Fatalf("write X-RateLimit-Limit = %q, want 1", w.Header().Get("X-RateLimit-Limit"))
+	}
+	if w := doRequest(r, "POST", "/api/login", "", `{"username":"nobody","password":"wrong"}`); w.Code != 429 {
+		t.Fatalf("write over the limit: status %d", w.Code)
+	}
+
+	// Health probes sit outside /api
+	if w := doRequest(r, "GET", "/healthz", "", ""); w.Code != 200 || w.Header().Get("X-RateLimit-Limit") != "" {
+		t.Fatalf("healthz: status %d, X-RateLimit-Limit %q", w.Code, w.Header().Get("X-RateLimit-Limit"))
+	}
+}
+
+func TestWindowLimiterResets(t *testing.T) {
+	limiter := newWindowLimiter(2, time.Minute)
+	now := time.Unix(1_700_000_000, 0)
+
+	for i := 0; i < 2; i++ {
+		if ok, _, _ := limiter.allow("10.0.0.1", now); !ok {
+			t.Fatalf("call %d refused", i+1)
+		}
+	}
+	ok, remaining, resetAt := limiter.allow("10.0.0.1", now.Add(59*time.Second))
+	if ok || remaining != 0 || !resetAt.Equal(now.Add(time.Minute)) {
+		t.Fatalf("third call: ok %v, remaining %d, reset %v", ok, remaining, resetAt)
+	}
+
+	// Other clients have their own window
+	if ok, _, _ := limiter.allow("10.0.0.2", now); !ok {
+		t.Fatal("second client refused")
+	}
+
+	// Once the window ends the client starts over
+	ok, remaining, resetAt = limiter.allow("10.0.0.1", now.Add(time.Minute))
+	if !ok || remaining != 1 || !resetAt.Equal(now.Add(2*time.Minute)) {
+		t.Fatalf("after reset: ok %v, remaining %d, reset %v", ok, remaining, resetAt)
+	}
+}
+
+func TestDailyOrderLimiter(t *testing.T) {
+	l := newDailyOrderLimiter(2)
+	morning := time.Date(2026, 3, 9, 8, 0, 0, 0, time.UTC)
+	midnight := time.Date(2026, 3, 10, 0, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.allow(1, morning); !ok {
+			t.Fatalf("order %d refused", i+1)
+		}
+	}
+	ok, resetAt := l.allow(1, morning.Add(15*time.Hour))
+	if ok || !resetAt.Equal(midnight) {
+		t.Fatalf("third order = %v, resets at %v; want refused until %v", ok, resetAt, midnight)
+	}
+	if ok, _ := l.peek(1, morning); ok {
+		t.Error("peek allows past the limit")
+	}
+
+	// Each user has their own count
+	if ok, _ := l.allow(2, morning); !ok {
+		t.Error("another user refused")
+	}
+
+	// The count starts over at midnight UTC
+	if ok, _ := l.peek(1, midnight.Add(-time.Nanosecond)); ok {
+		t.Error("allowed just before midnight")
+	}
+	if ok, _ := l.allow(1, midnight); !ok {
+		t.Error("refused after midnight")
+	}
+
+	// Midnight is UTC, not local
+	est := time.FixedZone("EST", -5*60*60)
+	if ok, _ := l.allow(1, time.Date(2026, 3, 9, 22, 0, 0, 0, est)); !ok {
+		t.Error("refused at 22:00 EST, which is 03:00 UTC the next day")
+	}
+
+	// A limit of 0 allows everything
+	unlimited := newDailyOrderLimiter(0)
+	for i := 0; i < 100; i++ {
+		if ok, _ := unlimited.allow(1, morning); !ok {
+			t.Fatal("unlimited limiter refused an order")
+		}
+	}
+}
+
+func TestDailyOrderLimitResponse(t *testing.T) {
+	s, r := newTestRouter(t, map[string]string{"MAX_ORDERS_PER_DAY": "1"})
+	user := createTestUser(t, s, "trader", roleUser)
+	_, token := createTestSession(t, s, user)
+	_, adminToken := createTestSession(t, s, seededAdmin(t, s))
+	order := `{"symbol":"AAPL","side":"buy","quantity":1}`
+
+	if w := doRequest(r, "POST", "/api/orders", token, order); w.Code != 201 {
+		t.Fatalf("first order: status %d: %s", w.Code, w.Body)
+	}
+	w := doRequest(r, "POST", "/api/orders", token, order)
+	var body struct {
+		Code    string    `json:"code"`
+		ResetAt time.Time `json:"reset_at"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); w.Code != 429 || err != nil || body.Code != orderCodeDailyLimit {
+		t.Fatalf("second order: status %d: %s", w.Code, w.Body)
+	}
+	midnight := time.Now().UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+	if !body.ResetAt.Equal(midnight) {
+		t.Errorf("reset_at = %v, want %v", body.ResetAt, midnight)
+	}
+	if retry, err := strconv.Atoi(w.Header().Get("Retry-After")); err != nil || retry < 1 || retry > 24*60*60+1 {
+		t.Errorf("Retry-After = %q", w.Header().Get("Retry-After"))
+	}
+
+	// Admins are exempt
+	for i := 0; i < 3; i++ {
+		if w := doRequest(r, "POST", "/api/orders", adminToken, order); w.Code != 201 {
+			t.Fatalf("admin order %d: status %d: %s", i+1, w.Code, w.Body)
+		}
+	}
+}
+
+func TestSymbolOrderThrottle(t *testing.T) {
+	s := newTestServer(t, map[string]string{
+		"SYMBOL_ORDER_LIMIT":  "2",
+		"SYMBOL_ORDER_WINDOW": "300ms",
+		"SETTLEMENT_DELAY":    "1h",
+	})
+	user := createTestUser(t, s, "trader", roleUser)
+	other := createTestUser(t, s, "other", roleUser)
+	admin := seededAdmin(t, s)
+	order := func(userID uint, isAdmin bool, symbol string) (Order, *orderError) {
+		return s.placeOrder(userID, isAdmin, OrderRequest{Symbol: symbol, Side: sideBuy, Quantity: 1})
+	}
+
+	var placed []Order
+	for i := 0; i < 2; i++ {
+		o, err := order(user.ID, false, "AAPL")
+		if err != nil {
+			t.Fatalf("AAPL order %d: %s", i+1, err.Message)
+		}
+		placed = append(placed, o)
+	}
+	_, err := order(user.ID, false, "AAPL")
+	if err == nil || err.Status != 429 || err.Code != orderCodeSymbolThrottled || err.RetryAt.IsZero() {
+		t.Fatalf("third AAPL order = %+v, want 429 %s with a retry time", err, orderCodeSymbolThrottled)
+	}
+
+	// Other symbols, other users and admins aren't affected
+	if _, err := order(user.ID, false, "TSLA"); err != nil {
+		t.Errorf("TSLA order: %s", err.Message)
+	}
+	if _, err := order(other.ID, false, "AAPL"); err != nil {
+		t.Errorf("another user's AAPL order: %s", err.Message)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := order(admin.ID, true, "AAPL"); err != nil {
+			t.Errorf("admin AAPL order %d: %s", i+1, err.Message)
+		}
+	}
+
+	// Cancels aren't throttled, and don't give back the order's slot
+	for _, o := range placed {
+		if _, err := s.cancelOrder(o.ID, user.ID, user.ID, nil); err != nil {
+			t.Errorf("cancelling order %d: %s", o.Number, err.Message)
+		}
+	}
+	if _, err := order(user.ID, false, "AAPL"); err == nil || err.Code != orderCodeSymbolThrottled {
+		t.Errorf("AAPL order after cancelling = %v, want %s", err, orderCodeSymbolThrottled)
+	}
+
+	// The window passes
+	time.Sleep(time.Until(err.RetryAt) + 10*time.Millisecond)
+	if _, err := order(user.ID, false, "AAPL"); err != nil {
+		t.Errorf("AAPL order after the window: %s", err.Message)
+	}
+}
+
+func TestMaxOpenOrders(t *testing.T) {
+	s := newTestServer(t, map[string]string{"SETTLEMENT_DELAY": "1h", "MAX_OPEN_ORDERS": "2", "MAX_ORDERS_PER_DAY": "4"})
+	user := createTestUser(t, s, "trader", roleUser)
+	order := OrderRequest{Symbol: "AAPL", Side: sideBuy, Quantity: 1}
+
+	first := placePendingOrder(t, s, user.ID)
+	placePendingOrder(t, s, user.ID)
+	_, err := s.placeOrder(user.ID, false, order)
+	if err == nil || err.Status != 429 || err.Code != orderCodeOpenLimit {
+		t.Fatalf("order past the cap: %v, want 429 %s", err, orderCodeOpenLimit)
+	}
+
+	// Cancelling frees a slot, and the refused order didn't count against
+	// the daily limit
+	version := first.Version
+	if _, err := s.cancelOrder(first.ID, user.ID, user.ID, &version); err != nil {
+		t.Fatalf("cancel: %s", err.Message)
+	}
+	placePendingOrder(t, s, user.ID)
+	if _, err := s.placeOrder(user.ID, false, order); err == nil || err.Code != orderCodeOpenLimit {
+		t.Fatalf("order past the cap again: %v, want %s", err, orderCodeOpenLimit)
+	}
+
+	// So does settling
+	if _, err := s.settleOrders(time.Now().Add(2 * time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	placePendingOrder(t, s, user.ID)
+
+	// Admins aren't capped
+	admin := createTestUser(t, s, "ops", roleAdmin)
+	for i := 0; i < 3; i++ {
+		if _, err := s.placeOrder(admin.ID, true, order); err != nil {
+			t.Fatalf("admin order %d: %s", i+1, err.Message)
+		}
+	}
+}
+
+func TestMaxOpenOrdersPreview(t *testing.T) {
+	s, r := newTestRouter(t, map[string]string{"SETTLEMENT_DELAY": "1h", "MAX_OPEN_ORDERS": "1"})
+	user := createTestUser(t, s, "trader", roleUser)
+	_, token := createTestSession(t, s, user)
+	order := `{"symbol":"AAPL","side":"buy","quantity":1}`
+
+	if w := doRequest(r, "POST", "/api/orders", token, order); w.Code != 201 {
+		t.Fatalf("first order: status %d: %s", w.Code, w.Body)
+	}
+	w := doRequest(r, "POST", "/api/orders", token, order)
+	if w.Code != 429 || !jsonHasCode(w.Body.Bytes(), orderCodeOpenLimit) {
+		t.Fatalf("second order: status %d: %s, want 429 %s", w.Code, w.Body, orderCodeOpenLimit)
+	}
+
+	w = doRequest(r, "POST", "/api/orders/preview", token, order)
+	var preview OrderPreview
+	if err := json.Unmarshal(w.Body.Bytes(), &preview); w.Code != 200 || err != nil {
+		t.Fatalf("preview: status %d: %s", w.Code, w.Body)
+	}
+	if preview.WouldSucceed || preview.ReasonCode != orderCodeOpenLimit {
+		t.Fatalf("preview = %+v, want it refused with %s", preview, orderCodeOpenLimit)
+	}
+}
+
+func TestMaxOpenOrdersWithoutSettlementDelay(t *testing.T) {
+	s := newTestServer(t, map[string]string{"MAX_OPEN_ORDERS": "1"})
+	user := createTestUser(t, s, "trader", roleUser)
+
+	// Orders fill straight away, so none are ever open
+	for i := 0; i < 3; i++ {
+		if _, err := s.placeOrder(user.ID, false, OrderRequest{Symbol: "AAPL", Side: sideBuy, Quantity: 1}); err != nil {
+			t.Fatalf("order %d: %s", i+1, err.Message)
+		}
+	}
+}
+
+func TestMaxOpenOrdersConfig(t *testing.T) {
+	for _, value := range []string{"-1", "many"} {
+		t.Run(value, func(t *testing.T) {
+			t.Setenv("MAX_OPEN_ORDERS", value)
+			if _, err := loadConfig(); err == nil {
+				t.Fatalf("MAX_OPEN_ORDERS=%s accepted", value)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// resetSimulation restores every stock and the market index to their seed
+// values. With clearOrders it also deletes all orders, which are the only
+// record of trades and holdings, inside a single transaction.
+func (s *Server) resetSimulation(clearOrders bool) error {
+	if clearOrders {
+		err := s.db.Transaction(func(tx *gorm.DB) error {
+			return tx.Session(&gorm.Session{AllowGlobalUpdate: true}).Delete(&Order{}).Error
+		})
+		if err != nil {
+			return err
+		}
+		s.orderLimiter.reset()
+		s.positionsCache.clear()
+	}
+
+	s.stocksLock.Lock()
+	now := time.Now()
+	for _, seed := range s.seedStocks {
+		stock := seed
+		stock.LastUpdate = now
+		stock.updateQuote()
+		*s.stocks[stock.Symbol] = stock
+	}
+	s.market = newMarketIndex()
+	s.snapshot = nil
+	s.stocksLock.Unlock()
+	s.candles.reset()
+
+	return nil
+}
+
+// resetDemo returns the simulation to its starting state for demos and QA.
+// It requires ?confirm=true, and ?clear_orders=true also wipes all orders.
+func (s *Server) resetDemo(c *gin.Context) {
+	if c.Query("confirm") != "true" {
+		c.JSON(400, gin.H{"error": "Reset requires confirm=true"})
+		return
+	}
+	clearOrders := c.Query("clear_orders") == "true"
+
+	if err := s.resetSimulation(clearOrders); err != nil {
+		c.JSON(500, gin.H{"error": "Failed to reset simulation"})
+		return
+	}
+
+	userID, _ := c.Get("user_id")
+	log.Printf("Simulation reset by user %v (orders cleared: %t)", userID, clearOrders)
+
+	s.broadcastPrices()
+	c.JSON(200, gin.H{"reset": true, "orders_cleared": clearOrders})
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// stockPrices returns every stock's current price
+func stockPrices(s *Server) map[string]float64 {
+	s.stocksLock.RLock()
+	defer s.stocksLock.RUnlock()
+	prices := make(map[string]float64, len(s.stocks))
+	for symbol, stock := range s.stocks {
+		prices[symbol] = stock.Price
+	}
+	return prices
+}
+
+// countOrders returns how many orders are stored, archived or not
+func countOrders(t *testing.T, s *Server) int64 {
+	t.Helper()
+	var count int64
+	if err := s.db.Model(&Order{}).Count(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func TestResetDemo(t *testing.T) {
+	quietLogs(t)
+	s, r := newTestRouter(t, map[string]string{"MAX_ORDERS_PER_DAY": "1"})
+	_, adminToken := createTestSession(t, s, seededAdmin(t, s))
+	user := createTestUser(t, s, "trader", roleUser)
+	seed := stockPrices(s)
+
+	moved := func() {
+		rng := rand.New(rand.NewSource(1))
+		for i := 0; i < 20; i++ {
+			s.stepPrices(rng)
+		}
+	}
+	moved()
+	if _, err := s.placeOrder(user.ID, false, OrderRequest{Symbol: "AAPL", Side: sideBuy, Quantity: 1}); err != nil {
+		t.Fatalf("placing: %s", err.Message)
+	}
+	before := stockPrices(s)
+
+	// Nothing happens without confirm=true
+	if w := doRequest(r, "POST", "/api/admin/reset", adminToken, ""); w.Code != 400 {
+		t.Fatalf("without confirm: status %d: %s", w.Code, w.Body)
+	}
+	if got := stockPrices(s); got["AAPL"] != before["AAPL"] {
+		t.Fatal("prices reset without confirm=true")
+	}
+
+	// Prices go back to their seeds, and are broadcast, but orders stay
+	conn := dialTestWebSocket(t, newTestWebSocketServer(t, s), "")
+	if w := doRequest(r, "POST", "/api/admin/reset?confirm=true", adminToken, ""); w.Code != 200 {
+		t.Fatalf("reset: status %d: %s", w.Code, w.Body)
+	}
+	for symbol, price := range stockPrices(s) {
+		if price != seed[symbol] {
+			t.Errorf("%s = %v after reset, want seed %v", symbol, price, seed[symbol])
+		}
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var broadcast []Stock
+	if err := conn.ReadJSON(&broadcast); err != nil {
+		t.Fatalf("reading broadcast: %v", err)
+	}
+	for _, stock := range broadcast {
+		if stock.Price != seed[stock.Symbol] {
+			t.Errorf("broadcast %s = %v, want seed %v", stock.Symbol, stock.Price, seed[stock.Symbol])
+		}
+	}
+	if got := countOrders(t, s); got != 1 {
+		t.Fatalf("%d orders after a plain reset, want 1", got)
+	}
+
+	// clear_orders empties the orders table and the daily counts
+	moved()
+	w := doRequest(r, "POST", "/api/admin/reset?confirm=true&clear_orders=true", adminToken, "")
+	var body struct {
+		OrdersCleared bool `json:"orders_cleared"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); w.Code != 200 || err != nil || !body.OrdersCleared {
+		t.Fatalf("reset with clear_orders: status %d: %s", w.Code, w.Body)
+	}
+	if got := countOrders(t, s); got != 0 {
+		t.Errorf("%d orders after clearing, want 0", got)
+	}
+	if netPosition(t, s, user.ID, "AAPL") != 0 {
+		t.Error("position survived clearing the orders")
+	}
+	if _, err := s.placeOrder(user.ID, false, OrderRequest{Symbol: "AAPL", Side: sideBuy, Quantity: 1}); err != nil {
+		t.Errorf("order after clearing: %s", err.Message)
+	}
+}
+
+func TestResetDemoDisabledInProduction(t *testing.T) {
+	s, r := newTestRouter(t, map[string]string{"APP_ENV": "production"})
+	_, adminToken := createTestSession(t, s, seededAdmin(t, s))
+	if w := doRequest(r, "POST", "/api/admin/reset?confirm=true", adminToken, ""); w.Code != 404 {
+		t.Fatalf("status %d, want 404", w.Code)
+	}
+}
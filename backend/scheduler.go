@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"log"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// job is a named piece of background work run on a fixed interval
+type job struct {
+	name     string
+	interval time.Duration
+
+	// runAtStart runs the job once straight away instead of waiting for
+	// the first interval to pass
+	runAtStart bool
+
+	run func(ctx context.Context)
+}
+
+// scheduler runs registered jobs in their own goroutines until it is
+// stopped. A job that panics is logged and runs again on its next tick.
+type scheduler struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	jobs   []job
+	wg     sync.WaitGroup
+}
+
+// newScheduler returns a scheduler with no jobs
+func newScheduler() *scheduler {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &scheduler{ctx: ctx, cancel: cancel}
+}
+
+// register adds a job; it must be called before start
+func (sc *scheduler) register(j job) {
+	sc.jobs = append(sc.jobs, j)
+}
+
+// start launches every registered job
+func (sc *scheduler) start() {
+	for _, j := range sc.jobs {
+		log.Printf("Starting job %s every %s", j.name, j.interval)
+		sc.wg.Add(1)
+		go sc.loop(j)
+	}
+}
+
+// stop cancels the jobs' context and waits for any running job to return
+func (sc *scheduler) stop() {
+	sc.cancel()
+	sc.wg.Wait()
+}
+
+// loop runs j on its interval until the scheduler is stopped
+func (sc *scheduler) loop(j job) {
+	defer sc.wg.Done()
+
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	if j.runAtStart {
+		sc.runOnce(j)
+	}
+	for {
+		select {
+		case <-sc.ctx.Done():
+			return
+		case <-ticker.C:
+			sc.runOnce(j)
+		}
+	}
+}
+
+// runOnce runs j, recovering and logging a panic so one bad run doesn't
+// take down the server or stop the job
+func (sc *scheduler) runOnce(j job) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Job %s panicked: %v\n%s", j.name, r, debug.Stack())
+		}
+	}()
+	j.run(sc.ctx)
+}
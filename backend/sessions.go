@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// sessionTouchInterval limits how often a session's last-seen time is
+// written, so busy clients don't cost a database write per request
+const sessionTouchInterval = time.Minute
+
+// sessionCleanupInterval is how often expired sessions are deleted
+const sessionCleanupInterval = time.Hour
+
+// errSessionRevoked is returned for a token whose session was revoked
+var errSessionRevoked = errors.New("session revoked")
+
+// errSessionLookup is returned when a token's session can't be checked
+// because the database failed; the token itself may well be fine
+var errSessionLookup = errors.New("session lookup failed")
+
+// Session is a signed-in device. Each token issued at login or signup
+// carries its session's TokenID as the jti claim; deleting the session
+// revokes the token.
+type Session struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	UserID    uint      `gorm:"not null;index" json:"-"`
+	TokenID   string    `gorm:"not null;uniqueIndex" json:"-"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	IssuedAt  time.Time `json:"issued_at"`
+	LastSeen  time.Time `json:"last_seen"`
+	ExpiresAt time.Time `gorm:"index" json:"expires_at"`
+
+	// ImpersonatedBy is the admin who started the session to act as the
+	// user; 0 for the user's own sign-ins
+	ImpersonatedBy uint `gorm:"not null;default:0" json:"impersonated_by,omitempty"`
+
+	// Current marks the session the request was made with
+	Current bool `gorm:"-" json:"current"`
+}
+
+// newSession prepares a session for userID started by this request, lasting
+// ttl, with a fresh token id
+func newSession(c *gin.Context, userID uint, ttl time.Duration) (Session, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return Session{}, err
+	}
+
+	now := time.Now()
+	return Session{
+		UserID:    userID,
+		TokenID:   hex.EncodeToString(b),
+		IP:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+		IssuedAt:  now,
+		LastSeen:  now,
+		ExpiresAt: now.Add(ttl),
+	}, nil
+}
+
+// startSession records a new session for user and issues its token
+func (s *Server) startSession(c *gin.Context, user User) (string, error) {
+	session, err := newSession(c, user.ID, tokenTTL)
+	if err != nil {
+		return "", err
+	}
+	if err := s.db.Create(&session).Error; err != nil {
+		return "", err
+	}
+	return issueToken(user, session)
+}
+
+// authenticate validates a token and, for tokens tied to a session, checks
+// the session hasn't been revoked. Tokens issued before sessions were
+// tracked carry no jti and stay valid until they expire.
+func (s *Server) authenticate(tokenString string) (tokenClaims, error) {
+	claims, err := parseToken(tokenString)
+	if err != nil || claims.TokenID == "" {
+		return claims, err
+	}
+
+	var sessions []Session
+	if err := s.db.Where("token_id = ? AND user_id = ?", claims.TokenID, claims.UserID).Limit(1).Find(&sessions).Error; err != nil {
+		return tokenClaims{}, fmt.Errorf("%w: %v", errSessionLookup, err)
+	}
+	if len(sessions) == 0 {
+		return tokenClaims{}, errSessionRevoked
+	}
+
+	if session := sessions[0]; time.Since(session.LastSeen) > sessionTouchInterval {
+		if err := s.db.Model(&session).Update("last_seen", time.Now()).Error; err != nil {
+			log.Printf("Failed to update last seen for session %d: %v", session.ID, err)
+		}
+	}
+	return claims, nil
+}
+
+// endSession deletes the session behind a token, if it has one, and closes
+// its WebSocket connections
+func (s *Server) endSession(tokenString string) {
+	claims, err := parseToken(tokenString)
+	if err != nil || claims.TokenID == "" {
+		return
+	}
+	if err := s.db.Where("token_id = ?", claims.TokenID).Delete(&Session{}).Error; err != nil {
+		log.Printf("Failed to end session: %v", err)
+		return
+	}
+	s.disconnectSession(claims.TokenID)
+}
+
+// writeSessionLookupError answers a request whose session couldn't be
+// checked, as requireDatabase does while the database is down
+func writeSessionLookupError(c *gin.Context) {
+	c.Header("Retry-After", strconv.Itoa(dbDownRetryAfter))
+	c.JSON(503, gin.H{"error": dbDownMessage})
+}
+
+// sessionCleanupJob deletes sessions whose tokens have expired
+func (s *Server) sessionCleanupJob() job {
+	return job{
+		name:       "expire-sessions",
+		interval:   sessionCleanupInterval,
+		runAtStart: true,
+		run: func(context.Context) {
+			res := s.db.Where("expires_at < ?", time.Now()).Delete(&Session{})
+			if res.Error != nil {
+				log.Printf("Error deleting expired sessions: %v", res.Error)
+			} else if res.RowsAffected > 0 {
+				log.Printf("Deleted %d expired sessions", res.RowsAffected)
+			}
+		},
+	}
+}
+
+// getSessions lists the authenticated user's active sessions, most recently
+// seen first
+func (s *Server) getSessions(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(401, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var sessions []Session
+	if err := s.db.Where("user_id = ? AND expires_at > ?", userID, time.Now()).Order("last_seen DESC").Find(&sessions).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to fetch sessions"})
+		return
+	}
+
+	current := c.GetString("token_id")
+	for i := range sessions {
+		sessions[i].Current = current != "" && sessions[i].TokenID == current
+	}
+	c.JSON(200, sessions)
+}
+
+// revokeSession signs one of the authenticated user's sessions out, closing
+// its WebSocket connections. Other users' sessions are reported as not
+// found.
+func (s *Server) revokeSession(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(401, gin.H{"error": "Unauthorized"})
+		return
+	}
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil || id == 0 {
+		c.JSON(400, gin.H{"error": "Invalid session id"})
+		return
+	}
+
+	var sessions []Session
+	if err := s.db.Where("id = ? AND user_id = ?", id, userID).Limit(1).Find(&sessions).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to revoke session"})
+		return
+	}
+	if len(sessions) == 0 {
+		c.JSON(404, gin.H{"error": "Session not found"})
+		return
+	}
+	if err := s.db.Delete(&sessions[0]).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to revoke session"})
+		return
+	}
+	s.disconnectSession(sessions[0].TokenID)
+
+	c.Status(204)
+}
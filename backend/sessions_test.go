@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// createTestSession starts a session for the user and issues its token
+func createTestSession(t *testing.T, s *Server, user User) (Session, string) {
+	t.Helper()
+	now := time.Now()
+	session := Session{UserID: user.ID, TokenID: user.Username + "-session", IssuedAt: now, LastSeen: now, ExpiresAt: now.Add(tokenTTL)}
+	if err := s.db.Create(&session).Error; err != nil {
+		t.Fatalf("creating session: %v", err)
+	}
+	token, err := issueToken(user, session)
+	if err != nil {
+		t.Fatalf("issueToken: %v", err)
+	}
+	return session, token
+}
+
+func TestSessionLookupFailureIsNotATokenError(t *testing.T) {
+	s := newTestServer(t, nil)
+	user := createTestUser(t, s, "trader", roleUser)
+	_, token := createTestSession(t, s, user)
+
+	r := gin.New()
+	r.GET("/check", s.authMiddleware(), func(c *gin.Context) { c.Status(204) })
+	if w := doRequest(r, "GET", "/check", token, ""); w.Code != 204 {
+		t.Fatalf("status = %d, want 204", w.Code)
+	}
+
+	sqlDB, err := s.db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.Close()
+
+	if _, err := s.authenticate(token); !errors.Is(err, errSessionLookup) {
+		t.Fatalf("authenticate = %v, want errSessionLookup", err)
+	}
+	w := doRequest(r, "GET", "/check", token, "")
+	if w.Code != 503 {
+		t.Fatalf("status = %d, want 503", w.Code)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("missing Retry-After")
+	}
+}
+
+func TestEndSessionClosesItsWebSockets(t *testing.T) {
+	s := newTestServer(t, nil)
+	user := createTestUser(t, s, "trader", roleUser)
+	_, token := createTestSession(t, s, user)
+	otherUser := createTestUser(t, s, "other", roleUser)
+	_, other := createTestSession(t, s, otherUser)
+
+	srv := newTestWebSocketServer(t, s)
+	conn := dialTestWebSocket(t, srv, token)
+	kept := dialTestWebSocket(t, srv, other)
+
+	s.endSession(token)
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			break
+		}
+	}
+	if countClients(s) != 1 {
+		t.Fatalf("%d clients connected, want 1", countClients(s))
+	}
+
+	// The other user's connection still gets messages
+	s.notifyUser(otherUser.ID, gin.H{"type": "ping"})
+	kept.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, _, err := kept.ReadMessage(); err != nil {
+		t.Fatalf("other connection: %v", err)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// orderFilledMessage tells an order's owner over the WebSocket that a
+// pending order has settled
+type orderFilledMessage struct {
+	Type  string `json:"type"` // "order_filled"
+	Order Order  `json:"order"`
+}
+
+// settlementJob fills pending orders once they are older than the
+// settlement delay. It checks at least once a second, so orders settle
+// within about a second of becoming due. It runs even without a delay, and
+// straight away at startup, so orders left pending when the delay is
+// lowered or removed still fill.
+func (s *Server) settlementJob() job {
+	interval := time.Second
+	if s.settlementDelay > 0 {
+		interval = min(s.settlementDelay, interval)
+	}
+	return job{
+		name:       "settle-orders",
+		interval:   interval,
+		runAtStart: true,
+		run: func(context.Context) {
+			if n, err := s.settleOrders(time.Now()); err != nil {
+				log.Printf("Error settling orders: %v", err)
+			} else if n > 0 {
+				log.Printf("Settled %d orders", n)
+			}
+		},
+	}
+}
+
+// settleOrders moves every pending order placed before now minus the
+// settlement delay to filled, notifying each owner, and returns how many
+// orders it filled. Orders cancelled in the meantime are left alone.
+func (s *Server) settleOrders(now time.Time) (int, error) {
+	var due []Order
+	err := s.db.Where("status = ? AND timestamp <= ?", orderStatusPending, now.Add(-s.settlementDelay)).
+		Order("timestamp ASC").
+		Find(&due).Error
+	if err != nil {
+		return 0, err
+	}
+
+	settled := 0
+	for _, order := range due {
+		// The compare-and-swap leaves an order cancelled since it was read
+		// cancelled
+		moved, err := transitionOrder(s.db, &order, orderStatusFilled)
+		if err != nil {
+			return settled, err
+		}
+		if !moved {
+			continue
+		}
+		settled++
+		s.positionsCache.invalidate(order.UserID)
+
+		s.notifyUser(order.UserID, orderFilledMessage{Type: "order_filled", Order: order})
+		s.notifyFill(order)
+		s.notifyOrderFilled(order)
+	}
+	return settled, nil
+}
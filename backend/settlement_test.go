@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// runJob runs j on a scheduler until the test ends
+func runJob(t *testing.T, j job) {
+	t.Helper()
+	jobs := newScheduler()
+	jobs.register(j)
+	jobs.start()
+	t.Cleanup(jobs.stop)
+}
+
+// fetchOrder gets one of the user's orders through the API
+func fetchOrder(t *testing.T, h http.Handler, token string, number uint) Order {
+	t.Helper()
+	w := doRequest(h, "GET", "/api/orders/"+strconv.FormatUint(uint64(number), 10), token, "")
+	var order Order
+	if err := json.Unmarshal(w.Body.Bytes(), &order); w.Code != 200 || err != nil {
+		t.Fatalf("fetching order: status %d: %s", w.Code, w.Body)
+	}
+	return order
+}
+
+// waitForStatus polls the order until it has status
+func waitForStatus(t *testing.T, h http.Handler, token string, number uint, status string) Order {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		order := fetchOrder(t, h, token, number)
+		if order.Status == status {
+			return order
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("order still %s, want %s", order.Status, status)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestPendingOrderSettles(t *testing.T) {
+	s, r := newTestRouter(t, map[string]string{"SETTLEMENT_DELAY": "200ms"})
+	user := createTestUser(t, s, "trader", roleUser)
+	_, token := createTestSession(t, s, user)
+
+	w := doRequest(r, "POST", "/api/orders", token, `{"symbol":"AAPL","side":"buy","quantity":1}`)
+	var placed Order
+	if err := json.Unmarshal(w.Body.Bytes(), &placed); w.Code != 201 || err != nil {
+		t.Fatalf("placing order: status %d: %s", w.Code, w.Body)
+	}
+	if placed.Status != orderStatusPending {
+		t.Fatalf("new order is %s, want %s", placed.Status, orderStatusPending)
+	}
+
+	// Not yet due: settling now leaves it pending
+	if n, err := s.settleOrders(time.Now()); err != nil || n != 0 {
+		t.Fatalf("settleOrders before the delay = %d, %v", n, err)
+	}
+	if order := fetchOrder(t, r, token, placed.Number); order.Status != orderStatusPending {
+		t.Fatalf("order is %s before the delay, want %s", order.Status, orderStatusPending)
+	}
+
+	runJob(t, s.settlementJob())
+	filled := waitForStatus(t, r, token, placed.Number, orderStatusFilled)
+	if filled.Version != placed.Version+1 {
+		t.Fatalf("version = %d, want %d", filled.Version, placed.Version+1)
+	}
+	if net := netPosition(t, s, user.ID, "AAPL"); net != 1 {
+		t.Fatalf("net = %g, want 1", net)
+	}
+}
+
+func TestLeftoverPendingOrdersSettleWithoutDelay(t *testing.T) {
+	s, r := newTestRouter(t, nil)
+	user := createTestUser(t, s, "trader", roleUser)
+	_, token := createTestSession(t, s, user)
+
+	// Left pending by a run that had a settlement delay
+	order := Order{UserID: user.ID, Number: 1, Symbol: "AAPL", Side: sideBuy, Quantity: 1, Price: 100, Timestamp: time.Now().Add(-time.Hour), Status: orderStatusPending, Version: 1}
+	if err := s.db.Create(&order).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if j := s.settlementJob(); !j.runAtStart || j.interval <= 0 {
+		t.Fatalf("settlement job runs every %s, at start %v", j.interval, j.runAtStart)
+	}
+	runJob(t, s.settlementJob())
+	waitForStatus(t, r, token, order.Number, orderStatusFilled)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/gin-gonic/gin"
+)
+
+// simulatorNotice is broadcast to WebSocket clients when the price
+// simulation is paused or resumed
+type simulatorNotice struct {
+	Type    string `json:"type"` // "simulator"
+	Running bool   `json:"running"`
+}
+
+// SimulatorRequest pauses or resumes the price simulation
+type SimulatorRequest struct {
+	Running *bool `json:"running"`
+}
+
+// getSimulator reports whether simulated prices are moving
+func (s *Server) getSimulator(c *gin.Context) {
+	c.JSON(200, gin.H{"running": !s.simulatorPaused.Load()})
+}
+
+// setSimulator pauses or resumes the price simulation and notifies clients.
+// While paused the last prices keep being served.
+func (s *Server) setSimulator(c *gin.Context) {
+	var req SimulatorRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Running == nil {
+		c.JSON(400, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	running := *req.Running
+	if s.simulatorPaused.Swap(!running) != !running {
+		userID, _ := c.Get("user_id")
+		log.Printf("Price simulation running set to %t by user %v", running, userID)
+
+		if msg, err := json.Marshal(simulatorNotice{Type: "simulator", Running: running}); err == nil {
+			s.broadcast(msg)
+		}
+	}
+
+	c.JSON(200, gin.H{"running": running})
+}
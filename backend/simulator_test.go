@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestSimulatorPause(t *testing.T) {
+	quietLogs(t)
+	s, r := newTestRouter(t, nil)
+	_, adminToken := createTestSession(t, s, seededAdmin(t, s))
+	conn := dialTestWebSocket(t, newTestWebSocketServer(t, s), "")
+	rng := rand.New(rand.NewSource(1))
+
+	// setRunning switches the simulator and reads the notice it broadcasts
+	setRunning := func(running bool) {
+		t.Helper()
+		body, _ := json.Marshal(SimulatorRequest{Running: &running})
+		if w := doRequest(r, "POST", "/api/admin/simulator", adminToken, string(body)); w.Code != 200 {
+			t.Fatalf("setting running to %t: status %d: %s", running, w.Code, w.Body)
+		}
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		var notice simulatorNotice
+		if err := conn.ReadJSON(&notice); err != nil || notice.Type != "simulator" || notice.Running != running {
+			t.Fatalf("notice = %+v, %v", notice, err)
+		}
+	}
+
+	setRunning(false)
+	frozen := stockPrices(s)
+	for i := 0; i < 20; i++ {
+		if s.stepPrices(rng) {
+			t.Fatal("stepPrices stepped while paused")
+		}
+	}
+	for symbol, price := range stockPrices(s) {
+		if price != frozen[symbol] {
+			t.Errorf("%s moved from %v to %v while paused", symbol, frozen[symbol], price)
+		}
+	}
+
+	// The last prices are still served, and new clients learn it's paused
+	if w := doRequest(r, "GET", "/api/prices", "", ""); w.Code != 200 {
+		t.Errorf("prices while paused: status %d", w.Code)
+	}
+	late := dialTestWebSocket(t, newTestWebSocketServer(t, s), "")
+	late.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var notice simulatorNotice
+	if err := late.ReadJSON(&notice); err != nil || notice.Type != "simulator" || notice.Running {
+		t.Errorf("notice on connecting = %+v, %v", notice, err)
+	}
+	w := doRequest(r, "GET", "/api/admin/simulator", adminToken, "")
+	var state struct {
+		Running bool `json:"running"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &state); w.Code != 200 || err != nil || state.Running {
+		t.Errorf("simulator state: status %d: %s", w.Code, w.Body)
+	}
+
+	setRunning(true)
+	if !s.stepPrices(rng) {
+		t.Fatal("stepPrices didn't step after resuming")
+	}
+
+	if w := doRequest(r, "POST", "/api/admin/simulator", adminToken, `{}`); w.Code != 400 {
+		t.Errorf("missing running: status %d, want 400", w.Code)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import "log"
+
+// priceSnapshot is the prices as they were after their last change, with
+// their encoding in every message format. It is built on first use after a
+// change and shared by every reader until the next one, so polling clients
+// and broadcasts don't copy and encode the same prices over and over.
+// Nothing in it may be modified.
+type priceSnapshot struct {
+	prices []Stock
+
+	// encoded holds the prices in each subprotocol's format; stocks.v1 is
+	// also the /api/prices response body. A format that failed to encode
+	// is missing.
+	encoded map[string][]byte
+}
+
+// currentPrices returns the snapshot of the current prices, building it if
+// prices changed since it was last built
+func (s *Server) currentPrices() *priceSnapshot {
+	s.stocksLock.RLock()
+	snapshot := s.snapshot
+	s.stocksLock.RUnlock()
+	if snapshot != nil {
+		return snapshot
+	}
+
+	s.stocksLock.Lock()
+	defer s.stocksLock.Unlock()
+
+	// Another reader may have built it while we waited for the lock
+	if s.snapshot != nil {
+		return s.snapshot
+	}
+
+	snapshot = &priceSnapshot{
+		prices:  make([]Stock, 0, len(s.stocks)),
+		encoded: make(map[string][]byte, len(knownWSProtocols)),
+	}
+	for _, stock := range s.stocks {
+		snapshot.prices = append(snapshot.prices, *stock)
+	}
+	for _, protocol := range knownWSProtocols {
+		msg, err := encodePrices(snapshot.prices, protocol)
+		if err != nil {
+			log.Printf("Error encoding prices: %v", err)
+			continue
+		}
+		snapshot.encoded[protocol] = msg
+	}
+	s.snapshot = snapshot
+	return snapshot
+}
@@ -0,0 +1,30 @@
+package main
+
+import "math"
+
+// defaultSpreadBps is the bid/ask spread for symbols without an explicit one
+const defaultSpreadBps = 10.0
+
+// updateQuote derives the bid and ask from the mid price and the symbol's
+// spread. The bid is rounded down and the ask up to the tick size, so the
+// quotes are always valid order prices and never cross the mid.
+func (st *Stock) updateQuote() {
+	half := st.Price * st.SpreadBps / 10000 / 2
+	st.Bid = floorToTick(st.Price-half, st.TickSize)
+	st.Ask = ceilToTick(st.Price+half, st.TickSize)
+}
+
+// floorToTick rounds price down to a multiple of tick
+func floorToTick(price, tick float64) float64 {
+	return trimFloat(math.Floor(price/tick+tickEpsilon) * tick)
+}
+
+// ceilToTick rounds price up to a multiple of tick
+func ceilToTick(price, tick float64) float64 {
+	return trimFloat(math.Ceil(price/tick-tickEpsilon) * tick)
+}
+
+// trimFloat trims the float noise introduced by multiplying by a tick
+func trimFloat(v float64) float64 {
+	return math.Round(v*1e8) / 1e8
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// sseHeartbeat is how often an idle event stream gets a comment line, which
+// keeps proxies from timing out the connection
+const sseHeartbeat = 15 * time.Second
+
+// parseSymbolFilter parses a comma-separated ?symbols= list. A nil map
+// means no filter; unknown symbols are an error.
+func (s *Server) parseSymbolFilter(raw string) (map[string]bool, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return nil, nil
+	}
+
+	filter := make(map[string]bool)
+	for _, symbol := range strings.Split(raw, ",") {
+		symbol = strings.ToUpper(strings.TrimSpace(s.resolveSymbol(symbol)))
+		if symbol == "" {
+			continue
+		}
+		if _, ok := s.lookupStock(symbol); !ok {
+			return nil, fmt.Errorf("unknown symbol %s", symbol)
+		}
+		filter[symbol] = true
+	}
+	return filter, nil
+}
+
+// filterPrices keeps only the stocks in filter; a nil filter keeps all
+func filterPrices(prices []Stock, filter map[string]bool) []Stock {
+	if filter == nil {
+		return prices
+	}
+	filtered := make([]Stock, 0, len(filter))
+	for _, stock := range prices {
+		if filter[stock.Symbol] {
+			filtered = append(filtered, stock)
+		}
+	}
+	return filtered
+}
+
+// filterSector keeps only the stocks in sector, which is matched
+// case-insensitively
+func filterSector(prices []Stock, sector string) []Stock {
+	filtered := make([]Stock, 0, len(prices))
+	for _, stock := range prices {
+		if strings.EqualFold(stock.Sector, sector) {
+			filtered = append(filtered, stock)
+		}
+	}
+	return filtered
+}
+
+// subscribePrices registers a channel that receives every price snapshot
+// broadcast to WebSocket clients
+func (s *Server) subscribePrices() chan []Stock {
+	// A single slot is enough: a newer snapshot supersedes an unread one
+	ch := make(chan []Stock, 1)
+	s.subscribersLock.Lock()
+	s.subscribers[ch] = struct{}{}
+	s.subscribersLock.Unlock()
+	return ch
+}
+
+// unsubscribePrices removes a channel added by subscribePrices
+func (s *Server) unsubscribePrices(ch chan []Stock) {
+	s.subscribersLock.Lock()
+	delete(s.subscribers, ch)
+	s.subscribersLock.Unlock()
+}
+
+// publishPrices hands a snapshot to every subscriber without blocking;
+// subscribers that haven't read the previous snapshot skip this one
+func (s *Server) publishPrices(prices []Stock) {
+	s.subscribersLock.Lock()
+	defer s.subscribersLock.Unlock()
+
+	for ch := range s.subscribers {
+		select {
+		case ch <- prices:
+		default:
+		}
+	}
+}
+
+// streamPrices streams price updates as Server-Sent Events for clients
+// that can't use WebSockets. ?symbols=AAPL,TSLA limits the stream.
+func (s *Server) streamPrices(c *gin.Context) {
+	filter, err := s.parseSymbolFilter(c.Query("symbols"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Unknown symbol in symbols filter"})
+		return
+	}
+
+	updates := s.subscribePrices()
+	defer s.unsubscribePrices(updates)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no") // Disable nginx response buffering
+	c.Status(200)
+
+	writeEvent := func(prices []Stock) bool {
+		data, err := json.Marshal(filterPrices(prices, filter))
+		if err != nil {
+			log.Printf("Error encoding prices: %v", err)
+			return false
+		}
+		if _, err := fmt.Fprintf(c.Writer, "event: prices\ndata: %s\n\n", data); err != nil {
+			return false
+		}
+		c.Writer.Flush()
+		return true
+	}
+
+	// Start with the current snapshot, like the WebSocket does
+	if !writeEvent(s.snapshotPrices()) {
+		return
+	}
+
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case prices := <-updates:
+			if !writeEvent(prices) {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(c.Writer, ": ping\n\n"); err != nil {
+				return
+			}
+			c.Writer.Flush()
+		}
+	}
+}
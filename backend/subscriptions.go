@@ -0,0 +1,171 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// subscriptionsMessage confirms a client's resulting subscription set
+type subscriptionsMessage struct {
+	Type    string   `json:"type"` // "subscriptions"
+	Symbols []string `json:"symbols"`
+	All     bool     `json:"all,omitempty"` // Receiving every symbol; Symbols is then empty
+}
+
+// SubscriptionCounts is how many WebSocket connections receive each symbol
+type SubscriptionCounts struct {
+	Connections int            `json:"connections"`
+	Unfiltered  int            `json:"unfiltered"` // Connections receiving every symbol
+	Symbols     map[string]int `json:"symbols"`
+}
+
+// symbolSet turns a list of symbols into a filter for filterPrices
+func symbolSet(symbols []string) map[string]bool {
+	set := make(map[string]bool, len(symbols))
+	for _, symbol := range symbols {
+		set[symbol] = true
+	}
+	return set
+}
+
+// subscribedSymbols returns the client's subscriptions in sorted order, or
+// nil if it receives every symbol
+func (c *Client) subscribedSymbols() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.subscriptions == nil {
+		return nil
+	}
+	symbols := make([]string, 0, len(c.subscriptions))
+	for symbol := range c.subscriptions {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+	return symbols
+}
+
+// subscribe adds symbols to the client's subscriptions. A client that
+// received every symbol is narrowed to just the ones it subscribes to.
+func (c *Client) subscribe(symbols []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.subscriptions == nil {
+		c.subscriptions = make(map[string]bool)
+	}
+	for _, symbol := range symbols {
+		c.subscriptions[symbol] = true
+	}
+}
+
+// unsubscribe removes symbols from the client's subscriptions; symbols it
+// isn't subscribed to are ignored. A client that received every symbol keeps
+// all of tracked except the ones it unsubscribes from.
+func (c *Client) unsubscribe(symbols, tracked []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.subscriptions == nil {
+		c.subscriptions = symbolSet(tracked)
+	}
+	for _, symbol := range symbols {
+		delete(c.subscriptions, symbol)
+	}
+}
+
+// normalizeSymbols upper-cases the requested symbols and checks they are
+// all tracked
+func (s *Server) normalizeSymbols(requested []string) ([]string, bool) {
+	symbols := make([]string, 0, len(requested))
+	for _, symbol := range requested {
+		symbol = strings.ToUpper(strings.TrimSpace(s.resolveSymbol(symbol)))
+		if _, ok := s.lookupStock(symbol); !ok {
+			return nil, false
+		}
+		symbols = append(symbols, symbol)
+	}
+	return symbols, true
+}
+
+// handleSubscribeMessage subscribes the client to more symbols and replies
+// with the resulting set
+func (s *Server) handleSubscribeMessage(client *Client, msg clientMessage) {
+	if len(msg.Symbols) == 0 {
+		s.queueMessage(client, errorMessage{Type: "error", Action: msg.Action, Error: "Missing symbols", Status: 400})
+		return
+	}
+	symbols, ok := s.normalizeSymbols(msg.Symbols)
+	if !ok {
+		s.queueMessage(client, errorMessage{Type: "error", Action: msg.Action, Error: "Unknown symbol", Status: 400})
+		return
+	}
+
+	client.subscribe(symbols)
+	s.sendSubscriptions(client)
+}
+
+// handleUnsubscribeMessage stops sending the client prices for symbols and
+// replies with the resulting set. Unsubscribing from every symbol leaves the
+// connection open without price updates.
+func (s *Server) handleUnsubscribeMessage(client *Client, msg clientMessage) {
+	if len(msg.Symbols) == 0 {
+		s.queueMessage(client, errorMessage{Type: "error", Action: msg.Action, Error: "Missing symbols", Status: 400})
+		return
+	}
+	symbols, ok := s.normalizeSymbols(msg.Symbols)
+	if !ok {
+		s.queueMessage(client, errorMessage{Type: "error", Action: msg.Action, Error: "Unknown symbol", Status: 400})
+		return
+	}
+
+	var tracked []string
+	for _, stock := range s.snapshotPrices() {
+		tracked = append(tracked, stock.Symbol)
+	}
+	client.unsubscribe(symbols, tracked)
+	s.sendSubscriptions(client)
+}
+
+// sendSubscriptions replies with the client's current subscription set
+func (s *Server) sendSubscriptions(client *Client) {
+	reply := subscriptionsMessage{Type: "subscriptions", Symbols: client.subscribedSymbols()}
+	if reply.Symbols == nil {
+		reply.Symbols = []string{}
+		reply.All = true
+	}
+	s.queueMessage(client, reply)
+}
+
+// getSubscriptions reports how many WebSocket connections receive each
+// symbol, to show which symbols are most watched
+func (s *Server) getSubscriptions(c *gin.Context) {
+	counts := SubscriptionCounts{Symbols: make(map[string]int)}
+	for _, stock := range s.snapshotPrices() {
+		counts.Symbols[stock.Symbol] = 0
+	}
+
+	// Only count under the lock; the response is encoded after releasing it
+	s.clientsLock.RLock()
+	for client := range s.clients {
+		counts.Connections++
+		symbols := client.subscribedSymbols()
+		if symbols == nil {
+			counts.Unfiltered++
+			continue
+		}
+		for _, symbol := range symbols {
+			counts.Symbols[symbol]++
+		}
+	}
+	s.clientsLock.RUnlock()
+
+	// Unfiltered connections receive every symbol too
+	for symbol := range counts.Symbols {
+		counts.Symbols[symbol] += counts.Unfiltered
+	}
+
+	c.JSON(200, counts)
+}
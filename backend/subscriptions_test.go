@@ -0,0 +1,128 @@
+package main
+
+import (
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// sendCommand writes a WebSocket command and decodes the reply into v
+func sendCommand(t *testing.T, conn *websocket.Conn, msg clientMessage, v interface{}) {
+	t.Helper()
+	if err := conn.WriteJSON(msg); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err := conn.ReadJSON(v); err != nil {
+		t.Fatalf("reading reply to %s: %v", msg.Action, err)
+	}
+}
+
+// subscriptionsAfter sends a command and returns the subscription set in
+// the reply
+func subscriptionsAfter(t *testing.T, conn *websocket.Conn, msg clientMessage) subscriptionsMessage {
+	t.Helper()
+	var reply subscriptionsMessage
+	sendCommand(t, conn, msg, &reply)
+	if reply.Type != "subscriptions" {
+		t.Fatalf("reply to %s has type %q", msg.Action, reply.Type)
+	}
+	return reply
+}
+
+func TestSubscribeUnsubscribeList(t *testing.T) {
+	s := newTestServer(t, nil)
+	conn := dialTestWebSocket(t, newTestWebSocketServer(t, s), "")
+
+	steps := []struct {
+		msg  clientMessage
+		want []string
+		all  bool
+	}{
+		{clientMessage{Action: "subscriptions"}, []string{}, true},
+		{clientMessage{Action: "subscribe", Symbols: []string{"tsla", "AAPL"}}, []string{"AAPL", "TSLA"}, false},
+		{clientMessage{Action: "subscribe", Symbols: []string{"AAPL"}}, []string{"AAPL", "TSLA"}, false},
+		// Unsubscribing from a symbol that isn't subscribed is a no-op
+		{clientMessage{Action: "unsubscribe", Symbols: []string{"AMZN"}}, []string{"AAPL", "TSLA"}, false},
+		{clientMessage{Action: "unsubscribe", Symbols: []string{"TSLA"}}, []string{"AAPL"}, false},
+		{clientMessage{Action: "subscriptions"}, []string{"AAPL"}, false},
+	}
+	for _, step := range steps {
+		got := subscriptionsAfter(t, conn, step.msg)
+		if !slices.Equal(got.Symbols, step.want) || got.All != step.all {
+			t.Fatalf("after %s %v: %+v, want %v (all %t)", step.msg.Action, step.msg.Symbols, got, step.want, step.all)
+		}
+	}
+
+	// Broadcasts follow the subscriptions
+	s.broadcastPrices()
+	var prices []Stock
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err := conn.ReadJSON(&prices); err != nil || len(prices) != 1 || prices[0].Symbol != "AAPL" {
+		t.Fatalf("broadcast = %+v, %v; want only AAPL", prices, err)
+	}
+
+	// With nothing left the connection stays open but gets no prices, so
+	// the next message is the reply to the following command
+	if got := subscriptionsAfter(t, conn, clientMessage{Action: "unsubscribe", Symbols: []string{"AAPL"}}); len(got.Symbols) != 0 || got.All {
+		t.Fatalf("after unsubscribing from everything: %+v", got)
+	}
+	s.broadcastPrices()
+	if got := subscriptionsAfter(t, conn, clientMessage{Action: "subscriptions"}); len(got.Symbols) != 0 || got.All {
+		t.Fatalf("subscriptions = %+v, want none", got)
+	}
+}
+
+func TestUnsubscribeFromAll(t *testing.T) {
+	s := newTestServer(t, nil)
+	conn := dialTestWebSocket(t, newTestWebSocketServer(t, s), "")
+
+	got := subscriptionsAfter(t, conn, clientMessage{Action: "unsubscribe", Symbols: []string{"TCS"}})
+	want := []string{"AAPL", "AMZN", "INFY", "TSLA"}
+	if !slices.Equal(got.Symbols, want) || got.All {
+		t.Fatalf("after unsubscribing from TCS: %+v, want %v", got, want)
+	}
+
+	for _, msg := range []clientMessage{
+		{Action: "unsubscribe", Symbols: []string{"ZZZZ"}},
+		{Action: "unsubscribe"},
+		{Action: "subscribe", Symbols: []string{"ZZZZ"}},
+	} {
+		var reply errorMessage
+		sendCommand(t, conn, msg, &reply)
+		if reply.Type != "error" || reply.Status != 400 || reply.Action != msg.Action {
+			t.Errorf("%s %v: reply %+v, want a 400 error", msg.Action, msg.Symbols, reply)
+		}
+	}
+}
+
+// Run with -race to check the per-connection subscriptions are guarded
+func TestSubscriptionsConcurrentAccess(t *testing.T) {
+	client := &Client{}
+	tracked := []string{"AAPL", "TSLA", "AMZN"}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			client.subscribe([]string{"AAPL"})
+		}()
+		go func() {
+			defer wg.Done()
+			client.unsubscribe([]string{"TSLA"}, tracked)
+		}()
+		go func() {
+			defer wg.Done()
+			client.subscribedSymbols()
+		}()
+	}
+	wg.Wait()
+
+	if got := client.subscribedSymbols(); !slices.Contains(got, "AAPL") || slices.Contains(got, "TSLA") {
+		t.Errorf("subscriptions = %v, want AAPL and not TSLA", got)
+	}
+}
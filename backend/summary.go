@@ -0,0 +1,129 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TradeSummary holds aggregate trading statistics for a single user
+type TradeSummary struct {
+	TotalOrders      int64   `json:"total_orders"`
+	BuyVolume        float64 `json:"buy_volume"`
+	SellVolume       float64 `json:"sell_volume"`
+	TotalNotional    float64 `json:"total_notional"` // In Currency, converted from each symbol's own
+	Currency         string  `json:"currency"`       // Always fxBaseCurrency
+	MostTradedSymbol string  `json:"most_traded_symbol,omitempty"`
+}
+
+// getTradeSummary returns aggregate trading stats for the authenticated
+// user's filled orders; pending and cancelled orders haven't traded
+func (s *Server) getTradeSummary(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(401, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	// Totals per side and symbol, computed in the database rather than by
+	// loading orders; symbols are quoted in different currencies, so their
+	// notionals are converted before they are added up
+	var sides []struct {
+		Side     string
+		Symbol   string
+		Orders   int64
+		Volume   float64
+		Notional float64
+	}
+	err := s.db.Model(&Order{}).
+		Select("side, symbol, COUNT(*) AS orders, COALESCE(SUM(quantity), 0) AS volume, COALESCE(SUM(quantity * price), 0) AS notional").
+		Where("user_id = ? AND status = ?", userID, orderStatusFilled).
+		Group("side, symbol").
+		Scan(&sides).Error
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to compute summary"})
+		return
+	}
+
+	summary := TradeSummary{Currency: fxBaseCurrency}
+	for _, row := range sides {
+		currency := fxBaseCurrency
+		if stock, ok := s.lookupStock(row.Symbol); ok {
+			currency = stock.Currency
+		}
+		summary.TotalOrders += row.Orders
+		notional := roundMoney(row.Notional, decimalsFor(row.Symbol), moneyRounding)
+		summary.TotalNotional += convertCurrency(notional, currency, fxBaseCurrency)
+		switch row.Side {
+		case sideBuy, sideCover:
+			summary.BuyVolume += row.Volume
+		case sideSell, sideShort:
+			summary.SellVolume += row.Volume
+		}
+	}
+	summary.TotalNotional = roundMoney(summary.TotalNotional, defaultPriceDecimals, moneyRounding)
+
+	// Most traded symbol by number of orders, ties broken alphabetically
+	var top []struct {
+		Symbol string
+		Orders int64
+	}
+	err = s.db.Model(&Order{}).
+		Select("symbol, COUNT(*) AS orders").
+		Where("user_id = ? AND status = ?", userID, orderStatusFilled).
+		Group("symbol").
+		Order("orders DESC, symbol ASC").
+		Limit(1).
+		Scan(&top).Error
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to compute summary"})
+		return
+	}
+	if len(top) > 0 {
+		summary.MostTradedSymbol = top[0].Symbol
+	}
+
+	c.JSON(200, summary)
+}
+
+// SymbolAggregate is a user's filled volume and average prices in a symbol.
+// Covers count as buys and shorts as sells, so a short position has a
+// negative NetQuantity.
+type SymbolAggregate struct {
+	Symbol       string  `json:"symbol"`
+	BuyQuantity  float64 `json:"buy_quantity"`
+	SellQuantity float64 `json:"sell_quantity"`
+	NetQuantity  float64 `json:"net_quantity"`
+	AvgBuyPrice  float64 `json:"avg_buy_price"`  // Volume-weighted; 0 without buys
+	AvgSellPrice float64 `json:"avg_sell_price"` // Volume-weighted; 0 without sells
+}
+
+// getOrdersBySymbol returns the authenticated user's filled orders aggregated
+// per symbol, a lightweight positions view that needs no live prices.
+// Archived fills still count; cancelled and unfilled orders are left out.
+func (s *Server) getOrdersBySymbol(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(401, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	aggregates, err := s.positions(userID.(uint))
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to aggregate orders"})
+		return
+	}
+
+	// ?symbol= narrows the view to one symbol, like the other order queries
+	if symbol := strings.ToUpper(strings.TrimSpace(s.resolveSymbol(c.Query("symbol")))); symbol != "" {
+		filtered := make([]SymbolAggregate, 0, 1)
+		for _, agg := range aggregates {
+			if agg.Symbol == symbol {
+				filtered = append(filtered, agg)
+			}
+		}
+		aggregates = filtered
+	}
+
+	c.JSON(200, aggregates)
+}
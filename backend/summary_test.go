@@ -0,0 +1,36 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestTradeSummary(t *testing.T) {
+	s, r := newTestRouter(t, map[string]string{"SETTLEMENT_DELAY": "1h"})
+	user := createTestUser(t, s, "trader", roleUser)
+	_, token := createTestSession(t, s, user)
+	createFilledOrder(t, s, user.ID, "AAPL", sideBuy, 2, 100)
+	createFilledOrder(t, s, user.ID, "AAPL", sideSell, 1, 110)
+	createFilledOrder(t, s, user.ID, "TCS", sideBuy, 1, 3450)
+	placePendingOrder(t, s, user.ID)
+
+	w := doRequest(r, "GET", "/api/me/summary", token, "")
+	var summary TradeSummary
+	if err := json.Unmarshal(w.Body.Bytes(), &summary); w.Code != 200 || err != nil {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+
+	// The pending order hasn't traded, and the INR notional of the TCS buy
+	// is converted before it is added to the USD ones
+	want := TradeSummary{
+		TotalOrders:      3,
+		BuyVolume:        3,
+		SellVolume:       1,
+		TotalNotional:    roundMoney(200+110+3450/fxRates["INR"], 2, moneyRounding),
+		Currency:         "USD",
+		MostTradedSymbol: "AAPL",
+	}
+	if summary != want {
+		t.Fatalf("summary = %+v, want %+v", summary, want)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// symbolsCacheControl lets clients and proxies cache the catalog for an hour
+const symbolsCacheControl = "public, max-age=3600"
+
+// SymbolInfo is the slow-changing metadata for a tracked symbol
+type SymbolInfo struct {
+	Symbol           string  `json:"symbol"`
+	Name             string  `json:"name"`
+	Sector           string  `json:"sector"`
+	Currency         string  `json:"currency"`
+	TickSize         float64 `json:"tick_size"`
+	PriceDecimals    int     `json:"price_decimals"`
+	QuantityDecimals int     `json:"quantity_decimals"`
+	MinNotional      float64 `json:"min_notional"` // Smallest order value, in Currency; 0 for none
+
+	// Aliases are the other names SYMBOL_ALIASES accepts for the symbol
+	Aliases []string `json:"aliases,omitempty"`
+}
+
+// resolveSymbol returns the tracked symbol an alias such as "apple" stands
+// for, ignoring case. Anything that isn't an alias is returned unchanged.
+func (s *Server) resolveSymbol(symbol string) string {
+	if canonical, ok := s.symbolAliases[strings.ToUpper(strings.TrimSpace(symbol))]; ok {
+		return canonical
+	}
+	return symbol
+}
+
+// symbolCatalog returns metadata for every tracked symbol, or only those in
+// sector when it isn't empty, sorted by symbol so the encoded catalog (and
+// its ETag) is stable
+func (s *Server) symbolCatalog(sector string) []SymbolInfo {
+	s.stocksLock.RLock()
+	catalog := make([]SymbolInfo, 0, len(s.stocks))
+	for _, stock := range s.stocks {
+		if sector != "" && !strings.EqualFold(stock.Sector, sector) {
+			continue
+		}
+		catalog = append(catalog, SymbolInfo{
+			Symbol:           stock.Symbol,
+			Name:             stock.Name,
+			Sector:           stock.Sector,
+			Currency:         stock.Currency,
+			TickSize:         stock.TickSize,
+			PriceDecimals:    stock.PriceDecimals,
+			QuantityDecimals: stock.QuantityDecimals,
+			MinNotional:      stock.MinNotional,
+		})
+	}
+	s.stocksLock.RUnlock()
+
+	sort.Slice(catalog, func(i, j int) bool {
+		return catalog[i].Symbol < catalog[j].Symbol
+	})
+	if len(s.symbolAliases) > 0 {
+		index := make(map[string]int, len(catalog))
+		for i, info := range catalog {
+			index[info.Symbol] = i
+		}
+		for alias, symbol := range s.symbolAliases {
+			if i, ok := index[symbol]; ok {
+				catalog[i].Aliases = append(catalog[i].Aliases, alias)
+			}
+		}
+		for i := range catalog {
+			sort.Strings(catalog[i].Aliases)
+		}
+	}
+	return catalog
+}
+
+// getSymbols returns the symbol catalog without live prices. Responses
+// carry an ETag so clients can revalidate cheaply with If-None-Match.
+// ?sector= narrows the catalog to one sector.
+func (s *Server) getSymbols(c *gin.Context) {
+	body, err := json.Marshal(s.symbolCatalog(strings.TrimSpace(c.Query("sector"))))
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to encode symbols"})
+		return
+	}
+
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+
+	c.Header("Cache-Control", symbolsCacheControl)
+	c.Header("ETag", etag)
+	if c.GetHeader("If-None-Match") == etag {
+		c.Status(304)
+		return
+	}
+
+	c.Data(200, "application/json; charset=utf-8", body)
+}
+
+// TradingRequest halts or resumes trading in a symbol
+type TradingRequest struct {
+	Tradable *bool `json:"tradable"`
+}
+
+// setTrading halts or resumes trading in a symbol, e.g. while news is
+// pending. Halted symbols keep their prices moving and published, and the
+// change reaches clients with the next price broadcast.
+func (s *Server) setTrading(c *gin.Context) {
+	var req TradingRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Tradable == nil {
+		c.JSON(400, gin.H{"error": "Invalid request"})
+		return
+	}
+	symbol := strings.ToUpper(c.Param("symbol"))
+	tradable := *req.Tradable
+
+	s.stocksLock.Lock()
+	stock, ok := s.stocks[symbol]
+	changed := ok && stock.Tradable != tradable
+	if changed {
+		stock.Tradable = tradable
+		s.snapshot = nil
+	}
+	s.stocksLock.Unlock()
+
+	if !ok {
+		c.JSON(404, gin.H{"error": "Unknown symbol", "code": orderCodeUnknownSymbol})
+		return
+	}
+	if changed {
+		userID, _ := c.Get("user_id")
+		log.Printf("Trading in %s set to %t by user %v", symbol, tradable, userID)
+		s.pricesChanged()
+	}
+
+	c.JSON(200, gin.H{"symbol": symbol, "tradable": tradable})
+}
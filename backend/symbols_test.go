@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestSymbolAliasesResolve(t *testing.T) {
+	s, r := newTestRouter(t, map[string]string{"SYMBOL_ALIASES": "APPLE:AAPL,TESLA:TSLA"})
+	user := createTestUser(t, s, "trader", roleUser)
+	_, token := createTestSession(t, s, user)
+
+	for _, alias := range []string{"APPLE", "apple", " Apple "} {
+		if got := s.resolveSymbol(alias); got != "AAPL" {
+			t.Errorf("resolveSymbol(%q) = %q, want AAPL", alias, got)
+		}
+	}
+	if got := s.resolveSymbol("MSFT"); got != "MSFT" {
+		t.Errorf("resolveSymbol(MSFT) = %q, want it unchanged", got)
+	}
+
+	// Orders placed by alias are stored under the tracked symbol
+	w := doRequest(r, "POST", "/api/orders", token, `{"symbol":"apple","side":"buy","quantity":1}`)
+	if w.Code != 201 {
+		t.Fatalf("order by alias: status %d: %s", w.Code, w.Body)
+	}
+	var order Order
+	if err := json.Unmarshal(w.Body.Bytes(), &order); err != nil {
+		t.Fatal(err)
+	}
+	if order.Symbol != "AAPL" {
+		t.Fatalf("order symbol = %q, want AAPL", order.Symbol)
+	}
+
+	// And counted by it
+	w = doRequest(r, "GET", "/api/orders/count?symbol=Apple", token, "")
+	var counts OrderCounts
+	if err := json.Unmarshal(w.Body.Bytes(), &counts); w.Code != 200 || err != nil || counts.Total != 1 {
+		t.Fatalf("order count by alias: status %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestUnknownSymbolStillRejected(t *testing.T) {
+	s, r := newTestRouter(t, map[string]string{"SYMBOL_ALIASES": "APPLE:AAPL"})
+	user := createTestUser(t, s, "trader", roleUser)
+	_, token := createTestSession(t, s, user)
+
+	for _, symbol := range []string{"BANANA", "APPL", ""} {
+		w := doRequest(r, "POST", "/api/orders", token, `{"symbol":"`+symbol+`","side":"buy","quantity":1}`)
+		if w.Code != 400 || !jsonHasCode(w.Body.Bytes(), orderCodeUnknownSymbol) {
+			t.Errorf("order for %q: status %d: %s", symbol, w.Code, w.Body)
+		}
+	}
+}
+
+// isTradable reads symbol's "tradable" flag from GET /api/prices
+func isTradable(t *testing.T, h http.Handler, symbol string) bool {
+	t.Helper()
+	w := doRequest(h, "GET", "/api/prices", "", "")
+	var prices []struct {
+		Symbol   string `json:"symbol"`
+		Tradable bool   `json:"tradable"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &prices); w.Code != 200 || err != nil {
+		t.Fatalf("prices: status %d: %s", w.Code, w.Body)
+	}
+	for _, price := range prices {
+		if price.Symbol == symbol {
+			return price.Tradable
+		}
+	}
+	t.Fatalf("%s missing from prices", symbol)
+	return false
+}
+
+func TestHaltingTrading(t *testing.T) {
+	quietLogs(t)
+	s, r := newTestRouter(t, nil)
+	_, adminToken := createTestSession(t, s, seededAdmin(t, s))
+	_, token := createTestSession(t, s, createTestUser(t, s, "trader", roleUser))
+	order := `{"symbol":"TSLA","side":"buy","quantity":1}`
+	setTradable := func(symbol, body string) *httptest.ResponseRecorder {
+		return doRequest(r, "POST", "/api/admin/symbols/"+symbol+"/trading", adminToken, body)
+	}
+
+	if !isTradable(t, r, "TSLA") {
+		t.Fatal("TSLA not tradable to begin with")
+	}
+	if w := setTradable("tsla", `{"tradable":false}`); w.Code != 200 {
+		t.Fatalf("halting: status %d: %s", w.Code, w.Body)
+	}
+	if w := doRequest(r, "POST", "/api/orders", token, order); w.Code != 409 || !jsonHasCode(w.Body.Bytes(), orderCodeNotTradable) {
+		t.Errorf("order while halted: status %d: %s", w.Code, w.Body)
+	}
+	if w := doRequest(r, "POST", "/api/orders", token, `{"symbol":"AAPL","side":"buy","quantity":1}`); w.Code != 201 {
+		t.Errorf("order in another symbol: status %d: %s", w.Code, w.Body)
+	}
+
+	// The halted price keeps moving and being published, flagged as halted
+	before := stockPrices(s)["TSLA"]
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 10 && stockPrices(s)["TSLA"] == before; i++ {
+		s.stepPrices(rng)
+	}
+	if stockPrices(s)["TSLA"] == before {
+		t.Error("halted price stopped moving")
+	}
+	if isTradable(t, r, "TSLA") {
+		t.Error("prices still show TSLA as tradable")
+	}
+
+	if w := setTradable("TSLA", `{"tradable":true}`); w.Code != 200 {
+		t.Fatalf("resuming: status %d: %s", w.Code, w.Body)
+	}
+	if !isTradable(t, r, "TSLA") {
+		t.Error("prices still show TSLA as halted")
+	}
+	if w := doRequest(r, "POST", "/api/orders", token, order); w.Code != 201 {
+		t.Errorf("order after resuming: status %d: %s", w.Code, w.Body)
+	}
+
+	if w := setTradable("ZZZZ", `{"tradable":false}`); w.Code != 404 {
+		t.Errorf("unknown symbol: status %d, want 404", w.Code)
+	}
+	if w := setTradable("TSLA", `{}`); w.Code != 400 {
+		t.Errorf("missing tradable: status %d, want 400", w.Code)
+	}
+}
+
+// listedSymbols returns the symbols listed by GET path, in order
+func listedSymbols(t *testing.T, h http.Handler, path string) string {
+	t.Helper()
+	w := doRequest(h, "GET", path, "", "")
+	var listed []struct {
+		Symbol string `json:"symbol"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &listed); w.Code != 200 || err != nil {
+		t.Fatalf("%s: status %d: %s", path, w.Code, w.Body)
+	}
+	symbols := make([]string, 0, len(listed))
+	for _, entry := range listed {
+		symbols = append(symbols, entry.Symbol)
+	}
+	sort.Strings(symbols)
+	return strings.Join(symbols, ",")
+}
+
+func TestFilterBySector(t *testing.T) {
+	_, r := newTestRouter(t, nil)
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"", "AAPL,AMZN,INFY,TCS,TSLA"},
+		{"?sector=Technology", "AAPL"},
+		{"?sector=it%20services", "INFY,TCS"},
+		{"?sector=%20Automotive%20", "TSLA"},
+		{"?sector=Utilities", ""},
+	}
+	for _, path := range []string{"/api/prices", "/api/symbols"} {
+		for _, tt := range tests {
+			if got := listedSymbols(t, r, path+tt.query); got != tt.want {
+				t.Errorf("%s%s lists %q, want %q", path, tt.query, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestSymbolNamesAndSectors(t *testing.T) {
+	_, r := newTestRouter(t, map[string]string{
+		"STOCK_NAMES":   "aapl: Apple ,TCS:TCS Ltd",
+		"STOCK_SECTORS": "TSLA:Consumer Discretionary",
+	})
+	w := doRequest(r, "GET", "/api/symbols", "", "")
+	var catalog []SymbolInfo
+	if err := json.Unmarshal(w.Body.Bytes(), &catalog); w.Code != 200 || err != nil {
+		t.Fatalf("symbols: status %d: %s", w.Code, w.Body)
+	}
+	want := map[string][2]string{
+		"AAPL": {"Apple", "Technology"},
+		"TCS":  {"TCS Ltd", "IT Services"},
+		"TSLA": {"Tesla Inc.", "Consumer Discretionary"},
+		"AMZN": {"Amazon.com Inc.", "Consumer Discretionary"},
+	}
+	for _, info := range catalog {
+		if w, ok := want[info.Symbol]; ok && (info.Name != w[0] || info.Sector != w[1]) {
+			t.Errorf("%s is %q in %q, want %q in %q", info.Symbol, info.Name, info.Sector, w[0], w[1])
+		}
+	}
+	if got := listedSymbols(t, r, "/api/prices?sector=Consumer%20Discretionary"); got != "AMZN,TSLA" {
+		t.Fatalf("overridden sector lists %q, want AMZN,TSLA", got)
+	}
+}
+
+func TestSymbolNamesConfig(t *testing.T) {
+	for _, env := range []map[string]string{
+		{"STOCK_NAMES": "AAPL"},
+		{"STOCK_NAMES": "AAPL:"},
+		{"STOCK_NAMES": "MSFT:Microsoft"},
+		{"STOCK_NAMES": "AAPL:Apple,aapl:Apple Inc."},
+		{"STOCK_SECTORS": "TSLA: "},
+		{"STOCK_SECTORS": ":Technology"},
+	} {
+		for key, value := range env {
+			t.Run(key+"="+value, func(t *testing.T) {
+				t.Setenv(key, value)
+				if _, err := loadConfig(); err == nil {
+					t.Fatalf("%s=%q accepted", key, value)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Trade is an executed order with the owner stripped, for market-wide feeds
+type Trade struct {
+	Symbol    string    `json:"symbol"`
+	Side      string    `json:"side"`
+	Quantity  float64   `json:"quantity"`
+	Price     float64   `json:"price"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// getRecentTrades returns the most recent trades across all users and
+// symbols, newest first, a page at a time. Pending and cancelled orders
+// haven't traded, so only filled orders are listed.
+func (s *Server) getRecentTrades(c *gin.Context) {
+	page, ok := s.pagination.parsePage(c)
+	if !ok {
+		return
+	}
+
+	// Filled orders double as the trade tape
+	trades := []Trade{}
+	err := s.db.Model(&Order{}).
+		Select("symbol, side, quantity, price, timestamp").
+		Where("status = ?", orderStatusFilled).
+		Order("timestamp DESC, id DESC").
+		Limit(page.Limit).
+		Offset(page.Offset).
+		Scan(&trades).Error
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to fetch trades"})
+		return
+	}
+
+	c.JSON(200, trades)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// userSortColumns maps the ?sort= values of GET /api/admin/users to their
+// columns. A leading "-" sorts descending.
+var userSortColumns = map[string]string{
+	"id":         "id",
+	"username":   "username",
+	"role":       "role",
+	"created_at": "created_at",
+}
+
+// UserPage is one page of the user list
+type UserPage struct {
+	Users  []User `json:"users"`
+	Total  int64  `json:"total"` // Users matching the search, across all pages
+	Limit  int    `json:"limit"`
+	Offset int    `json:"offset"`
+}
+
+// UserDetail is a user together with a summary of their activity
+type UserDetail struct {
+	User
+
+	Orders         int64 `json:"orders"`      // Including archived orders
+	OpenOrders     int64 `json:"open_orders"` // Open or pending
+	ActiveSessions int64 `json:"active_sessions"`
+
+	// Entitlements is empty when the user may trade every symbol
+	Entitlements []string `json:"entitlements"`
+}
+
+// escapeLike escapes the LIKE wildcards in s, for use with ESCAPE '\'
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// listUsers returns a page of users, optionally filtered by a ?q= substring
+// of the username and ordered by ?sort= (default id)
+func (s *Server) listUsers(c *gin.Context) {
+	requested, ok := s.pagination.parsePage(c)
+	if !ok {
+		return
+	}
+	page := UserPage{Users: []User{}, Limit: requested.Limit, Offset: requested.Offset}
+
+	key, desc := strings.CutPrefix(c.DefaultQuery("sort", "id"), "-")
+	column, ok := userSortColumns[key]
+	if !ok {
+		c.JSON(400, gin.H{"error": "sort must be one of id, username, role or created_at, optionally prefixed with -"})
+		return
+	}
+	order := column + " ASC"
+	if desc {
+		order = column + " DESC"
+	}
+
+	query := s.db.Model(&User{})
+	if q := strings.TrimSpace(c.Query("q")); q != "" {
+		query = query.Where(`username LIKE ? ESCAPE '\'`, "%"+escapeLike(q)+"%")
+	}
+	if err := query.Count(&page.Total).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to fetch users"})
+		return
+	}
+	// Break ties on id so pages don't overlap
+	if err := query.Order(order).Order("id ASC").Limit(page.Limit).Offset(page.Offset).Find(&page.Users).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to fetch users"})
+		return
+	}
+
+	c.JSON(200, page)
+}
+
+// getUser returns one user with a summary of their orders, sessions and
+// entitlements
+func (s *Server) getUser(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil || id == 0 {
+		c.JSON(400, gin.H{"error": "Invalid user id"})
+		return
+	}
+
+	var users []User
+	if err := s.db.Where("id = ?", id).Limit(1).Find(&users).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to fetch user"})
+		return
+	}
+	if len(users) == 0 {
+		c.JSON(404, gin.H{"error": "User not found"})
+		return
+	}
+
+	detail := UserDetail{User: users[0], Entitlements: []string{}}
+	if err := s.db.Model(&Order{}).Where("user_id = ?", id).Count(&detail.Orders).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to fetch orders"})
+		return
+	}
+	if err := s.db.Model(&Order{}).Where("user_id = ? AND status IN ?", id, workingOrderStatuses).Count(&detail.OpenOrders).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to fetch orders"})
+		return
+	}
+	if err := s.db.Model(&Session{}).Where("user_id = ? AND expires_at > ?", id, time.Now()).Count(&detail.ActiveSessions).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to fetch sessions"})
+		return
+	}
+
+	entitled, err := s.entitledSymbols(detail.ID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to fetch entitlements"})
+		return
+	}
+	for symbol := range entitled {
+		detail.Entitlements = append(detail.Entitlements, symbol)
+	}
+	sort.Strings(detail.Entitlements)
+
+	c.JSON(200, detail)
+}
@@ -0,0 +1,420 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	minUsernameLength = 3
+	maxUsernameLength = 32
+)
+
+// maxClientOrderIDLength bounds client-supplied order references
+const maxClientOrderIDLength = 64
+
+// Length limits, in characters, for order annotations
+const (
+	maxOrderTagLength  = 32
+	maxOrderNoteLength = 500
+)
+
+// clientOrderIDPattern limits client order ids to URL- and log-safe characters
+var clientOrderIDPattern = regexp.MustCompile(`^[A-Za-z0-9_.:-]+$`)
+
+// Order sides. Short and cover are only accepted with SHORT_SELLING; a
+// short sells shares the user doesn't hold and a cover buys them back.
+const (
+	sideBuy   = "buy"
+	sideSell  = "sell"
+	sideShort = "short"
+	sideCover = "cover"
+)
+
+// Order types. Market orders fill at the bid or ask; limit orders at their
+// own price.
+const (
+	orderTypeMarket = "market"
+	orderTypeLimit  = "limit"
+)
+
+// sideAliases maps the accepted spellings of an order side, lower-cased, to
+// the canonical side stored on orders
+var sideAliases = map[string]string{
+	"buy":   sideBuy,
+	"b":     sideBuy,
+	"sell":  sideSell,
+	"s":     sideSell,
+	"short": sideShort,
+	"cover": sideCover,
+}
+
+// sideBuys reports whether an order on side buys shares (a buy or a cover)
+// rather than selling them (a sell or a short)
+func sideBuys(side string) bool {
+	return side == sideBuy || side == sideCover
+}
+
+// usernamePattern limits usernames to letters, digits, dots, dashes and underscores
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// defaultUsernameDenylist holds names that would let a user pass as staff
+var defaultUsernameDenylist = []string{"admin", "administrator", "root", "superuser", "system", "support", "moderator"}
+
+// UsernamePolicy describes which usernames new accounts may take
+type UsernamePolicy struct {
+	// Pattern is the allowed charset, and PatternMessage the error shown
+	// for usernames that don't match it
+	Pattern        *regexp.Regexp
+	PatternMessage string
+
+	// Denylist holds reserved names, lower-cased
+	Denylist map[string]bool
+}
+
+// defaultUsernamePolicy allows usernamePattern and reserves the default names
+func defaultUsernamePolicy() UsernamePolicy {
+	policy := UsernamePolicy{
+		Pattern:        usernamePattern,
+		PatternMessage: "Username may only contain letters, digits, '.', '-' and '_'",
+		Denylist:       make(map[string]bool),
+	}
+	for _, name := range defaultUsernameDenylist {
+		policy.Denylist[name] = true
+	}
+	return policy
+}
+
+// loadUsernamePolicy reads the username rules from the environment.
+// USERNAME_DENYLIST replaces the default reserved names.
+func loadUsernamePolicy() (UsernamePolicy, error) {
+	policy := defaultUsernamePolicy()
+
+	if raw := strings.TrimSpace(os.Getenv("USERNAME_PATTERN")); raw != "" {
+		pattern, err := regexp.Compile(raw)
+		if err != nil {
+			return policy, fmt.Errorf("USERNAME_PATTERN is not a valid regular expression: %v", err)
+		}
+		policy.Pattern = pattern
+		policy.PatternMessage = "Username contains characters that aren't allowed"
+	}
+
+	if raw, ok := os.LookupEnv("USERNAME_DENYLIST"); ok {
+		policy.Denylist = make(map[string]bool)
+		for _, name := range strings.Split(raw, ",") {
+			if name = usernameKey(name); name != "" {
+				policy.Denylist[name] = true
+			}
+		}
+	}
+
+	return policy, nil
+}
+
+// usernameKey is the form usernames are compared in, so "Admin" and
+// "admin" are the same account while the display case is preserved
+func usernameKey(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+// PasswordPolicy describes the strength requirements for new passwords
+type PasswordPolicy struct {
+	MinLength     int
+	RequireDigit  bool
+	RequireUpper  bool
+	RequireLower  bool
+	RequireSymbol bool
+}
+
+// loadPasswordPolicy reads the password policy from the environment
+func loadPasswordPolicy() (PasswordPolicy, error) {
+	var policy PasswordPolicy
+	var err error
+
+	if policy.MinLength, err = envInt("PASSWORD_MIN_LENGTH", 6); err != nil {
+		return policy, err
+	}
+	if policy.MinLength < 1 {
+		return policy, fmt.Errorf("PASSWORD_MIN_LENGTH must be at least 1")
+	}
+	if policy.RequireDigit, err = envBool("PASSWORD_REQUIRE_DIGIT", false); err != nil {
+		return policy, err
+	}
+	if policy.RequireUpper, err = envBool("PASSWORD_REQUIRE_UPPER", false); err != nil {
+		return policy, err
+	}
+	if policy.RequireLower, err = envBool("PASSWORD_REQUIRE_LOWER", false); err != nil {
+		return policy, err
+	}
+	if policy.RequireSymbol, err = envBool("PASSWORD_REQUIRE_SYMBOL", false); err != nil {
+		return policy, err
+	}
+
+	return policy, nil
+}
+
+// check returns a message describing every requirement the password misses,
+// or an empty string if it satisfies the policy
+func (p PasswordPolicy) check(password string) string {
+	var hasDigit, hasUpper, hasLower, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r):
+			hasSymbol = true
+		}
+	}
+
+	var problems []string
+	if len(password) < p.MinLength {
+		problems = append(problems, fmt.Sprintf("be at least %d characters", p.MinLength))
+	}
+	if p.RequireDigit && !hasDigit {
+		problems = append(problems, "contain a digit")
+	}
+	if p.RequireUpper && !hasUpper {
+		problems = append(problems, "contain an uppercase letter")
+	}
+	if p.RequireLower && !hasLower {
+		problems = append(problems, "contain a lowercase letter")
+	}
+	if p.RequireSymbol && !hasSymbol {
+		problems = append(problems, "contain a symbol")
+	}
+
+	if len(problems) == 0 {
+		return ""
+	}
+	return "Password must " + strings.Join(problems, ", ")
+}
+
+// validateSignup checks every field of a signup request and returns a
+// message per invalid field; the map is empty when the request is valid
+func (s *Server) validateSignup(req SignupRequest) map[string]string {
+	errs := make(map[string]string)
+
+	switch {
+	case req.Username == "":
+		errs["username"] = "Username is required"
+	case len(req.Username) < minUsernameLength || len(req.Username) > maxUsernameLength:
+		errs["username"] = fmt.Sprintf("Username must be between %d and %d characters", minUsernameLength, maxUsernameLength)
+	case !s.usernamePolicy.Pattern.MatchString(req.Username):
+		errs["username"] = s.usernamePolicy.PatternMessage
+	case s.usernamePolicy.Denylist[usernameKey(req.Username)]:
+		errs["username"] = "Username is reserved, please choose another"
+	}
+
+	if msg := s.passwordPolicy.check(req.Password); msg != "" {
+		errs["password"] = msg
+	}
+
+	return errs
+}
+
+// Reject codes returned as "code" alongside the error message when an order
+// or cancel is refused, so clients can react without parsing the message
+const (
+	orderCodeUnknownSymbol   = "UNKNOWN_SYMBOL"
+	orderCodeNotTradable     = "NOT_TRADABLE"
+	orderCodeInvalidSide     = "INVALID_SIDE"
+	orderCodeInvalidQuantity = "INVALID_QUANTITY"
+	orderCodeInvalidPrice    = "INVALID_PRICE"
+	orderCodeInvalidType     = "INVALID_ORDER_TYPE"
+	orderCodeTooPrecise      = "PRICE_TOO_PRECISE"
+	orderCodeInvalidClientID = "INVALID_CLIENT_ORDER_ID"
+	orderCodeInvalidTag      = "INVALID_TAG"
+	orderCodeInvalidNote     = "INVALID_NOTE"
+	orderCodePriceOutOfBand  = "PRICE_OUT_OF_BAND"
+	orderCodeBelowMinimum    = "BELOW_MIN_NOTIONAL"
+	orderCodeNotEntitled     = "NOT_ENTITLED"
+	orderCodeDuplicateOrder  = "DUPLICATE_CLIENT_ORDER_ID"
+	orderCodeNoPosition      = "INSUFFICIENT_POSITION"
+	orderCodeDailyLimit      = "DAILY_LIMIT"
+	orderCodeOpenLimit       = "OPEN_ORDER_LIMIT"
+	orderCodeSymbolThrottled = "SYMBOL_THROTTLED"
+	orderCodeMaintenance     = "MAINTENANCE"
+	orderCodeLiveUnavailable = "LIVE_TRADING_UNAVAILABLE"
+	orderCodeAccountTooNew   = "ACCOUNT_TOO_NEW"
+	orderCodeOrderNotFound   = "ORDER_NOT_FOUND"
+	orderCodeVersionConflict = "VERSION_CONFLICT"
+	orderCodeOrderNotWorking = "ORDER_NOT_WORKING"
+	orderCodeInternal        = "INTERNAL"
+)
+
+// orderError is a rejected order request along with the HTTP status and
+// reject code to use
+type orderError struct {
+	Status  int
+	Code    string
+	Message string
+
+	// RetryAt is set when the order was rate limited and may be retried later
+	RetryAt time.Time
+}
+
+func (e *orderError) Error() string {
+	return e.Message
+}
+
+// validateOrder checks an order request against the tracked stocks, which
+// must be tradable. It normalizes the side, fills in the quote for market
+// orders, and in the rounding modes rounds the quantity to the symbol's
+// precision and snaps the price to its tick, in place. Limit prices outside
+// the symbol's price band are rejected unless the request is forced.
+func (s *Server) validateOrder(req *OrderRequest) *orderError {
+	req.Symbol = s.resolveSymbol(req.Symbol)
+	stock, ok := s.lookupStock(req.Symbol)
+	if !ok {
+		return &orderError{Status: 400, Code: orderCodeUnknownSymbol, Message: "Unknown symbol"}
+	}
+	if !stock.Tradable {
+		return &orderError{Status: 409, Code: orderCodeNotTradable, Message: fmt.Sprintf("%s is not tradable", stock.Symbol)}
+	}
+
+	side, ok := sideAliases[strings.ToLower(strings.TrimSpace(req.Side))]
+	if (side == sideShort || side == sideCover) && !s.shortSelling {
+		ok = false
+	}
+	if !ok {
+		if s.shortSelling {
+			return &orderError{Status: 400, Code: orderCodeInvalidSide, Message: "Side must be 'buy', 'sell', 'short' or 'cover'"}
+		}
+		return &orderError{Status: 400, Code: orderCodeInvalidSide, Message: "Side must be 'buy' or 'sell'"}
+	}
+	req.Side = side
+
+	if req.Quantity <= 0 {
+		return &orderError{Status: 400, Code: orderCodeInvalidQuantity, Message: "Quantity must be positive"}
+	}
+
+	if rounded := roundDecimals(req.Quantity, stock.QuantityDecimals); rounded != req.Quantity {
+		if !s.roundQuantity {
+			return &orderError{Status: 400, Code: orderCodeInvalidQuantity, Message: fmt.Sprintf("Quantity for %s may have at most %d decimal places", stock.Symbol, stock.QuantityDecimals)}
+		}
+		req.Quantity = rounded
+		if req.Quantity <= 0 {
+			return &orderError{Status: 400, Code: orderCodeInvalidQuantity, Message: "Quantity must be positive"}
+		}
+	}
+
+	if !onTick(req.Quantity, s.qtyIncrement) {
+		return &orderError{Status: 400, Code: orderCodeInvalidQuantity, Message: fmt.Sprintf("Quantity must be a multiple of %g", s.qtyIncrement)}
+	}
+
+	if id := req.ClientOrderID; id != nil {
+		if len(*id) == 0 || len(*id) > maxClientOrderIDLength {
+			return &orderError{Status: 400, Code: orderCodeInvalidClientID, Message: fmt.Sprintf("client_order_id must be between 1 and %d characters", maxClientOrderIDLength)}
+		}
+		if !clientOrderIDPattern.MatchString(*id) {
+			return &orderError{Status: 400, Code: orderCodeInvalidClientID, Message: "client_order_id may only contain letters, digits, '.', ':', '-' and '_'"}
+		}
+	}
+
+	req.Tag = strings.TrimSpace(req.Tag)
+	if n := utf8.RuneCountInString(req.Tag); n > maxOrderTagLength {
+		return &orderError{Status: 400, Code: orderCodeInvalidTag, Message: fmt.Sprintf("tag may be at most %d characters", maxOrderTagLength)}
+	}
+	if strings.ContainsFunc(req.Tag, unicode.IsControl) {
+		return &orderError{Status: 400, Code: orderCodeInvalidTag, Message: "tag may not contain control characters"}
+	}
+	req.Note = strings.TrimSpace(req.Note)
+	if n := utf8.RuneCountInString(req.Note); n > maxOrderNoteLength {
+		return &orderError{Status: 400, Code: orderCodeInvalidNote, Message: fmt.Sprintf("note may be at most %d characters", maxOrderNoteLength)}
+	}
+
+	// Market orders are priced by the server, so any price sent is ignored
+	switch strings.ToLower(strings.TrimSpace(req.Type)) {
+	case "":
+	case orderTypeMarket:
+		req.Price = 0
+	case orderTypeLimit:
+		if req.Price <= 0 {
+			return &orderError{Status: 400, Code: orderCodeInvalidPrice, Message: "Limit orders need a positive price"}
+		}
+	default:
+		return &orderError{Status: 400, Code: orderCodeInvalidType, Message: "type must be 'market' or 'limit'"}
+	}
+	req.Type = orderTypeLimit
+	if req.Price == 0 {
+		req.Type = orderTypeMarket
+	}
+
+	if req.Price < 0 {
+		return &orderError{Status: 400, Code: orderCodeInvalidPrice, Message: "Price must be positive"}
+	}
+
+	// An omitted price makes a market order, which fills at the ask for a
+	// buy or cover and the bid for a sell or short. Quotes are always on the
+	// tick.
+	if req.Price == 0 {
+		if sideBuys(req.Side) {
+			req.Price = stock.Ask
+		} else {
+			req.Price = stock.Bid
+		}
+		return checkMinNotional(stock, *req)
+	}
+
+	// Checked before the tick so that rounding to the tick can't hide a
+	// price the client got wrong
+	if roundDecimals(req.Price, s.priceDecimals) != req.Price {
+		return &orderError{Status: 422, Code: orderCodeTooPrecise, Message: fmt.Sprintf("Price may have at most %d decimal places", s.priceDecimals)}
+	}
+
+	if !onTick(req.Price, stock.TickSize) {
+		if !s.roundToTick {
+			return &orderError{Status: 400, Code: orderCodeInvalidPrice, Message: fmt.Sprintf("Price must be a multiple of the tick size %g", stock.TickSize)}
+		}
+		req.Price = snapToTick(req.Price, stock.TickSize)
+		if req.Price <= 0 {
+			return &orderError{Status: 400, Code: orderCodeInvalidPrice, Message: "Price must be positive"}
+		}
+	}
+
+	// Catch fat-fingered limit prices far from the market unless forced
+	if band := stock.PriceBandPercent; band > 0 && !req.Force {
+		if math.Abs(req.Price-stock.Price) > stock.Price*band/100 {
+			return &orderError{Status: 422, Code: orderCodePriceOutOfBand, Message: fmt.Sprintf("Price %.*f is more than %g%% away from the market price %.*f; send force to place it anyway", stock.PriceDecimals, req.Price, band, stock.PriceDecimals, stock.Price)}
+		}
+	}
+
+	return checkMinNotional(stock, *req)
+}
+
+// checkMinNotional rejects dust orders worth less than the symbol's minimum
+// notional at the order's price
+func checkMinNotional(stock Stock, req OrderRequest) *orderError {
+	if stock.MinNotional <= 0 {
+		return nil
+	}
+	// Allow for float error in quantity * price landing just under the minimum
+	if notional := req.Quantity * req.Price; notional < stock.MinNotional-tickEpsilon {
+		return &orderError{Status: 422, Code: orderCodeBelowMinimum, Message: fmt.Sprintf("Order value %.*f %s is below the minimum of %.*f %s for %s", stock.PriceDecimals, notional, stock.Currency, stock.PriceDecimals, stock.MinNotional, stock.Currency, stock.Symbol)}
+	}
+	return nil
+}
+
+// tickEpsilon absorbs floating point error when comparing values to ticks
+const tickEpsilon = 1e-6
+
+// onTick reports whether value is a whole multiple of tick
+func onTick(value, tick float64) bool {
+	steps := value / tick
+	return math.Abs(steps-math.Round(steps)) < tickEpsilon
+}
+
+// snapToTick rounds price to the nearest multiple of tick
+func snapToTick(price, tick float64) float64 {
+	return trimFloat(math.Round(price/tick) * tick)
+}
@@ -0,0 +1,510 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMinNotionalBoundary(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      map[string]string
+		symbol   string
+		quantity float64
+		price    float64
+		accepted bool
+	}{
+		// MIN_ORDER_NOTIONAL is in USD: 100 for INFY, 8320 INR for TCS
+		{"INFY at the minimum", nil, "INFY", 5, 20, true},
+		{"INFY a tick under", nil, "INFY", 5, 19.99, false},
+		{"INFY a lot under", nil, "INFY", 4.999, 20, false},
+		{"TCS at the minimum", nil, "TCS", 1, 8320, true},
+		{"TCS a tick under", nil, "TCS", 1, 8319.95, false},
+		{"TCS over the minimum", nil, "TCS", 3, 2773.35, true},
+
+		// MIN_NOTIONALS is in the symbol's own currency
+		{"TCS override at the minimum", map[string]string{"MIN_NOTIONALS": "TCS:500"}, "TCS", 1, 500, true},
+		{"TCS override a tick under", map[string]string{"MIN_NOTIONALS": "TCS:500"}, "TCS", 1, 499.95, false},
+		{"INFY unaffected by the TCS override", map[string]string{"MIN_NOTIONALS": "TCS:500"}, "INFY", 1, 99.99, false},
+	}
+	for _, tt := range tests {
+		env := map[string]string{"MIN_ORDER_NOTIONAL": "100"}
+		for key, value := range tt.env {
+			env[key] = value
+		}
+		s := newTestServer(t, env)
+
+		req := OrderRequest{Symbol: tt.symbol, Side: sideBuy, Type: orderTypeLimit, Quantity: tt.quantity, Price: tt.price}
+		err := s.validateOrder(&req)
+		switch {
+		case tt.accepted && err != nil:
+			t.Errorf("%s: rejected: %s", tt.name, err.Message)
+		case !tt.accepted && err == nil:
+			t.Errorf("%s: accepted", tt.name)
+		case !tt.accepted && err.Code != orderCodeBelowMinimum:
+			t.Errorf("%s: code = %s, want %s", tt.name, err.Code, orderCodeBelowMinimum)
+		}
+	}
+}
+
+func TestSignupReportsEveryInvalidField(t *testing.T) {
+	_, r := newTestRouter(t, map[string]string{
+		"PASSWORD_MIN_LENGTH":    "10",
+		"PASSWORD_REQUIRE_DIGIT": "true",
+	})
+
+	tests := []struct {
+		name   string
+		body   string
+		errors map[string]string
+	}{
+		{
+			"both invalid",
+			`{"username":"a!","password":"short"}`,
+			map[string]string{
+				"username": fmt.Sprintf("Username must be between %d and %d characters", minUsernameLength, maxUsernameLength),
+				"password": "Password must be at least 10 characters, contain a digit",
+			},
+		},
+		{
+			"username only",
+			`{"username":"bad name","password":"long-enough-1"}`,
+			map[string]string{"username": defaultUsernamePolicy().PatternMessage},
+		},
+		{
+			"password only",
+			`{"username":"trader","password":"long-enough"}`,
+			map[string]string{"password": "Password must contain a digit"},
+		},
+		{
+			"missing",
+			`{}`,
+			map[string]string{
+				"username": "Username is required",
+				"password": "Password must be at least 10 characters, contain a digit",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := doRequest(r, "POST", "/api/signup", "", tt.body)
+			var body struct {
+				Errors map[string]string `json:"errors"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); w.Code != 422 || err != nil {
+				t.Fatalf("status %d: %s", w.Code, w.Body)
+			}
+			if len(body.Errors) != len(tt.errors) {
+				t.Fatalf("errors = %v, want %v", body.Errors, tt.errors)
+			}
+			for field, want := range tt.errors {
+				if body.Errors[field] != want {
+					t.Errorf("errors[%s] = %q, want %q", field, body.Errors[field], want)
+				}
+			}
+		})
+	}
+}
+
+// signupError posts a signup and returns the 422 message for field, or ""
+// if the signup wasn't refused with one
+func signupError(t *testing.T, h http.Handler, username, field string) string {
+	t.Helper()
+	body, _ := json.Marshal(SignupRequest{Username: username, Password: "Correct-Horse-9"})
+	w := doRequest(h, "POST", "/api/signup", "", string(body))
+	var resp struct {
+		Errors map[string]string `json:"errors"`
+	}
+	if w.Code != 422 || json.Unmarshal(w.Body.Bytes(), &resp) != nil {
+		return ""
+	}
+	return resp.Errors[field]
+}
+
+func TestReservedAndInvalidUsernames(t *testing.T) {
+	_, r := newTestRouter(t, nil)
+	reserved := "Username is reserved, please choose another"
+
+	for _, username := range []string{"admin", "Admin", "  ROOT  ", "Support"} {
+		if got := signupError(t, r, username, "username"); got != reserved {
+			t.Errorf("%q: error %q, want %q", username, got, reserved)
+		}
+	}
+	for _, username := range []string{"bad name", "émile", "semi;colon", "<script>"} {
+		if got := signupError(t, r, username, "username"); got != defaultUsernamePolicy().PatternMessage {
+			t.Errorf("%q: error %q, want the charset message", username, got)
+		}
+	}
+
+	// Usernames differing only in case are the same account, but keep the
+	// case they were signed up with
+	_, user := signUp(t, r, "Trader.Joe")
+	if user.Username != "Trader.Joe" {
+		t.Errorf("username stored as %q", user.Username)
+	}
+	if w := doRequest(r, "POST", "/api/signup", "", `{"username":"trader.joe","password":"Correct-Horse-9"}`); w.Code != 400 {
+		t.Errorf("signing up again in lower case: status %d: %s", w.Code, w.Body)
+	}
+	if w := doRequest(r, "POST", "/api/login", "", `{"username":"TRADER.JOE","password":"Correct-Horse-9"}`); w.Code != 200 {
+		t.Errorf("logging in in upper case: status %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestUsernamePolicyConfig(t *testing.T) {
+	_, r := newTestRouter(t, map[string]string{
+		"USERNAME_DENYLIST": "Bob, mallory",
+		"USERNAME_PATTERN":  `^[A-Za-z]+$`,
+	})
+
+	// The configured list replaces the default one
+	for _, username := range []string{"bob", "MALLORY"} {
+		if got := signupError(t, r, username, "username"); got != "Username is reserved, please choose another" {
+			t.Errorf("%q: error %q, want reserved", username, got)
+		}
+	}
+	signUp(t, r, "root")
+
+	if got := signupError(t, r, "with.dot", "username"); got != "Username contains characters that aren't allowed" {
+		t.Errorf("with.dot: error %q, want the pattern message", got)
+	}
+
+	t.Setenv("USERNAME_PATTERN", "[")
+	if _, err := loadConfig(); err == nil {
+		t.Error("invalid USERNAME_PATTERN accepted")
+	}
+}
+
+func TestOrderSideAliases(t *testing.T) {
+	tests := []struct {
+		side  string
+		want  string // "" when the side is rejected
+		short bool   // SHORT_SELLING on
+	}{
+		{"buy", sideBuy, false},
+		{"BUY", sideBuy, false},
+		{"Buy", sideBuy, false},
+		{"b", sideBuy, false},
+		{"B", sideBuy, false},
+		{" sell ", sideSell, false},
+		{"SELL", sideSell, false},
+		{"S", sideSell, false},
+		{"short", "", false},
+		{"SHORT", sideShort, true},
+		{"Cover", sideCover, true},
+		{"", "", false},
+		{"bu", "", false},
+		{"purchase", "", false},
+		{"x", "", true},
+		{"buy sell", "", false},
+	}
+	servers := map[bool]*Server{
+		false: newTestServer(t, nil),
+		true:  newTestServer(t, map[string]string{"SHORT_SELLING": "true"}),
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%q", tt.side), func(t *testing.T) {
+			req := OrderRequest{Symbol: "AAPL", Side: tt.side, Quantity: 1}
+			err := servers[tt.short].validateOrder(&req)
+			if tt.want == "" {
+				if err == nil || err.Code != orderCodeInvalidSide {
+					t.Fatalf("error = %v, want %s", err, orderCodeInvalidSide)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("rejected: %s", err.Message)
+			}
+			if req.Side != tt.want {
+				t.Fatalf("side normalized to %q, want %q", req.Side, tt.want)
+			}
+		})
+	}
+}
+
+func TestOrderSideAliasesStoredCanonical(t *testing.T) {
+	s, r := newTestRouter(t, nil)
+	user := createTestUser(t, s, "trader", roleUser)
+	_, token := createTestSession(t, s, user)
+
+	w := doRequest(r, "POST", "/api/orders", token, `{"symbol":"AAPL","side":"B","quantity":1}`)
+	var order Order
+	if err := json.Unmarshal(w.Body.Bytes(), &order); w.Code != 201 || err != nil {
+		t.Fatalf("REST order: status %d: %s", w.Code, w.Body)
+	}
+	if order.Side != sideBuy {
+		t.Errorf("REST order side = %q, want buy", order.Side)
+	}
+
+	conn := dialTestWebSocket(t, newTestWebSocketServer(t, s), token)
+	if err := conn.WriteJSON(clientMessage{Action: "order", Order: &OrderRequest{Symbol: "AAPL", Side: "SELL", Quantity: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		var ack orderAckMessage
+		if err := conn.ReadJSON(&ack); err != nil {
+			t.Fatalf("waiting for the ack: %v", err)
+		}
+		if ack.Type == "order_ack" {
+			if ack.Order.Side != sideSell {
+				t.Errorf("WebSocket order side = %q, want sell", ack.Order.Side)
+			}
+			break
+		}
+	}
+
+	var sides []string
+	s.db.Model(&Order{}).Where("user_id = ?", user.ID).Order("number").Pluck("side", &sides)
+	if len(sides) != 2 || sides[0] != sideBuy || sides[1] != sideSell {
+		t.Errorf("stored sides = %v, want [buy sell]", sides)
+	}
+}
+
+func TestLimitPriceBand(t *testing.T) {
+	s, r := newTestRouter(t, map[string]string{"PRICE_BAND_PERCENT": "20", "PRICE_BANDS": "TSLA:50,AMZN:0"})
+	tests := []struct {
+		name   string
+		symbol string
+		price  float64 // 0 for a market order
+		force  bool
+		ok     bool
+	}{
+		{"in band", "AAPL", 200, false, true},
+		{"above band", "AAPL", 211, false, false},
+		{"below band", "AAPL", 140, false, false},
+		{"forced above", "AAPL", 300, true, true},
+		{"forced below", "AAPL", 1, true, true},
+		{"market order", "AAPL", 0, false, true},
+		{"symbol band in", "TSLA", 360, false, true},
+		{"symbol band out", "TSLA", 370, false, false},
+		{"symbol band off", "AMZN", 1000, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := OrderRequest{Symbol: tt.symbol, Side: sideBuy, Quantity: 1, Price: tt.price, Force: tt.force}
+			err := s.validateOrder(&req)
+			if tt.ok && err != nil {
+				t.Fatalf("rejected: %s", err.Message)
+			}
+			if !tt.ok && (err == nil || err.Status != 422 || err.Code != orderCodePriceOutOfBand) {
+				t.Fatalf("error = %v, want 422 %s", err, orderCodePriceOutOfBand)
+			}
+		})
+	}
+
+	_, token := createTestSession(t, s, createTestUser(t, s, "trader", roleUser))
+	w := doRequest(r, "POST", "/api/orders", token, `{"symbol":"AAPL","side":"buy","quantity":1,"price":500}`)
+	if w.Code != 422 || !jsonHasCode(w.Body.Bytes(), orderCodePriceOutOfBand) {
+		t.Errorf("out-of-band REST order: status %d: %s", w.Code, w.Body)
+	}
+	if w := doRequest(r, "POST", "/api/orders", token, `{"symbol":"AAPL","side":"buy","quantity":1,"price":500,"force":true}`); w.Code != 201 {
+		t.Errorf("forced REST order: status %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestLimitPriceBandOffByDefault(t *testing.T) {
+	s := newTestServer(t, nil)
+	req := OrderRequest{Symbol: "AAPL", Side: sideBuy, Quantity: 1, Price: 1000}
+	if err := s.validateOrder(&req); err != nil {
+		t.Fatalf("rejected without a band: %s", err.Message)
+	}
+}
+
+func TestOrderRejectCodes(t *testing.T) {
+	s, r := newTestRouter(t, map[string]string{
+		"SHORT_SELLING":      "true",
+		"MIN_ORDER_NOTIONAL": "10",
+		"PRICE_BAND_PERCENT": "50",
+	})
+	_, adminToken := createTestSession(t, s, seededAdmin(t, s))
+	if w := doRequest(r, "POST", "/api/admin/symbols/TSLA/trading", adminToken, `{"tradable":false}`); w.Code != 200 {
+		t.Fatalf("halting TSLA: status %d: %s", w.Code, w.Body)
+	}
+	user := createTestUser(t, s, "trader", roleUser)
+	_, token := createTestSession(t, s, user)
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		code   string
+	}{
+		{"unknown symbol", `{"symbol":"ZZZZ","side":"buy","quantity":1}`, 400, orderCodeUnknownSymbol},
+		{"halted symbol", `{"symbol":"TSLA","side":"buy","quantity":1}`, 409, orderCodeNotTradable},
+		{"bad side", `{"symbol":"AAPL","side":"hold","quantity":1}`, 400, orderCodeInvalidSide},
+		{"zero quantity", `{"symbol":"AAPL","side":"buy","quantity":0}`, 400, orderCodeInvalidQuantity},
+		{"negative quantity", `{"symbol":"AAPL","side":"buy","quantity":-1}`, 400, orderCodeInvalidQuantity},
+		{"off tick", `{"symbol":"AAPL","side":"buy","quantity":1,"price":175.505}`, 400, orderCodeInvalidPrice},
+		{"limit without price", `{"symbol":"AAPL","side":"buy","quantity":1,"type":"limit"}`, 400, orderCodeInvalidPrice},
+		{"bad type", `{"symbol":"AAPL","side":"buy","quantity":1,"type":"stop"}`, 400, orderCodeInvalidType},
+		{"too precise", `{"symbol":"AAPL","side":"buy","quantity":1,"price":175.12345}`, 422, orderCodeTooPrecise},
+		{"bad client order id", `{"symbol":"AAPL","side":"buy","quantity":1,"client_order_id":"a b"}`, 400, orderCodeInvalidClientID},
+		{"long tag", `{"symbol":"AAPL","side":"buy","quantity":1,"tag":"` + strings.Repeat("t", maxOrderTagLength+1) + `"}`, 400, orderCodeInvalidTag},
+		{"long note", `{"symbol":"AAPL","side":"buy","quantity":1,"note":"` + strings.Repeat("n", maxOrderNoteLength+1) + `"}`, 400, orderCodeInvalidNote},
+		{"out of band", `{"symbol":"AAPL","side":"buy","quantity":1,"price":50}`, 422, orderCodePriceOutOfBand},
+		{"below minimum", `{"symbol":"INFY","side":"buy","quantity":0.1}`, 422, orderCodeBelowMinimum},
+		{"nothing to sell", `{"symbol":"AAPL","side":"sell","quantity":1}`, 409, orderCodeNoPosition},
+		{"nothing to cover", `{"symbol":"AAPL","side":"cover","quantity":1}`, 409, orderCodeNoPosition},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := doRequest(r, "POST", "/api/orders", token, tt.body)
+			if w.Code != tt.status || !jsonHasCode(w.Body.Bytes(), tt.code) {
+				t.Fatalf("status %d: %s; want %d %s", w.Code, w.Body, tt.status, tt.code)
+			}
+		})
+	}
+
+	// Cancels have codes of their own
+	filled := createFilledOrder(t, s, user.ID, "AAPL", sideBuy, 1, 175.5)
+	cancels := []struct {
+		name   string
+		number uint
+		status int
+		code   string
+	}{
+		{"no such order", 999, 404, orderCodeOrderNotFound},
+		{"already filled", filled.Number, 409, orderCodeOrderNotWorking},
+	}
+	for _, tt := range cancels {
+		t.Run(tt.name, func(t *testing.T) {
+			w := doRequest(r, "POST", fmt.Sprintf("/api/orders/%d/cancel", tt.number), token, `{"version":1}`)
+			if w.Code != tt.status || !jsonHasCode(w.Body.Bytes(), tt.code) {
+				t.Fatalf("status %d: %s; want %d %s", w.Code, w.Body, tt.status, tt.code)
+			}
+		})
+	}
+}
+
+func TestLimitPriceDecimals(t *testing.T) {
+	tests := []struct {
+		name  string
+		env   map[string]string
+		price float64
+		want  float64 // 0 when rejected as too precise
+	}{
+		{"default allows four", map[string]string{"TICK_SIZES": "AAPL:0.0001"}, 150.1234, 150.1234},
+		{"default rejects five", map[string]string{"TICK_SIZES": "AAPL:0.0001"}, 150.12345, 0},
+		{"whole prices always fit", map[string]string{"LIMIT_PRICE_DECIMALS": "0"}, 150, 150},
+		{"custom rejects past it", map[string]string{"LIMIT_PRICE_DECIMALS": "1"}, 150.12, 0},
+		{"custom allows up to it", map[string]string{"LIMIT_PRICE_DECIMALS": "1"}, 150.1, 150.1},
+		{"rounding within limit", map[string]string{"TICK_SIZE_MODE": "round"}, 150.123, 150.12},
+		{"rounding is no way round it", map[string]string{"TICK_SIZE_MODE": "round"}, 150.12345, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, tt.env)
+			req := OrderRequest{Symbol: "AAPL", Side: sideBuy, Quantity: 1, Price: tt.price}
+			err := s.validateOrder(&req)
+			if tt.want == 0 {
+				if err == nil || err.Status != 422 || err.Code != orderCodeTooPrecise {
+					t.Fatalf("price %v: error = %v, want 422 %s", tt.price, err, orderCodeTooPrecise)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("price %v rejected: %s", tt.price, err.Message)
+			}
+			if req.Price != tt.want {
+				t.Fatalf("price = %v, want %v", req.Price, tt.want)
+			}
+		})
+	}
+}
+
+func TestLimitPriceDecimalsResponse(t *testing.T) {
+	s, r := newTestRouter(t, nil)
+	_, token := createTestSession(t, s, createTestUser(t, s, "trader", roleUser))
+
+	w := doRequest(r, "POST", "/api/orders", token, `{"symbol":"AAPL","side":"buy","quantity":1,"price":150.12345}`)
+	if w.Code != 422 || !jsonHasCode(w.Body.Bytes(), orderCodeTooPrecise) {
+		t.Fatalf("status %d: %s, want 422 %s", w.Code, w.Body, orderCodeTooPrecise)
+	}
+
+	// Market orders are priced by the server, so the decimals sent don't matter
+	if w := doRequest(r, "POST", "/api/orders", token, `{"symbol":"AAPL","side":"buy","quantity":1,"type":"market","price":150.12345}`); w.Code != 201 {
+		t.Fatalf("market order: status %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestLimitPriceDecimalsConfig(t *testing.T) {
+	for _, value := range []string{"-1", "9", "four"} {
+		t.Run(value, func(t *testing.T) {
+			t.Setenv("LIMIT_PRICE_DECIMALS", value)
+			if _, err := loadConfig(); err == nil {
+				t.Fatalf("LIMIT_PRICE_DECIMALS=%s accepted", value)
+			}
+		})
+	}
+}
+
+func TestOrderTypes(t *testing.T) {
+	s := newTestServer(t, nil)
+	aapl, _ := s.lookupStock("AAPL")
+	tests := []struct {
+		name  string
+		typ   string
+		side  string
+		price float64
+		want  float64 // Price once validated
+		code  string  // Reject code, "" when accepted
+	}{
+		{"implicit market", "", sideBuy, 0, aapl.Ask, ""},
+		{"implicit limit", "", sideBuy, 170, 170, ""},
+		{"market buy without price", "market", sideBuy, 0, aapl.Ask, ""},
+		{"market sell without price", "market", sideSell, 0, aapl.Bid, ""},
+		{"market ignores price", "market", sideBuy, 170, aapl.Ask, ""},
+		{"market ignores negative price", "market", sideBuy, -1, aapl.Ask, ""},
+		{"limit", "limit", sideBuy, 170, 170, ""},
+		{"type is case-insensitive", " LIMIT ", sideBuy, 170, 170, ""},
+		{"limit without price", "limit", sideBuy, 0, 0, orderCodeInvalidPrice},
+		{"limit with negative price", "limit", sideBuy, -1, 0, orderCodeInvalidPrice},
+		{"unknown type", "stop", sideBuy, 170, 0, orderCodeInvalidType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := OrderRequest{Symbol: "AAPL", Side: tt.side, Quantity: 1, Price: tt.price, Type: tt.typ}
+			err := s.validateOrder(&req)
+			if tt.code != "" {
+				if err == nil || err.Status != 400 || err.Code != tt.code {
+					t.Fatalf("error = %v, want 400 %s", err, tt.code)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("rejected: %s", err.Message)
+			}
+			if req.Price != tt.want {
+				t.Fatalf("price = %v, want %v", req.Price, tt.want)
+			}
+		})
+	}
+}
+
+func TestOrderTypeOverREST(t *testing.T) {
+	s, r := newTestRouter(t, nil)
+	_, token := createTestSession(t, s, createTestUser(t, s, "trader", roleUser))
+	aapl, _ := s.lookupStock("AAPL")
+
+	w := doRequest(r, "POST", "/api/orders", token, `{"symbol":"AAPL","side":"buy","quantity":1,"type":"market"}`)
+	var order Order
+	if err := json.Unmarshal(w.Body.Bytes(), &order); w.Code != 201 || err != nil {
+		t.Fatalf("market order: status %d: %s", w.Code, w.Body)
+	}
+	if order.Price != aapl.Ask {
+		t.Fatalf("market order filled at %v, want the ask %v", order.Price, aapl.Ask)
+	}
+
+	w = doRequest(r, "POST", "/api/orders", token, `{"symbol":"AAPL","side":"buy","quantity":1,"type":"limit","price":0}`)
+	if w.Code != 400 || !jsonHasCode(w.Body.Bytes(), orderCodeInvalidPrice) {
+		t.Fatalf("limit order at 0: status %d: %s, want 400 %s", w.Code, w.Body, orderCodeInvalidPrice)
+	}
+	w = doRequest(r, "POST", "/api/orders", token, `{"symbol":"AAPL","side":"buy","quantity":1,"type":"stop","price":170}`)
+	if w.Code != 400 || !jsonHasCode(w.Body.Bytes(), orderCodeInvalidType) {
+		t.Fatalf("stop order: status %d: %s, want 400 %s", w.Code, w.Body, orderCodeInvalidType)
+	}
+}
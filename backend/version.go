@@ -0,0 +1,25 @@
+package main
+
+import (
+	"runtime"
+
+	"github.com/gin-gonic/gin"
+)
+
+// orUnknown substitutes a placeholder for build info that wasn't injected
+func orUnknown(v string) string {
+	if v == "" {
+		return "unknown"
+	}
+	return v
+}
+
+// getVersion returns the build info of the running binary
+func getVersion(c *gin.Context) {
+	c.JSON(200, gin.H{
+		"version":    orUnknown(version),
+		"commit":     orUnknown(commit),
+		"build_time": orUnknown(buildTime),
+		"go_version": runtime.Version(),
+	})
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Webhook delivery settings
+const (
+	webhookTimeout      = 5 * time.Second
+	webhookMaxAttempts  = 5
+	webhookRetryBackoff = time.Second // Doubles after each failed attempt
+	maxWebhookURLLength = 2048
+)
+
+// Webhook is a user's endpoint for order notifications. Secret signs every
+// delivery so the receiver can verify it came from this server.
+type Webhook struct {
+	ID        uint      `gorm:"primaryKey" json:"-"`
+	UserID    uint      `gorm:"not null;uniqueIndex" json:"-"`
+	URL       string    `gorm:"not null" json:"url"`
+	Secret    string    `gorm:"not null" json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// WebhookRequest sets the authenticated user's webhook URL
+type WebhookRequest struct {
+	URL string `json:"url"`
+}
+
+// webhookEvent is the JSON body delivered to a webhook
+type webhookEvent struct {
+	Event     string `json:"event"` // "order.filled"
+	Order     Order  `json:"order"`
+	Timestamp int64  `json:"timestamp"` // Unix seconds, also sent in X-Webhook-Timestamp
+}
+
+// errBlockedAddress is returned for webhook targets on internal networks
+var errBlockedAddress = errors.New("webhook address is not publicly routable")
+
+// cgnatRange is the carrier-grade NAT block, which net.IP doesn't classify
+var cgnatRange = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// publicIP reports whether ip is a publicly routable unicast address
+func publicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || cgnatRange.Contains(ip))
+}
+
+// newWebhookClient builds the HTTP client used for deliveries. Unless
+// allowPrivate is set, it refuses to connect to internal addresses at dial
+// time, which also covers DNS that changes after the URL was validated.
+func newWebhookClient(allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: webhookTimeout}
+	if !allowPrivate {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+				return errBlockedAddress
+			}
+			return nil
+		}
+	}
+
+	return &http.Client{
+		Timeout:   webhookTimeout,
+		Transport: &http.Transport{DialContext: dialer.DialContext, Proxy: nil},
+		// A redirect could point anywhere, so deliveries never follow one
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// validateWebhookURL checks that raw is an absolute http(s) URL whose host
+// resolves only to public addresses
+func (s *Server) validateWebhookURL(ctx context.Context, raw string) error {
+	if len(raw) > maxWebhookURLLength {
+		return fmt.Errorf("URL must be at most %d characters", maxWebhookURLLength)
+	}
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return errors.New("URL must be an absolute http or https URL")
+	}
+	if u.User != nil {
+		return errors.New("URL must not contain credentials")
+	}
+	if s.webhookAllowPrivate {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, webhookTimeout)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, u.Hostname())
+	if err != nil || len(addrs) == 0 {
+		return errors.New("URL host could not be resolved")
+	}
+	for _, addr := range addrs {
+		if !publicIP(addr.IP) {
+			return errBlockedAddress
+		}
+	}
+	return nil
+}
+
+// newWebhookSecret returns a random hex-encoded signing secret
+func newWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// signWebhook returns the hex HMAC-SHA256 of "<timestamp>.<body>". Signing
+// the timestamp lets receivers reject replays of old deliveries.
+func signWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// setWebhook creates or replaces the authenticated user's webhook. A new
+// secret is generated every time and returned only in this response.
+func (s *Server) setWebhook(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(401, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var req WebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.URL == "" {
+		c.JSON(400, gin.H{"error": "Invalid request"})
+		return
+	}
+	if err := s.validateWebhookURL(c.Request.Context(), req.URL); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid webhook URL: " + err.Error()})
+		return
+	}
+
+	secret, err := newWebhookSecret()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to save webhook"})
+		return
+	}
+
+	var hook Webhook
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Limit(1).Find(&hook).Error; err != nil {
+			return err
+		}
+		hook.UserID = userID.(uint)
+		hook.URL = req.URL
+		hook.Secret = secret
+		return tx.Save(&hook).Error
+	})
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to save webhook"})
+		return
+	}
+
+	c.JSON(200, gin.H{"url": hook.URL, "secret": secret})
+}
+
+// getWebhook returns the authenticated user's webhook without its secret
+func (s *Server) getWebhook(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(401, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var hook Webhook
+	if err := s.db.Where("user_id = ?", userID).First(&hook).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(404, gin.H{"error": "No webhook configured"})
+			return
+		}
+		c.JSON(500, gin.H{"error": "Failed to fetch webhook"})
+		return
+	}
+
+	c.JSON(200, hook)
+}
+
+// deleteWebhook removes the authenticated user's webhook
+func (s *Server) deleteWebhook(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(401, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	if err := s.db.Where("user_id = ?", userID).Delete(&Webhook{}).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to delete webhook"})
+		return
+	}
+	c.Status(204)
+}
+
+// notifyOrderFilled delivers an order.filled event to the order owner's
+// webhook, if they have one, without holding up the caller
+func (s *Server) notifyOrderFilled(order Order) {
+	go func() {
+		// Find rather than First: most users have no webhook, which isn't an error
+		var hooks []Webhook
+		if err := s.db.Where("user_id = ?", order.UserID).Limit(1).Find(&hooks).Error; err != nil {
+			log.Printf("Error loading webhook for user %d: %v", order.UserID, err)
+			return
+		}
+		if len(hooks) == 0 {
+			return
+		}
+		hook := hooks[0]
+
+		now := time.Now()
+		body, err := json.Marshal(webhookEvent{Event: "order.filled", Order: order, Timestamp: now.Unix()})
+		if err != nil {
+			log.Printf("Error encoding webhook event: %v", err)
+			return
+		}
+		s.deliverWebhook(hook, body, strconv.FormatInt(now.Unix(), 10))
+	}()
+}
+
+// deliverWebhook POSTs a signed body to the webhook, retrying with backoff
+// on network errors and non-2xx responses
+func (s *Server) deliverWebhook(hook Webhook, body []byte, timestamp string) {
+	signature := "sha256=" + signWebhook(hook.Secret, timestamp, body)
+	backoff := s.webhookBackoff
+
+	for attempt := 1; attempt <= webhookMaxAttempts; attempt++ {
+		req, err := http.NewRequest("POST", hook.URL, bytes.NewReader(body))
+		if err != nil {
+			log.Printf("Invalid webhook URL for user %d: %v", hook.UserID, err)
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Webhook-Timestamp", timestamp)
+		req.Header.Set("X-Webhook-Signature", signature)
+
+		resp, err := s.webhookClient.Do(req)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+				return
+			}
+			err = fmt.Errorf("status %d", resp.StatusCode)
+		}
+
+		if attempt == webhookMaxAttempts {
+			log.Printf("Giving up on webhook for user %d after %d attempts: %v", hook.UserID, attempt, err)
+			return
+		}
+		log.Printf("Webhook for user %d failed on attempt %d: %v; retrying in %s", hook.UserID, attempt, err, backoff)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookDelivery is one request a stub receiver got
+type webhookDelivery struct {
+	Timestamp string
+	Signature string
+	Body      []byte
+}
+
+// stubReceiver records webhook deliveries, answering each with the next of
+// statuses and then 200
+type stubReceiver struct {
+	*httptest.Server
+	mu         sync.Mutex
+	statuses   []int
+	deliveries []webhookDelivery
+	received   chan struct{}
+}
+
+func newStubReceiver(t *testing.T, statuses ...int) *stubReceiver {
+	t.Helper()
+	r := &stubReceiver{statuses: statuses, received: make(chan struct{}, 16)}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		r.mu.Lock()
+		r.deliveries = append(r.deliveries, webhookDelivery{
+			Timestamp: req.Header.Get("X-Webhook-Timestamp"),
+			Signature: req.Header.Get("X-Webhook-Signature"),
+			Body:      body,
+		})
+		status := 200
+		if len(r.statuses) > 0 {
+			status, r.statuses = r.statuses[0], r.statuses[1:]
+		}
+		r.mu.Unlock()
+		w.WriteHeader(status)
+		r.received <- struct{}{}
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+// wait blocks until n more deliveries arrived
+func (r *stubReceiver) wait(t *testing.T, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		select {
+		case <-r.received:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("got %d of %d deliveries", i, n)
+		}
+	}
+}
+
+// setTestWebhook points the user's webhook at url and returns its secret
+func setTestWebhook(t *testing.T, h http.Handler, token, url string) string {
+	t.Helper()
+	w := doRequest(h, "POST", "/api/webhooks", token, `{"url":"`+url+`"}`)
+	if w.Code != 200 {
+		t.Fatalf("setting webhook: status %d: %s", w.Code, w.Body)
+	}
+	var resp struct {
+		Secret string `json:"secret"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp.Secret
+}
+
+func TestWebhookSignedDelivery(t *testing.T) {
+	s, r := newTestRouter(t, map[string]string{"WEBHOOK_ALLOW_PRIVATE": "true"})
+	user := createTestUser(t, s, "trader", roleUser)
+	_, token := createTestSession(t, s, user)
+	receiver := newStubReceiver(t)
+	secret := setTestWebhook(t, r, token, receiver.URL)
+
+	order, err := s.placeOrder(user.ID, false, OrderRequest{Symbol: "AAPL", Side: sideBuy, Quantity: 2})
+	if err != nil {
+		t.Fatalf("placeOrder: %s", err.Message)
+	}
+	receiver.wait(t, 1)
+
+	delivery := receiver.deliveries[0]
+	if want := "sha256=" + signWebhook(secret, delivery.Timestamp, delivery.Body); delivery.Signature != want {
+		t.Fatalf("signature = %s, want %s", delivery.Signature, want)
+	}
+	if delivery.Signature == "sha256="+signWebhook("wrong-secret", delivery.Timestamp, delivery.Body) {
+		t.Fatal("signature doesn't depend on the secret")
+	}
+
+	var event struct {
+		Event     string `json:"event"`
+		Timestamp int64  `json:"timestamp"`
+		Order     struct {
+			Number uint   `json:"number"`
+			Symbol string `json:"symbol"`
+		} `json:"order"`
+	}
+	if err := json.Unmarshal(delivery.Body, &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.Event != "order.filled" || event.Order.Number != order.Number || event.Order.Symbol != "AAPL" {
+		t.Fatalf("event = %+v", event)
+	}
+	if strconv.FormatInt(event.Timestamp, 10) != delivery.Timestamp {
+		t.Fatalf("body timestamp %d doesn't match header %s", event.Timestamp, delivery.Timestamp)
+	}
+}
+
+func TestWebhookRetriesFailedDeliveries(t *testing.T) {
+	s := newTestServer(t, map[string]string{"WEBHOOK_ALLOW_PRIVATE": "true"})
+	s.webhookBackoff = time.Millisecond
+	receiver := newStubReceiver(t, 500, 503)
+	hook := Webhook{UserID: 1, URL: receiver.URL, Secret: "secret"}
+
+	s.deliverWebhook(hook, []byte(`{}`), "1700000000")
+	receiver.wait(t, 3)
+	if len(receiver.deliveries) != 3 {
+		t.Fatalf("%d attempts, want 3", len(receiver.deliveries))
+	}
+
+	// Every attempt carries the same signed timestamp
+	for _, delivery := range receiver.deliveries {
+		if delivery.Timestamp != "1700000000" || delivery.Signature != receiver.deliveries[0].Signature {
+			t.Fatalf("attempt differs: %+v", delivery)
+		}
+	}
+}
+
+func TestWebhookGivesUpAfterMaxAttempts(t *testing.T) {
+	s := newTestServer(t, map[string]string{"WEBHOOK_ALLOW_PRIVATE": "true"})
+	s.webhookBackoff = time.Millisecond
+	statuses := make([]int, webhookMaxAttempts+1)
+	for i := range statuses {
+		statuses[i] = 500
+	}
+	receiver := newStubReceiver(t, statuses...)
+
+	s.deliverWebhook(Webhook{UserID: 1, URL: receiver.URL, Secret: "secret"}, []byte(`{}`), "1700000000")
+	if len(receiver.deliveries) != webhookMaxAttempts {
+		t.Fatalf("%d attempts, want %d", len(receiver.deliveries), webhookMaxAttempts)
+	}
+}
+
+func TestWebhookRefusesInternalAddresses(t *testing.T) {
+	s, r := newTestRouter(t, nil)
+	user := createTestUser(t, s, "trader", roleUser)
+	_, token := createTestSession(t, s, user)
+	receiver := newStubReceiver(t)
+
+	// The stub listens on loopback, which webhooks may not target
+	if w := doRequest(r, "POST", "/api/webhooks", token, `{"url":"`+receiver.URL+`"}`); w.Code != 400 {
+		t.Fatalf("status = %d, want 400", w.Code)
+	}
+
+	// Nor can a delivery reach it, whatever DNS said when it was saved
+	s.webhookBackoff = time.Millisecond
+	s.deliverWebhook(Webhook{UserID: user.ID, URL: receiver.URL, Secret: "secret"}, []byte(`{}`), "1700000000")
+	if len(receiver.deliveries) != 0 {
+		t.Fatalf("%d deliveries reached an internal address", len(receiver.deliveries))
+	}
+}
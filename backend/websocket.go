@@ -0,0 +1,381 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// clientSendBuffer is how many messages may queue for a WebSocket client
+	// before it is considered too slow and disconnected
+	clientSendBuffer = 16
+
+	// writeWait is the time allowed to write a message to a WebSocket client
+	writeWait = 10 * time.Second
+)
+
+// Client is a connected WebSocket client and its per-connection state. Only
+// the client's writer goroutine writes to conn; everything else queues
+// messages on send.
+type Client struct {
+	conn *websocket.Conn
+	send chan []byte
+
+	// claims identifies the user; nil for anonymous connections
+	claims *tokenClaims
+
+	// protocol is the negotiated subprotocol, which decides how price
+	// snapshots are encoded
+	protocol string
+
+	// subscriptions holds the symbols the client receives prices for; nil
+	// means every symbol
+	mu            sync.Mutex
+	subscriptions map[string]bool
+
+	// candles holds the candle streams the client follows; guarded by mu
+	candles map[candleKey]bool
+}
+
+// newClient wraps an upgraded connection
+func newClient(conn *websocket.Conn, claims *tokenClaims, subscriptions map[string]bool) *Client {
+	return &Client{
+		conn:          conn,
+		send:          make(chan []byte, clientSendBuffer),
+		claims:        claims,
+		protocol:      connProtocol(conn),
+		subscriptions: subscriptions,
+	}
+}
+
+// clientMessage is a command sent by a WebSocket client
+type clientMessage struct {
+	Action  string        `json:"action"`
+	Order   *OrderRequest `json:"order,omitempty"`
+	Symbols []string      `json:"symbols,omitempty"`
+
+	// Symbol and Interval pick a candle stream
+	Symbol   string `json:"symbol,omitempty"`
+	Interval string `json:"interval,omitempty"`
+}
+
+// orderAckMessage confirms an order placed over the WebSocket
+type orderAckMessage struct {
+	Type  string `json:"type"` // "order_ack"
+	Order Order  `json:"order"`
+}
+
+// errorMessage reports a failed WebSocket command back to the client
+type errorMessage struct {
+	Type    string      `json:"type"` // "error"
+	Action  string      `json:"action,omitempty"`
+	Error   string      `json:"error"`
+	Code    string      `json:"code,omitempty"`
+	Field   string      `json:"field,omitempty"` // The offending field of a malformed message
+	Status  int         `json:"status,omitempty"`
+	Details interface{} `json:"details,omitempty"`
+
+	// ClientOrderID echoes the rejected order's client reference, if any
+	ClientOrderID *string `json:"client_order_id,omitempty"`
+}
+
+// websocketToken returns the JWT offered during the WebSocket handshake.
+// Browsers can't set headers on WebSocket requests, so a ?token= query
+// parameter is accepted as well as the usual Bearer header.
+func websocketToken(c *gin.Context) string {
+	if token := c.Query("token"); token != "" {
+		return token
+	}
+	if header := c.GetHeader("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		return strings.TrimPrefix(header, "Bearer ")
+	}
+	return ""
+}
+
+// handleWebSocket handles WebSocket connections
+func (s *Server) handleWebSocket(c *gin.Context) {
+	// A token is optional unless prices require auth; authenticated
+	// connections may also place orders
+	var claims *tokenClaims
+	tokenString := websocketToken(c)
+	if tokenString == "" {
+		tokenString = s.cookieToken(c)
+
+		// Another site's page would send the cookie too; only the origins
+		// allowed to use it may open a socket with it
+		if tokenString != "" && !s.originAllowed(c.Request) {
+			c.JSON(403, gin.H{"error": "Origin not allowed"})
+			return
+		}
+	}
+	if tokenString != "" {
+		parsed, err := s.authenticate(tokenString)
+		if errors.Is(err, errSessionLookup) {
+			writeSessionLookupError(c)
+			return
+		}
+		if err != nil {
+			code, message := tokenErrorCode(err)
+			c.JSON(401, gin.H{"error": message, "code": code})
+			return
+		}
+		claims = &parsed
+	}
+	if claims == nil && s.pricesRequireAuth {
+		c.JSON(401, gin.H{"error": "Authentication required", "code": authCodeMissing})
+		return
+	}
+
+	// ?symbols=AAPL,TSLA limits the prices sent, as for the event stream
+	filter, err := s.parseSymbolFilter(c.Query("symbols"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Unknown symbol in symbols filter"})
+		return
+	}
+
+	// Clients pick the message format with a subprotocol such as stocks.v2
+	if !s.acceptsSubprotocol(websocket.Subprotocols(c.Request)) {
+		c.JSON(400, gin.H{"error": "Unsupported WebSocket subprotocol", "supported": s.upgrader.Subprotocols})
+		return
+	}
+
+	conn, err := s.upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		log.Printf("WebSocket upgrade error: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	// Only takes effect if the client negotiated permessage-deflate
+	if err := conn.SetCompressionLevel(s.wsCompressionLevel); err != nil {
+		log.Printf("Error setting WebSocket compression level: %v", err)
+	}
+
+	// Queue the initial snapshot so the writer goroutine delivers it first,
+	// then register the client and start its writer together
+	client := newClient(conn, claims, filter)
+	snapshot := s.currentPrices()
+	if msg, ok := snapshot.encoded[client.protocol]; ok && filter == nil {
+		client.send <- msg
+	} else if msg, err := encodePrices(filterPrices(snapshot.prices, filter), client.protocol); err == nil {
+		client.send <- msg
+	} else {
+		log.Printf("Error encoding prices: %v", err)
+	}
+	if s.maintenance.Load() {
+		if msg, err := json.Marshal(maintenanceNotice{Type: "maintenance", Enabled: true}); err == nil {
+			client.send <- msg
+		}
+	}
+	if s.simulatorPaused.Load() {
+		if msg, err := json.Marshal(simulatorNotice{Type: "simulator", Running: false}); err == nil {
+			client.send <- msg
+		}
+	}
+	s.registerClient(client)
+
+	// Keep connection alive and handle client messages
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			break
+		}
+		s.handleClientMessage(client, data)
+	}
+
+	s.unregisterClient(client)
+}
+
+// registerClient adds a client to the broadcast set and starts its writer
+func (s *Server) registerClient(client *Client) {
+	s.clientsLock.Lock()
+	defer s.clientsLock.Unlock()
+
+	s.clients[client] = struct{}{}
+	go client.writePump()
+}
+
+// writePump is the only goroutine that writes to the client's connection.
+// It drains the send channel until the channel is closed or a write fails.
+func (c *Client) writePump() {
+	for msg := range c.send {
+		c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+		if err := c.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+			log.Printf("Error writing to client: %v", err)
+			// Closing the connection ends the read loop, which unregisters it
+			c.conn.Close()
+			return
+		}
+	}
+}
+
+// unregisterClient removes a client and closes its send channel, stopping
+// its writer. It is safe to call more than once.
+func (s *Server) unregisterClient(client *Client) {
+	s.clientsLock.Lock()
+	defer s.clientsLock.Unlock()
+
+	if _, ok := s.clients[client]; ok {
+		delete(s.clients, client)
+		close(client.send)
+	}
+}
+
+// broadcast queues an encoded message for every connected client
+func (s *Server) broadcast(msg []byte) {
+	s.broadcastEach(func(*Client) []byte { return msg })
+}
+
+// broadcastEach queues the message built by encode for every connected
+// client; a nil message skips that client
+func (s *Server) broadcastEach(encode func(*Client) []byte) {
+	s.clientsLock.Lock()
+	defer s.clientsLock.Unlock()
+
+	for client := range s.clients {
+		msg := encode(client)
+		if msg == nil {
+			continue
+		}
+		select {
+		case client.send <- msg:
+		default:
+			// The client isn't keeping up; drop it rather than block everyone
+			log.Printf("Dropping slow WebSocket client %s", client.conn.RemoteAddr())
+			delete(s.clients, client)
+			close(client.send)
+			client.conn.Close()
+		}
+	}
+}
+
+// handleClientMessage dispatches one command read from a client
+func (s *Server) handleClientMessage(client *Client, data []byte) {
+	var msg clientMessage
+	if err := decodeStrict(data, &msg); err != nil {
+		s.queueMessage(client, errorMessage{Type: "error", Error: err.Message, Code: requestCodeInvalid, Field: err.Field, Status: 400})
+		return
+	}
+
+	switch msg.Action {
+	case "order":
+		s.handleOrderMessage(client, msg)
+	case "subscribe":
+		s.handleSubscribeMessage(client, msg)
+	case "unsubscribe":
+		s.handleUnsubscribeMessage(client, msg)
+	case "subscriptions":
+		s.sendSubscriptions(client)
+	case "subscribe_candles":
+		s.handleSubscribeCandlesMessage(client, msg)
+	case "unsubscribe_candles":
+		s.handleUnsubscribeCandlesMessage(client, msg)
+	case "candle_subscriptions":
+		s.sendCandleStreams(client)
+	default:
+		s.queueMessage(client, errorMessage{Type: "error", Action: msg.Action, Error: "Unknown action", Status: 400})
+	}
+}
+
+// handleOrderMessage places an order through the same path as createOrder
+func (s *Server) handleOrderMessage(client *Client, msg clientMessage) {
+	claims := client.claims
+	if claims == nil {
+		s.queueMessage(client, errorMessage{Type: "error", Action: msg.Action, Error: "Authentication required", Status: 401})
+		return
+	}
+	if claims.ImpersonatedBy != 0 {
+		s.queueMessage(client, errorMessage{Type: "error", Action: msg.Action, Error: impersonationReadOnlyMessage, Code: authCodeReadOnly, Status: 403})
+		return
+	}
+	if s.maintenance.Load() {
+		s.queueMessage(client, errorMessage{Type: "error", Action: msg.Action, Error: maintenanceMessage, Code: orderCodeMaintenance, Status: 503})
+		return
+	}
+	if msg.Order == nil {
+		s.queueMessage(client, errorMessage{Type: "error", Action: msg.Action, Error: "Missing order", Status: 400})
+		return
+	}
+
+	order, err := s.placeOrder(claims.UserID, claims.Role == roleAdmin, *msg.Order)
+	if err != nil {
+		reply := errorMessage{Type: "error", Action: msg.Action, Error: err.Message, Code: err.Code, Status: err.Status, ClientOrderID: msg.Order.ClientOrderID}
+		if !err.RetryAt.IsZero() {
+			reply.Details = gin.H{"reset_at": err.RetryAt}
+		}
+		s.queueMessage(client, reply)
+		return
+	}
+
+	s.queueMessage(client, orderAckMessage{Type: "order_ack", Order: order})
+}
+
+// queueMessage encodes v and queues it on the client's send channel. The
+// message is dropped if the client is gone or its queue is full.
+func (s *Server) queueMessage(client *Client, v interface{}) {
+	msg, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("Error encoding WebSocket message: %v", err)
+		return
+	}
+
+	// Holding the read lock keeps the channel from being closed under us
+	s.clientsLock.RLock()
+	defer s.clientsLock.RUnlock()
+
+	if _, ok := s.clients[client]; !ok {
+		return
+	}
+	select {
+	case client.send <- msg:
+	default:
+		log.Printf("Dropping message for slow WebSocket client %s", client.conn.RemoteAddr())
+	}
+}
+
+// notifyUser queues a message for every WebSocket connection authenticated
+// as userID
+func (s *Server) notifyUser(userID uint, v interface{}) {
+	msg, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("Error encoding WebSocket message: %v", err)
+		return
+	}
+
+	s.clientsLock.RLock()
+	defer s.clientsLock.RUnlock()
+
+	for client := range s.clients {
+		if client.claims == nil || client.claims.UserID != userID {
+			continue
+		}
+		select {
+		case client.send <- msg:
+		default:
+			log.Printf("Dropping message for slow WebSocket client %s", client.conn.RemoteAddr())
+		}
+	}
+}
+
+// disconnectSession closes the WebSocket connections authenticated with the
+// session's token, once it has been revoked
+func (s *Server) disconnectSession(tokenID string) {
+	s.clientsLock.Lock()
+	defer s.clientsLock.Unlock()
+
+	for client := range s.clients {
+		if client.claims == nil || client.claims.TokenID != tokenID {
+			continue
+		}
+		delete(s.clients, client)
+		close(client.send)
+		client.conn.Close()
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+// WebSocket subprotocols, one per message format version
+const (
+	// wsProtocolV1 sends price snapshots as a bare array. Connections that
+	// don't ask for a subprotocol get this legacy format.
+	wsProtocolV1 = "stocks.v1"
+
+	// wsProtocolV2 wraps price snapshots in a typed message like every
+	// other message: {"type": "prices", "prices": [...]}
+	wsProtocolV2 = "stocks.v2"
+)
+
+// knownWSProtocols are the supported subprotocols, in the order the server
+// prefers them when a client offers several
+var knownWSProtocols = []string{wsProtocolV2, wsProtocolV1}
+
+// pricesMessage is a price snapshot in the stocks.v2 format
+type pricesMessage struct {
+	Type   string  `json:"type"` // "prices"
+	Prices []Stock `json:"prices"`
+}
+
+// loadWSProtocols reads the accepted subprotocols from WS_PROTOCOLS, in
+// order of preference, defaulting to every known one
+func loadWSProtocols() ([]string, error) {
+	raw := strings.TrimSpace(os.Getenv("WS_PROTOCOLS"))
+	if raw == "" {
+		return knownWSProtocols, nil
+	}
+
+	var protocols []string
+	for _, name := range strings.Split(raw, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !slices.Contains(knownWSProtocols, name) {
+			return nil, fmt.Errorf("WS_PROTOCOLS: unknown subprotocol %q, expected one of %s", name, strings.Join(knownWSProtocols, ", "))
+		}
+		if !slices.Contains(protocols, name) {
+			protocols = append(protocols, name)
+		}
+	}
+	return protocols, nil
+}
+
+// acceptsSubprotocol reports whether the handshake can be accepted: the
+// client must offer one of the enabled subprotocols, or none at all if the
+// legacy format is enabled
+func (s *Server) acceptsSubprotocol(offered []string) bool {
+	if len(offered) == 0 {
+		return slices.Contains(s.upgrader.Subprotocols, wsProtocolV1)
+	}
+	for _, protocol := range offered {
+		if slices.Contains(s.upgrader.Subprotocols, protocol) {
+			return true
+		}
+	}
+	return false
+}
+
+// connProtocol is the message format negotiated for a connection
+func connProtocol(conn *websocket.Conn) string {
+	if protocol := conn.Subprotocol(); protocol != "" {
+		return protocol
+	}
+	return wsProtocolV1
+}
+
+// encodePrices encodes a price snapshot in the given protocol's format
+func encodePrices(prices []Stock, protocol string) ([]byte, error) {
+	if protocol == wsProtocolV2 {
+		return json.Marshal(pricesMessage{Type: "prices", Prices: prices})
+	}
+	return json.Marshal(prices)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// dialSubprotocols connects to srv's WebSocket offering protocols
+func dialSubprotocols(srv *httptest.Server, protocols ...string) (*websocket.Conn, int, error) {
+	dialer := websocket.Dialer{Subprotocols: protocols}
+	conn, resp, err := dialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", nil)
+	status := 0
+	if resp != nil {
+		status = resp.StatusCode
+	}
+	return conn, status, err
+}
+
+// readPrices reads the next message and decodes it as a price snapshot in
+// protocol's format
+func readPrices(t *testing.T, conn *websocket.Conn, protocol string) []Stock {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("reading prices: %v", err)
+	}
+
+	var prices []Stock
+	if protocol == wsProtocolV2 {
+		var msg pricesMessage
+		if err := json.Unmarshal(data, &msg); err != nil || msg.Type != "prices" {
+			t.Fatalf("v2 prices = %s, want a typed prices message", data)
+		}
+		prices = msg.Prices
+	} else if err := json.Unmarshal(data, &prices); err != nil {
+		t.Fatalf("v1 prices = %s, want a bare array", data)
+	}
+	if len(prices) == 0 {
+		t.Fatalf("no prices in %s", data)
+	}
+	return prices
+}
+
+func TestWebSocketSubprotocols(t *testing.T) {
+	s := newTestServer(t, nil)
+	srv := newTestWebSocketServer(t, s)
+
+	tests := []struct {
+		name    string
+		offered []string
+		want    string
+	}{
+		{"none is legacy v1", nil, wsProtocolV1},
+		{"v1", []string{wsProtocolV1}, wsProtocolV1},
+		{"v2", []string{wsProtocolV2}, wsProtocolV2},
+		{"server prefers v2", []string{wsProtocolV1, wsProtocolV2}, wsProtocolV2},
+		{"unknown ones skipped", []string{"stocks.v9", wsProtocolV1}, wsProtocolV1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, status, err := dialSubprotocols(srv, tt.offered...)
+			if err != nil {
+				t.Fatalf("dial: %v (status %d)", err, status)
+			}
+			defer conn.Close()
+			if got := connProtocol(conn); got != tt.want {
+				t.Fatalf("negotiated %q, want %q", got, tt.want)
+			}
+
+			// Both the snapshot and later broadcasts use the agreed format
+			readPrices(t, conn, tt.want)
+			s.broadcastPrices()
+			readPrices(t, conn, tt.want)
+		})
+	}
+
+	if _, status, err := dialSubprotocols(srv, "stocks.v9"); err == nil || status != 400 {
+		t.Errorf("unknown subprotocol: status %d, err %v; want 400", status, err)
+	}
+}
+
+func TestWebSocketProtocolsConfig(t *testing.T) {
+	s := newTestServer(t, map[string]string{"WS_PROTOCOLS": wsProtocolV2})
+	srv := newTestWebSocketServer(t, s)
+
+	// Without v1 enabled, clients must ask for a subprotocol
+	if _, status, err := dialSubprotocols(srv); err == nil || status != 400 {
+		t.Errorf("no subprotocol: status %d, err %v; want 400", status, err)
+	}
+	if _, status, err := dialSubprotocols(srv, wsProtocolV1); err == nil || status != 400 {
+		t.Errorf("disabled v1: status %d, err %v; want 400", status, err)
+	}
+	conn, status, err := dialSubprotocols(srv, wsProtocolV2)
+	if err != nil {
+		t.Fatalf("v2: %v (status %d)", err, status)
+	}
+	conn.Close()
+
+	t.Setenv("WS_PROTOCOLS", "stocks.v3")
+	if _, err := loadConfig(); err == nil {
+		t.Error("unknown WS_PROTOCOLS entry accepted")
+	}
+}
@@ -10,6 +10,7 @@ function App() {
   const [token, setToken] = useState(null)
   const [user, setUser] = useState(null)
   const [orders, setOrders] = useState([])
+  const [impersonatedBy, setImpersonatedBy] = useState(null)
 
   // Check for existing token on mount
   useEffect(() => {
@@ -22,6 +23,18 @@ function App() {
     }
   }, [])
 
+  // Check whether an admin is using this token to act as the user
+  useEffect(() => {
+    if (!token) return
+
+    fetch(`${API_URL}/api/me`, {
+      headers: { Authorization: `Bearer ${token}` },
+    })
+      .then((response) => (response.ok ? response.json() : null))
+      .then((profile) => setImpersonatedBy(profile?.impersonated_by || null))
+      .catch((error) => console.error('Error fetching profile:', error))
+  }, [token])
+
   // Fetch orders when authenticated
   const fetchOrders = async () => {
     if (!token) return
@@ -80,6 +93,11 @@ function App() {
 
   return (
     <div className="min-h-screen bg-gray-100">
+      {impersonatedBy && (
+        <div className="bg-yellow-400 text-yellow-900 text-center py-2 font-semibold">
+          Admin #{impersonatedBy} is viewing this account as {user?.username} (read-only)
+        </div>
+      )}
       <div className="container mx-auto px-4 py-8">
         <div className="flex justify-between items-center mb-8">
           <h1 className="text-4xl font-bold text-gray-800">
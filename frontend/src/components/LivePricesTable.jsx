@@ -8,7 +8,10 @@ function LivePricesTable() {
   const reconnectTimeoutRef = useRef(null)
 
   const setupWebSocket = () => {
-    const ws = new WebSocket(getWebSocketURL())
+    // Send the token so prices keep flowing when the server requires auth
+    const token = localStorage.getItem('token')
+    const url = token ? `${getWebSocketURL()}?token=${encodeURIComponent(token)}` : getWebSocketURL()
+    const ws = new WebSocket(url)
     wsRef.current = ws
 
     ws.onopen = () => {
@@ -21,7 +24,10 @@ function LivePricesTable() {
 
     ws.onmessage = (event) => {
       const newPrices = JSON.parse(event.data)
-      
+      // Price snapshots are arrays; other messages are typed notices
+      if (!Array.isArray(newPrices)) return
+
+
       // Store previous prices for comparison before updating
       setPrices((currentPrices) => {
         const newPrev = {}
@@ -75,6 +81,14 @@ function LivePricesTable() {
     return 'text-gray-700'
   }
 
+  const formatPrice = (stock, value) =>
+    new Intl.NumberFormat(undefined, {
+      style: 'currency',
+      currency: stock.currency || 'USD',
+      minimumFractionDigits: stock.price_decimals ?? 2,
+      maximumFractionDigits: stock.price_decimals ?? 2,
+    }).format(value)
+
   if (prices.length === 0) {
     return (
       <div className="text-center py-8 text-gray-500">
@@ -106,14 +120,19 @@ function LivePricesTable() {
                 <td className="px-6 py-4 whitespace-nowrap">
                   <div className="text-sm font-medium text-gray-900">
                     {stock.symbol}
+                    {stock.tradable === false && (
+                      <span className="ml-2 text-xs font-normal text-gray-500">
+                        Not tradable
+                      </span>
+                    )}
                   </div>
                 </td>
                 <td className="px-6 py-4 whitespace-nowrap text-right">
                   <div className={`text-sm font-semibold ${priceColor}`}>
-                    ${stock.price.toFixed(2)}
+                    {formatPrice(stock, stock.price)}
                     {change !== null && change !== 0 && (
                       <span className="ml-2 text-xs">
-                        {change > 0 ? '↑' : '↓'} {Math.abs(change).toFixed(2)}
+                        {change > 0 ? '↑' : '↓'} {formatPrice(stock, Math.abs(change))}
                       </span>
                     )}
                   </div>
@@ -49,7 +49,8 @@ const response = await fetch(url, {
         localStorage.setItem('user', JSON.stringify(data.user))
         onLogin(data.token, data.user)
       } else {
-        setError(data.error || (isSignup ? 'Signup failed' : 'Login failed'))
+        const fieldErrors = data.errors ? Object.values(data.errors).join('. ') : ''
+        setError(fieldErrors || data.error || (isSignup ? 'Signup failed' : 'Login failed'))
       }
     } catch (err) {
       setError('Error connecting to server')
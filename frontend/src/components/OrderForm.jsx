@@ -34,7 +34,7 @@ function OrderForm({ onOrderPlaced, token }) {
         body: JSON.stringify({
           symbol: formData.symbol.toUpperCase(),
           side: formData.side,
-          quantity: parseInt(formData.quantity),
+          quantity: parseFloat(formData.quantity),
           price: parseFloat(formData.price),
         }),
       })
@@ -109,8 +109,9 @@ function OrderForm({ onOrderPlaced, token }) {
           name="quantity"
           value={formData.quantity}
           onChange={handleChange}
-          placeholder="e.g., 10"
-          min="1"
+          placeholder="e.g., 10 or 0.5"
+          min="0.001"
+          step="0.001"
           required
           className="w-full px-3 py-2 border border-gray-300 rounded-md shadow-sm focus:outline-none focus:ring-2 focus:ring-blue-500 focus:border-blue-500"
         />
@@ -45,9 +45,9 @@ function OrdersTable({ orders }) {
             .slice()
             .reverse()
             .map((order) => (
-              <tr key={order.id} className="hover:bg-gray-50">
+              <tr key={order.number} className="hover:bg-gray-50">
                 <td className="px-6 py-4 whitespace-nowrap text-sm text-gray-900">
-                  {order.id}
+                  {order.number}
                 </td>
                 <td className="px-6 py-4 whitespace-nowrap">
                   <div className="text-sm font-medium text-gray-900">